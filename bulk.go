@@ -2,6 +2,7 @@ package shopify
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,17 +11,16 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gempages/go-helper/tracing"
 	"github.com/gempages/go-shopify-graphql-model/graph/model"
-	"github.com/getsentry/sentry-go"
 	jsoniter "github.com/json-iterator/go"
-	log "github.com/sirupsen/logrus"
 	"gopkg.in/guregu/null.v4"
 
 	"github.com/gempages/go-shopify-graphql/graphql"
+	"github.com/gempages/go-shopify-graphql/tracing"
 	"github.com/gempages/go-shopify-graphql/utils"
 )
 
@@ -30,15 +30,23 @@ const (
 )
 
 type BulkOperationService interface {
-	BulkQuery(ctx context.Context, query string, v interface{}) error
+	BulkQuery(ctx context.Context, query string, v interface{}, opts ...BulkQueryOption) error
+	BulkQueryStream(ctx context.Context, query string, parse func(r io.Reader) error, opts ...BulkQueryOption) (*BulkResult, error)
+	BulkMutate(ctx context.Context, mutation string, variables io.Reader) ([]BulkMutationResult, error)
 
 	PostBulkQuery(ctx context.Context, query string) (*string, error)
+	PostBulkMutation(ctx context.Context, mutation, stagedUploadPath string) (string, error)
 	GetCurrentBulkQuery(ctx context.Context) (*model.BulkOperation, error)
 	GetCurrentBulkQueryResultURL(ctx context.Context) (*string, error)
 	WaitForCurrentBulkQuery(ctx context.Context, interval time.Duration) (*model.BulkOperation, error)
 	ShouldGetBulkQueryResultURL(ctx context.Context, id *string) (*string, error)
 	CancelRunningBulkQuery(ctx context.Context) error
 	GetBulkQueryResult(ctx context.Context, id graphql.ID) (*model.BulkOperation, error)
+
+	GetBulkOperation(ctx context.Context, id string) (*model.BulkOperation, error)
+	WaitForBulkOperation(ctx context.Context, id string, interval time.Duration) (*model.BulkOperation, error)
+	CancelBulkOperation(ctx context.Context, id string) error
+	WaitForBulkResult(ctx context.Context, id string) (*BulkResult, error)
 }
 
 type BulkOperationServiceOp struct {
@@ -55,6 +63,10 @@ type mutationBulkOperationRunQueryCancel struct {
 	BulkOperationCancelResult model.BulkOperationCancelPayload `graphql:"bulkOperationCancel(id: $id)" json:"bulkOperationCancel"`
 }
 
+// ErrBulkOperationInProgress is wrapped by the errors of PostBulkQuery and PostBulkMutation when the shop already
+// runs as many bulk operations of the type as allowed.
+var ErrBulkOperationInProgress = errors.New("bulk operation already in progress")
+
 var gidRegex *regexp.Regexp
 
 func init() {
@@ -73,6 +85,11 @@ func (s *BulkOperationServiceOp) PostBulkQuery(ctx context.Context, query string
 	}
 	if len(m.BulkOperationRunQueryResult.UserErrors) > 0 {
 		userErrors, _ := json.MarshalIndent(m.BulkOperationRunQueryResult.UserErrors, "", "    ")
+		for _, userErr := range m.BulkOperationRunQueryResult.UserErrors {
+			if isBulkOperationInProgress(userErr.Code, userErr.Message) {
+				return nil, fmt.Errorf("error posting bulk query: %w: %s", ErrBulkOperationInProgress, userErrors)
+			}
+		}
 		return nil, fmt.Errorf("error posting bulk query: %s", userErrors)
 	}
 
@@ -80,12 +97,19 @@ func (s *BulkOperationServiceOp) PostBulkQuery(ctx context.Context, query string
 }
 
 func (s *BulkOperationServiceOp) GetCurrentBulkQuery(ctx context.Context) (*model.BulkOperation, error) {
+	return s.getCurrentBulkOperation(ctx, model.BulkOperationTypeQuery)
+}
+
+// getCurrentBulkOperation returns the last bulk operation of type opType started by the app
+func (s *BulkOperationServiceOp) getCurrentBulkOperation(ctx context.Context, opType model.BulkOperationType) (*model.BulkOperation, error) {
 	var q struct {
 		CurrentBulkOperation struct {
 			model.BulkOperation
-		}
+		} `graphql:"currentBulkOperation(type: $type)"`
 	}
-	err := s.client.gql.Query(ctx, &q, nil)
+	err := s.client.gql.Query(ctx, &q, map[string]interface{}{
+		"type": opType,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
 	}
@@ -96,144 +120,371 @@ func (s *BulkOperationServiceOp) GetCurrentBulkQueryResultURL(ctx context.Contex
 	return s.ShouldGetBulkQueryResultURL(ctx, nil)
 }
 
+// ShouldGetBulkQueryResultURL waits for the bulk query id, or the current one if id is nil, to finish and returns
+// the URL of its result, nil if the result is empty.
 func (s *BulkOperationServiceOp) ShouldGetBulkQueryResultURL(ctx context.Context, id *string) (*string, error) {
-	q, err := s.GetCurrentBulkQuery(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("getting current bulk operation: %w", err)
-	}
-
-	if id != nil && q.ID != *id {
-		return nil, fmt.Errorf("bulk operation ID doesn't match, got=%v, want=%v", q.ID, id)
+	if id == nil {
+		q, err := s.GetCurrentBulkQuery(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting current bulk operation: %w", err)
+		}
+		id = &q.ID
 	}
 
-	q, err = s.WaitForCurrentBulkQuery(ctx, 1*time.Second)
+	res, err := s.WaitForBulkResult(ctx, *id)
 	if err != nil {
-		return nil, fmt.Errorf("waiting for current bulk operation: %w", err)
-	}
-	if q.Status != model.BulkOperationStatusCompleted {
-		return nil, fmt.Errorf("bulk operation didn't complete, status=%s, error_code=%s", q.Status, q.ErrorCode)
+		return nil, err
 	}
-
-	if q.ErrorCode != nil && q.ErrorCode.String() != "" {
-		return nil, fmt.Errorf("bulk operation error: %s", q.ErrorCode)
+	if err = res.Err(); err != nil {
+		return nil, err
 	}
 
-	if q.ObjectCount == "0" {
+	if res.ObjectCount == 0 {
 		return nil, nil
 	}
 
-	if q.URL == nil {
+	if res.URL == "" {
 		return nil, fmt.Errorf("empty URL result")
 	}
 
-	return q.URL, nil
+	return &res.URL, nil
+}
+
+// observeBulkOperation reports the finished bulk operation q to the metrics hook.
+func (s *BulkOperationServiceOp) observeBulkOperation(ctx context.Context, q *model.BulkOperation) {
+	m := graphql.BulkOperationMetric{
+		Shop:      s.client.gql.Shop(),
+		Operation: utils.GetDescriptionFromQuery(q.Query),
+		Status:    q.Status.String(),
+	}
+	if q.CompletedAt != nil {
+		m.Duration = q.CompletedAt.Sub(q.CreatedAt)
+	}
+	m.ObjectCount, _ = strconv.ParseInt(q.ObjectCount, 10, 64)
+	s.client.gql.Metrics().ObserveBulkOperation(ctx, m)
 }
 
 func (s *BulkOperationServiceOp) WaitForCurrentBulkQuery(ctx context.Context, interval time.Duration) (*model.BulkOperation, error) {
-	q, err := s.GetCurrentBulkQuery(ctx)
+	return s.waitForCurrentBulkOperation(ctx, model.BulkOperationTypeQuery, interval)
+}
+
+// waitForCurrentBulkOperation polls the current bulk operation of type opType every interval until it is finished
+func (s *BulkOperationServiceOp) waitForCurrentBulkOperation(ctx context.Context, opType model.BulkOperationType, interval time.Duration) (*model.BulkOperation, error) {
+	q, err := s.getCurrentBulkOperation(ctx, opType)
 	if err != nil {
 		return q, fmt.Errorf("get current bulk query: %w", err)
 	}
 
-	for q.Status == model.BulkOperationStatusCreated || q.Status == model.BulkOperationStatusRunning || q.Status == model.BulkOperationStatusCanceling {
-		log.Debugf("Bulk operation is still %s...", q.Status)
-		span := sentry.StartSpan(ctx, "time.sleep")
-		span.Description = "interval"
-		time.Sleep(interval)
-		tracing.FinishSpan(span, ctx.Err())
-		ctx = span.Context()
+	for isBulkOperationRunning(q.Status) {
+		s.client.gql.Logger().DebugContext(ctx, "Bulk operation is still running", graphql.LogKeyOperation, "currentBulkOperation", "type", opType, "status", q.Status)
+		if err = s.sleep(ctx, interval); err != nil {
+			return q, err
+		}
 
-		q, err = s.GetCurrentBulkQuery(ctx)
+		q, err = s.getCurrentBulkOperation(ctx, opType)
 		if err != nil {
 			return q, fmt.Errorf("get current bulk query continously: %w", err)
 		}
 	}
-	log.Debugf("Bulk operation ready, latest status=%s", q.Status)
+	s.client.gql.Logger().DebugContext(ctx, "Bulk operation ready", graphql.LogKeyOperation, "currentBulkOperation", "type", opType, "status", q.Status)
 
 	return q, nil
 }
 
-func (s *BulkOperationServiceOp) CancelRunningBulkQuery(ctx context.Context) error {
-	q, err := s.GetCurrentBulkQuery(ctx)
+// GetBulkOperation returns the bulk operation id, of any type.
+func (s *BulkOperationServiceOp) GetBulkOperation(ctx context.Context, id string) (*model.BulkOperation, error) {
+	var q struct {
+		Node *struct {
+			model.BulkOperation `graphql:"... on BulkOperation"`
+		} `graphql:"node(id: $id)"`
+	}
+	err := s.client.gql.Query(ctx, &q, map[string]interface{}{
+		"id": id,
+	})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("query: %w", err)
 	}
+	if q.Node == nil || q.Node.ID == "" {
+		return nil, fmt.Errorf("bulk operation %s not found", id)
+	}
+	return &q.Node.BulkOperation, nil
+}
 
-	if q.Status == model.BulkOperationStatusCreated || q.Status == model.BulkOperationStatusRunning {
-		log.Debugln("Canceling running operation")
-		operationID := q.ID
+// WaitForBulkOperation waits until the bulk operation id is finished and returns it. It polls the operation
+// every interval, unless the client has a BulkOperationNotifier: it then waits for the notification of the operation,
+// and polls it with a backoff starting at interval once the notification timed out.
+func (s *BulkOperationServiceOp) WaitForBulkOperation(ctx context.Context, id string, interval time.Duration) (*model.BulkOperation, error) {
+	if s.client.bulkNotifier != nil {
+		return s.waitForBulkOperationNotification(ctx, id, interval)
+	}
+
+	q, err := s.GetBulkOperation(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get bulk operation: %w", err)
+	}
 
-		m := mutationBulkOperationRunQueryCancel{}
-		vars := map[string]interface{}{
-			"id": operationID,
+	for isBulkOperationRunning(q.Status) {
+		s.client.gql.Logger().DebugContext(ctx, "Bulk operation is still running", graphql.LogKeyOperation, "bulkOperation", "id", id, "status", q.Status)
+		if err = s.sleep(ctx, interval); err != nil {
+			return q, err
 		}
 
-		err = s.client.gql.Mutate(ctx, &m, vars)
+		q, err = s.GetBulkOperation(ctx, id)
 		if err != nil {
-			return fmt.Errorf("mutation: %w", err)
+			return nil, fmt.Errorf("get bulk operation continously: %w", err)
 		}
-		if len(m.BulkOperationCancelResult.UserErrors) > 0 {
-			return fmt.Errorf("%+v", m.BulkOperationCancelResult.UserErrors)
+	}
+	s.client.gql.Logger().DebugContext(ctx, "Bulk operation ready", graphql.LogKeyOperation, "bulkOperation", "id", id, "status", q.Status)
+
+	return q, nil
+}
+
+// waitForBulkOperationNotification waits for the notification of the bulk operation id, polling it with a backoff
+// starting at interval if the notification doesn't arrive in time
+func (s *BulkOperationServiceOp) waitForBulkOperationNotification(ctx context.Context, id string, interval time.Duration) (*model.BulkOperation, error) {
+	logger := s.client.gql.Logger().With(graphql.LogKeyOperation, "bulkOperation", "id", id)
+	finished, unsubscribe := s.client.bulkNotifier.Subscribe(id)
+	defer unsubscribe()
+
+	// Subscribe before getting the operation, in case it finishes in between
+	q, err := s.GetBulkOperation(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get bulk operation: %w", err)
+	}
+
+	timer := time.NewTimer(s.client.bulkNotifierTimeout)
+	defer timer.Stop()
+	polling := false
+	for isBulkOperationRunning(q.Status) {
+		logger.DebugContext(ctx, "Waiting for the bulk operation notification", "status", q.Status, "polling", polling)
+		select {
+		case <-finished:
+			// A notified operation should be finished, poll it otherwise
+			finished = nil
+		case <-timer.C:
+			if !polling {
+				logger.WarnContext(ctx, "No bulk operation notification, polling", "timeout", s.client.bulkNotifierTimeout)
+				polling = true
+			} else {
+				interval = min(interval*2, maxBulkPollInterval)
+			}
+		case <-ctx.Done():
+			return q, ctx.Err()
 		}
 
-		q, err = s.GetCurrentBulkQuery(ctx)
+		q, err = s.GetBulkOperation(ctx, id)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("get bulk operation continously: %w", err)
 		}
-		for q.Status == model.BulkOperationStatusCreated || q.Status == model.BulkOperationStatusRunning || q.Status == model.BulkOperationStatusCanceling {
-			log.Tracef("Bulk operation still %s...", q.Status)
-			q, err = s.GetCurrentBulkQuery(ctx)
-			if err != nil {
-				return fmt.Errorf("get current bulk query: %w", err)
-			}
+		if finished == nil {
+			// The operation was notified but isn't finished yet
+			polling = true
 		}
-		log.Debugln("Bulk operation cancelled")
+		if polling {
+			timer.Stop()
+			timer.Reset(interval)
+		}
+	}
+	logger.DebugContext(ctx, "Bulk operation ready", "status", q.Status)
+
+	return q, nil
+}
+
+// CancelBulkOperation cancels the bulk operation id and waits until it is canceled.
+func (s *BulkOperationServiceOp) CancelBulkOperation(ctx context.Context, id string) error {
+	logger := s.client.gql.Logger().With(graphql.LogKeyOperation, "bulkOperationCancel", "id", id)
+	logger.DebugContext(ctx, "Canceling running bulk operation")
+
+	m := mutationBulkOperationRunQueryCancel{}
+	vars := map[string]interface{}{
+		"id": id,
+	}
+
+	err := s.client.gql.Mutate(ctx, &m, vars)
+	if err != nil {
+		return fmt.Errorf("mutation: %w", err)
+	}
+	if len(m.BulkOperationCancelResult.UserErrors) > 0 {
+		return fmt.Errorf("%+v", m.BulkOperationCancelResult.UserErrors)
+	}
+
+	q, err := s.WaitForBulkOperation(ctx, id, time.Second)
+	if err != nil {
+		return fmt.Errorf("wait for bulk operation: %w", err)
 	}
+	logger.DebugContext(ctx, "Bulk operation canceled", "status", q.Status)
 
 	return nil
 }
 
-func (s *BulkOperationServiceOp) BulkQuery(ctx context.Context, query string, out interface{}) error {
-	var (
-		id  *string
-		err error
-	)
+// startBulkOperation waits for a slot of the bulk coordinator, then posts the bulk operation of type opType,
+// retrying while the shop already runs another one, and returns its ID and the function releasing the slot.
+func (s *BulkOperationServiceOp) startBulkOperation(ctx context.Context, opType model.BulkOperationType, post func() (string, error)) (string, func(), error) {
+	release, err := s.acquireBulkOperation(ctx, opType)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := s.postBulkOperation(ctx, opType, post)
+	if err != nil {
+		release()
+		return "", nil, err
+	}
+	return id, release, nil
+}
 
-	// sentry tracing
-	span := sentry.StartSpan(ctx, "shopify_graphql.bulk_query")
-	span.Data = map[string]interface{}{
-		"GraphQL Query": query,
+// acquireBulkOperation waits for a slot of the bulk coordinator to run a bulk operation of type opType,
+// and returns the function releasing it.
+func (s *BulkOperationServiceOp) acquireBulkOperation(ctx context.Context, opType model.BulkOperationType) (func(), error) {
+	release, err := s.client.bulkOperationCoordinator().Acquire(ctx, s.client.gql.Shop(), opType)
+	if err != nil {
+		return nil, fmt.Errorf("wait for a bulk operation slot: %w", err)
 	}
+	return release, nil
+}
+
+// postBulkOperation posts the bulk operation of type opType, retrying while the shop already runs another one,
+// and returns its ID.
+func (s *BulkOperationServiceOp) postBulkOperation(ctx context.Context, opType model.BulkOperationType, post func() (string, error)) (string, error) {
+	for {
+		id, err := post()
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, ErrBulkOperationInProgress) {
+			return "", err
+		}
+		// Another process of the app runs a bulk operation on the shop
+		s.client.gql.Logger().DebugContext(ctx, "Another bulk operation is in progress", "type", opType)
+		if err = s.sleep(ctx, time.Second); err != nil {
+			return "", err
+		}
+	}
+}
+
+// sleep waits for interval, or until ctx is done
+func (s *BulkOperationServiceOp) sleep(ctx context.Context, interval time.Duration) (err error) {
+	_, span := s.client.gql.Tracer().Start(ctx, tracing.SpanSleep, tracing.Attr("interval", interval.String()))
 	defer func() {
-		tracing.FinishSpan(span, err)
+		span.End(err)
 	}()
-	ctx = span.Context()
-	// end sentry tracing
 
-	_, err = s.WaitForCurrentBulkQuery(ctx, time.Second)
-	if err != nil {
-		return fmt.Errorf("wait for current bulk query: %w", err)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	id, err = s.PostBulkQuery(ctx, query)
+func isBulkOperationRunning(status model.BulkOperationStatus) bool {
+	return status == model.BulkOperationStatusCreated || status == model.BulkOperationStatusRunning || status == model.BulkOperationStatusCanceling
+}
+
+// isBulkOperationInProgress reports whether the user error of a bulk operation tells that another one is running
+func isBulkOperationInProgress(code *string, message string) bool {
+	return (code != nil && *code == "OPERATION_IN_PROGRESS") || strings.Contains(message, "already in progress")
+}
+
+// CancelRunningBulkQuery cancels the current bulk query if it is running.
+func (s *BulkOperationServiceOp) CancelRunningBulkQuery(ctx context.Context) error {
+	q, err := s.GetCurrentBulkQuery(ctx)
 	if err != nil {
-		return fmt.Errorf("post bulk query: %w", err)
+		return err
 	}
 
-	if id == nil {
-		return fmt.Errorf("posted operation ID is nil")
+	if q.Status == model.BulkOperationStatusCreated || q.Status == model.BulkOperationStatusRunning {
+		return s.CancelBulkOperation(ctx, q.ID)
+	}
+
+	return nil
+}
+
+// BulkQuery runs query as a bulk operation and decodes its result into out, a pointer to a slice of the objects
+// of the top-level connection, with their nested connections attached. It returns a *BulkOperationError
+// if the operation didn't complete, see WithPartialData to decode the objects produced until then.
+func (s *BulkOperationServiceOp) BulkQuery(ctx context.Context, query string, out interface{}, opts ...BulkQueryOption) error {
+	_, err := s.BulkQueryStream(ctx, query, func(r io.Reader) error {
+		return parseBulkQueryResult(r, out)
+	}, opts...)
+	return err
+}
+
+// BulkQueryStream runs query as a bulk operation like BulkQuery, and calls parse with a reader of its JSONL result,
+// without decoding it. parse isn't called when the result is empty. It returns the result of the operation,
+// also when the operation didn't complete, along with a *BulkOperationError. See BulkQueryEach to decode
+// the objects one by one.
+func (s *BulkOperationServiceOp) BulkQueryStream(ctx context.Context, query string, parse func(r io.Reader) error, opts ...BulkQueryOption) (res *BulkResult, err error) {
+	var o bulkQueryOptions
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	url, err := s.ShouldGetBulkQueryResultURL(ctx, id)
+	ctx, span := s.client.gql.Tracer().Start(ctx, tracing.SpanBulkQuery,
+		tracing.Attr(tracing.AttrOperation, utils.GetDescriptionFromQuery(query)),
+		tracing.Attr(tracing.AttrQuery, query),
+	)
+	defer func() {
+		span.End(err)
+	}()
+
+	id, release, err := s.startBulkOperation(ctx, model.BulkOperationTypeQuery, func() (string, error) {
+		id, err := s.PostBulkQuery(ctx, query)
+		if err != nil {
+			return "", err
+		}
+		if id == nil {
+			return "", fmt.Errorf("posted operation ID is nil")
+		}
+		return *id, nil
+	})
 	if err != nil {
-		return fmt.Errorf("get bulk query result URL: %w", err)
+		return nil, fmt.Errorf("post bulk query: %w", err)
 	}
+	defer release()
 
-	if url == nil || *url == "" {
+	res, err = s.WaitForBulkResult(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("get bulk query result: %w", err)
+	}
+	// The operation is finished, let the next one run while the result is read
+	release()
+
+	url := res.URL
+	opErr, _ := res.Err().(*BulkOperationError)
+	if opErr != nil {
+		if !o.partialData || res.PartialDataURL == "" {
+			return res, opErr
+		}
+		url = res.PartialDataURL
+		opErr.Partial = true
+	}
+
+	if url == "" || (opErr == nil && res.ObjectCount == 0) {
 		// Empty result
+		return res, nil
+	}
+
+	err = s.readBulkResultFile(ctx, url, func(r io.Reader) error {
+		if err := parse(r); err != nil {
+			return fmt.Errorf("parse bulk query result: %w", err)
+		}
 		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	if opErr != nil {
+		return res, opErr
 	}
 
+	return res, nil
+}
+
+// readBulkResultFile downloads the JSONL result of a bulk operation from url to a temporary file,
+// and calls parse with it
+func (s *BulkOperationServiceOp) readBulkResultFile(ctx context.Context, url string, parse func(r io.Reader) error) error {
 	resultFile, err := os.CreateTemp("", "*.jsonl")
 	if err != nil {
 		return fmt.Errorf("create tempfile: %w", err)
@@ -244,7 +495,7 @@ func (s *BulkOperationServiceOp) BulkQuery(ctx context.Context, query string, ou
 		_ = os.Remove(resultFile.Name())
 	}()
 
-	err = utils.DownloadFile(ctx, resultFile, *url)
+	err = utils.DownloadFileWithTracer(ctx, s.client.gql.ExternalHTTPClient(), s.client.gql.Tracer(), resultFile, url)
 	if err != nil {
 		return fmt.Errorf("download file: %w", err)
 	}
@@ -255,24 +506,35 @@ func (s *BulkOperationServiceOp) BulkQuery(ctx context.Context, query string, ou
 		return fmt.Errorf("seeking result file: %w", err)
 	}
 
-	err = parseBulkQueryResult(resultFile, out)
-	if err != nil {
-		return fmt.Errorf("parse bulk query result: %w", err)
-	}
+	return parse(resultFile)
+}
 
-	return nil
+// BulkQueryEach runs query as a bulk operation like BulkQuery, but instead of decoding the whole result into a slice,
+// it reads the result line by line and calls fn with each object of the top-level connection once its nested
+// connections are attached. Only one object and its nested connections are held in memory at a time.
+// BulkQueryEach stops at the first error returned by fn and returns it.
+//
+// It relies on Shopify writing the nested objects right after their parent, before the next top-level object.
+func BulkQueryEach[T any](ctx context.Context, s BulkOperationService, query string, fn func(item T) error, opts ...BulkQueryOption) error {
+	_, err := s.BulkQueryStream(ctx, query, func(r io.Reader) error {
+		return eachBulkQueryResult(r, fn)
+	}, opts...)
+	return err
 }
 
-// GetBulkQueryResult get current status of bulk query id
+// GetBulkQueryResult get current status of bulk query id, or of the current bulk query if id is nil
 func (s *BulkOperationServiceOp) GetBulkQueryResult(ctx context.Context, id graphql.ID) (*model.BulkOperation, error) {
-	q, err := s.GetCurrentBulkQuery(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("get current bulk query: %w", err)
+	if id == nil {
+		q, err := s.GetCurrentBulkQuery(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get current bulk query: %w", err)
+		}
+		return q, nil
 	}
 
-	if id != nil && q.ID != id {
-		err = fmt.Errorf("bulk operation ID doesn't match, got=%v, want=%v", q.ID, id)
-		return q, err
+	q, err := s.GetBulkOperation(ctx, fmt.Sprint(id))
+	if err != nil {
+		return nil, fmt.Errorf("get bulk operation: %w", err)
 	}
 	return q, nil
 }
@@ -339,7 +601,7 @@ func (b *bulkQueryBuilder) Build() string {
 	return q
 }
 
-func parseBulkQueryResult(resultFile *os.File, out interface{}) error {
+func parseBulkQueryResult(resultFile io.Reader, out interface{}) error {
 	var err error
 	if reflect.TypeOf(out).Kind() != reflect.Ptr {
 		return fmt.Errorf("the out arg is not a pointer")
@@ -372,70 +634,9 @@ func parseBulkQueryResult(resultFile *os.File, out interface{}) error {
 
 		parentIDNode := json.Get(line, "__parentId")
 		if parentIDNode.LastError() == nil {
-			parentID := parentIDNode.ToString()
-
-			gid := json.Get(line, "id")
-			if gid.LastError() != nil {
-				return fmt.Errorf("The connection type must query the `id` field")
-			}
-			edgeType, nodeType, connectionFieldName, err := concludeObjectType(gid.ToString())
-			if err != nil {
+			if _, err = sinkNestedConnection(line, parentIDNode.ToString(), connectionSink); err != nil {
 				return err
 			}
-			node := reflect.New(nodeType).Interface()
-			err = json.Unmarshal(line, &node)
-			if err != nil {
-				return fmt.Errorf("unmarshalling: %w", err)
-			}
-			nodeVal := reflect.ValueOf(node).Elem()
-
-			var edge interface{}
-			var edgeVal reflect.Value
-			var nodeField reflect.Value
-			if edgeType.Kind() == reflect.Ptr {
-				edge = reflect.New(edgeType.Elem()).Interface()
-				nodeField = reflect.ValueOf(edge).Elem().FieldByName(nodeFieldName)
-				edgeVal = reflect.ValueOf(edge)
-			} else {
-				edge = reflect.New(edgeType).Interface()
-
-				if reflect.ValueOf(edge).Kind() == reflect.Ptr {
-					nodeField = reflect.ValueOf(edge).Elem().FieldByName(nodeFieldName)
-				} else {
-					nodeField = reflect.ValueOf(edge).FieldByName(nodeFieldName)
-				}
-
-				edgeVal = reflect.ValueOf(edge).Elem()
-			}
-
-			if !nodeField.IsValid() {
-				return fmt.Errorf("Edge in the '%s' doesn't have the Node field", connectionFieldName)
-			}
-			nodeField.Set(nodeVal)
-
-			var edgesSlice reflect.Value
-			var edges map[string]interface{}
-			if val, ok := connectionSink[parentID]; ok {
-				var ok2 bool
-				if edges, ok2 = val.(map[string]interface{}); !ok2 {
-					return fmt.Errorf("The connection sink for parent ID '%s' is not a map", parentID)
-				}
-			} else {
-				edges = make(map[string]interface{})
-			}
-
-			if val, ok := edges[connectionFieldName]; ok {
-				edgesSlice = reflect.ValueOf(val)
-			} else {
-				edgesSliceCap := 50
-				edgesSlice = reflect.MakeSlice(reflect.SliceOf(edgeType), 0, edgesSliceCap)
-			}
-
-			edgesSlice = reflect.Append(edgesSlice, edgeVal)
-
-			edges[connectionFieldName] = edgesSlice.Interface()
-			connectionSink[parentID] = edges
-
 			continue
 		}
 
@@ -468,6 +669,152 @@ func parseBulkQueryResult(resultFile *os.File, out interface{}) error {
 	return nil
 }
 
+// eachBulkQueryResult reads the JSONL result of a bulk query and calls fn with each top-level object, once the lines
+// of its nested connections, which follow it, are attached.
+func eachBulkQueryResult[T any](r io.Reader, fn func(item T) error) error {
+	sliceType := reflect.TypeOf([]T(nil))
+	sliceItemKind := sliceType.Elem().Kind()
+	itemType := sliceType.Elem()
+	if sliceItemKind == reflect.Ptr {
+		itemType = itemType.Elem()
+	}
+
+	reader := bufio.NewReader(r)
+	json := jsoniter.ConfigFastest
+
+	var (
+		// pending holds the current top-level object, as a slice of one item for attachNestedConnections
+		pending        reflect.Value
+		connectionSink map[string]interface{}
+		// pendingIDs are the IDs of the current top-level object and of its nested objects
+		pendingIDs map[string]bool
+	)
+	flush := func() error {
+		if !pending.IsValid() {
+			return nil
+		}
+		if len(connectionSink) > 0 {
+			if err := attachNestedConnections(connectionSink, pending); err != nil {
+				return fmt.Errorf("error processing nested connections: %w", err)
+			}
+		}
+		item := pending.Index(0).Interface().(T)
+		pending = reflect.Value{}
+		return fn(item)
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			parentIDNode := json.Get(line, "__parentId")
+			if parentIDNode.LastError() == nil {
+				parentID := parentIDNode.ToString()
+				if !pendingIDs[parentID] {
+					return fmt.Errorf("the parent '%s' of a nested object is not the current top-level object or one of its nested objects", parentID)
+				}
+				id, serr := sinkNestedConnection(line, parentID, connectionSink)
+				if serr != nil {
+					return serr
+				}
+				pendingIDs[id] = true
+			} else {
+				if ferr := flush(); ferr != nil {
+					return ferr
+				}
+				item := reflect.New(itemType).Interface()
+				if uerr := json.Unmarshal(line, &item); uerr != nil {
+					return fmt.Errorf("unmarshalling: %w", uerr)
+				}
+				itemVal := reflect.ValueOf(item)
+				if sliceItemKind != reflect.Ptr {
+					itemVal = itemVal.Elem()
+				}
+				pending = reflect.Append(reflect.MakeSlice(sliceType, 0, 1), itemVal)
+				connectionSink = make(map[string]interface{})
+				pendingIDs = map[string]bool{json.Get(line, "id").ToString(): true}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading the result file: %w", err)
+		}
+	}
+
+	return flush()
+}
+
+// sinkNestedConnection decodes the line of a nested object, whose parent has the ID parentID, and adds it to
+// the edges of its connection in connectionSink. It returns the ID of the nested object.
+func sinkNestedConnection(line []byte, parentID string, connectionSink map[string]interface{}) (string, error) {
+	json := jsoniter.ConfigFastest
+
+	gid := json.Get(line, "id")
+	if gid.LastError() != nil {
+		return "", fmt.Errorf("The connection type must query the `id` field")
+	}
+	edgeType, nodeType, connectionFieldName, err := concludeObjectType(gid.ToString())
+	if err != nil {
+		return "", err
+	}
+	node := reflect.New(nodeType).Interface()
+	err = json.Unmarshal(line, &node)
+	if err != nil {
+		return "", fmt.Errorf("unmarshalling: %w", err)
+	}
+	nodeVal := reflect.ValueOf(node).Elem()
+
+	var edge interface{}
+	var edgeVal reflect.Value
+	var nodeField reflect.Value
+	if edgeType.Kind() == reflect.Ptr {
+		edge = reflect.New(edgeType.Elem()).Interface()
+		nodeField = reflect.ValueOf(edge).Elem().FieldByName(nodeFieldName)
+		edgeVal = reflect.ValueOf(edge)
+	} else {
+		edge = reflect.New(edgeType).Interface()
+
+		if reflect.ValueOf(edge).Kind() == reflect.Ptr {
+			nodeField = reflect.ValueOf(edge).Elem().FieldByName(nodeFieldName)
+		} else {
+			nodeField = reflect.ValueOf(edge).FieldByName(nodeFieldName)
+		}
+
+		edgeVal = reflect.ValueOf(edge).Elem()
+	}
+
+	if !nodeField.IsValid() {
+		return "", fmt.Errorf("Edge in the '%s' doesn't have the Node field", connectionFieldName)
+	}
+	nodeField.Set(nodeVal)
+
+	var edgesSlice reflect.Value
+	var edges map[string]interface{}
+	if val, ok := connectionSink[parentID]; ok {
+		var ok2 bool
+		if edges, ok2 = val.(map[string]interface{}); !ok2 {
+			return "", fmt.Errorf("The connection sink for parent ID '%s' is not a map", parentID)
+		}
+	} else {
+		edges = make(map[string]interface{})
+	}
+
+	if val, ok := edges[connectionFieldName]; ok {
+		edgesSlice = reflect.ValueOf(val)
+	} else {
+		edgesSliceCap := 50
+		edgesSlice = reflect.MakeSlice(reflect.SliceOf(edgeType), 0, edgesSliceCap)
+	}
+
+	edgesSlice = reflect.Append(edgesSlice, edgeVal)
+
+	edges[connectionFieldName] = edgesSlice.Interface()
+	connectionSink[parentID] = edges
+
+	return gid.ToString(), nil
+}
+
 func attachNestedConnections(connectionSink map[string]interface{}, outSlice reflect.Value) error {
 	for i := 0; i < outSlice.Len(); i++ {
 		parent := outSlice.Index(i)
@@ -0,0 +1,99 @@
+package shopify
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+)
+
+// DefaultBulkConcurrency is the number of bulk operations of each type the clients run at once per shop
+// unless another BulkCoordinator is set, the limit of the API versions allowing a single bulk operation at a time.
+const DefaultBulkConcurrency = 1
+
+// defaultBulkCoordinator is shared by the clients without a BulkCoordinator
+var defaultBulkCoordinator = NewBulkCoordinator(DefaultBulkConcurrency)
+
+// BulkCoordinator limits the number of bulk operations of each type run at once per shop, queueing the others
+// until a running one finishes. Set the same coordinator on all the clients of a shop, e.g. with
+// WithPoolBulkCoordinator, so that they share the limit.
+// A BulkCoordinator is safe for concurrent use.
+type BulkCoordinator struct {
+	limit int
+
+	mu    sync.Mutex
+	slots map[bulkSlotKey]*bulkSlots
+}
+
+type bulkSlotKey struct {
+	shop   string
+	opType model.BulkOperationType
+}
+
+// bulkSlots are the slots of a shop and type, refs counting the operations holding or waiting for one
+type bulkSlots struct {
+	sem  chan struct{}
+	refs int
+}
+
+// NewBulkCoordinator returns a coordinator running at most limit bulk operations of each type at once per shop.
+// Newer API versions allow several bulk queries at once, see the Shopify documentation for the limit of your version.
+func NewBulkCoordinator(limit int) *BulkCoordinator {
+	if limit < 1 {
+		limit = 1
+	}
+	return &BulkCoordinator{
+		limit: limit,
+		slots: make(map[bulkSlotKey]*bulkSlots),
+	}
+}
+
+// Acquire waits until a bulk operation of type opType can be run for shop and returns the function releasing
+// its slot once the operation finished. It returns the error of ctx if ctx is done first.
+func (c *BulkCoordinator) Acquire(ctx context.Context, shop string, opType model.BulkOperationType) (release func(), err error) {
+	key := bulkSlotKey{shop: shop, opType: opType}
+	c.mu.Lock()
+	slots, ok := c.slots[key]
+	if !ok {
+		slots = &bulkSlots{sem: make(chan struct{}, c.limit)}
+		c.slots[key] = slots
+	}
+	slots.refs++
+	c.mu.Unlock()
+
+	select {
+	case slots.sem <- struct{}{}:
+	case <-ctx.Done():
+		c.unref(key, slots)
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-slots.sem
+			c.unref(key, slots)
+		})
+	}, nil
+}
+
+// Running returns the number of bulk operations of type opType holding a slot of shop.
+func (c *BulkCoordinator) Running(shop string, opType model.BulkOperationType) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slots, ok := c.slots[bulkSlotKey{shop: shop, opType: opType}]
+	if !ok {
+		return 0
+	}
+	return len(slots.sem)
+}
+
+// unref forgets the slots of key once no operation holds or waits for one
+func (c *BulkCoordinator) unref(key bulkSlotKey, slots *bulkSlots) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slots.refs--
+	if slots.refs == 0 {
+		delete(c.slots, key)
+	}
+}
@@ -0,0 +1,390 @@
+package shopify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+	"gopkg.in/guregu/null.v4"
+
+	"github.com/gempages/go-shopify-graphql/graphql"
+	"github.com/gempages/go-shopify-graphql/tracing"
+	"github.com/gempages/go-shopify-graphql/utils"
+)
+
+// MaxBulkMutationVariablesSize is the maximum size in bytes of the variables of a bulk mutation allowed by Shopify
+const MaxBulkMutationVariablesSize = 100 << 20
+
+// ErrBulkMutationVariablesTooLarge is returned, wrapped, by BulkMutate when the variables exceed MaxBulkMutationVariablesSize
+var ErrBulkMutationVariablesTooLarge = errors.New("bulk mutation variables too large")
+
+const (
+	bulkMutationVariablesFilename = "bulk_op_vars.jsonl"
+	bulkMutationVariablesMimeType = "text/jsonl"
+	// stagedUploadKeyParameter is the staged upload parameter holding the path passed to bulkOperationRunMutation
+	stagedUploadKeyParameter = "key"
+)
+
+type mutationBulkOperationRunMutation struct {
+	BulkOperationRunMutationResult model.BulkOperationRunMutationPayload `graphql:"bulkOperationRunMutation(mutation: $mutation, stagedUploadPath: $stagedUploadPath)" json:"bulkOperationRunMutation"`
+}
+
+// BulkMutationResult is the result of the mutation run with a line of the variables of a bulk mutation.
+type BulkMutationResult struct {
+	// Line is the index of the line of the variables, starting at 0
+	Line int
+	// Variables are the variables of the line
+	Variables json.RawMessage
+	// Data is the data returned by the mutation, e.g. {"productUpdate": {...}}, nil if the line wasn't run,
+	// e.g. because the bulk operation failed
+	Data json.RawMessage
+	// UserErrors are the user errors of the mutation payload
+	UserErrors []model.BulkMutationUserError
+	// Errors are the GraphQL errors of the line
+	Errors []*graphql.GraphQLError
+}
+
+// Unmarshal decodes the data returned by the mutation into v.
+func (r *BulkMutationResult) Unmarshal(v any) error {
+	if r.Data == nil {
+		return fmt.Errorf("line %d wasn't run", r.Line)
+	}
+	return json.Unmarshal(r.Data, v)
+}
+
+// Err returns an error joining the errors and user errors of the line, nil if it succeeded.
+// The GraphQL errors are wrapped, so that errors.Is matches them with the sentinel errors of their codes.
+func (r *BulkMutationResult) Err() error {
+	if len(r.Errors) == 0 && len(r.UserErrors) == 0 {
+		if r.Data == nil {
+			return fmt.Errorf("line %d wasn't run", r.Line)
+		}
+		return nil
+	}
+	errs := make([]error, 0, len(r.Errors)+len(r.UserErrors))
+	for _, err := range r.Errors {
+		errs = append(errs, err)
+	}
+	for _, userErr := range r.UserErrors {
+		if len(userErr.Field) == 0 {
+			errs = append(errs, errors.New(userErr.Message))
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: %s", strings.Join(userErr.Field, "."), userErr.Message))
+	}
+	return fmt.Errorf("line %d: %w", r.Line, errors.Join(errs...))
+}
+
+// BulkMutationVariables encodes the variables of each mutation as a line of JSONL, the input of BulkMutate.
+func BulkMutationVariables[T any](variables []T) (io.Reader, error) {
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	for i, v := range variables {
+		if err := encoder.Encode(v); err != nil {
+			return nil, fmt.Errorf("encode variables %d: %w", i, err)
+		}
+	}
+	return buf, nil
+}
+
+// BulkMutate runs mutation once per line of variables, a JSONL stream of the variables of each mutation,
+// as a bulk operation: the variables are uploaded through a staged upload, and the results are returned
+// in the order of the lines once the operation finished. See BulkMutationVariables to encode the variables.
+//
+// The variables are buffered in a temporary file, not in memory. They are limited to MaxBulkMutationVariablesSize,
+// ErrBulkMutationVariablesTooLarge being returned before anything is uploaded otherwise.
+//
+// If the operation didn't complete, the results of the lines run until then are returned with a *BulkOperationError.
+//
+// Shopify runs one bulk mutation per shop at a time: the variables are uploaded once the bulk coordinator
+// allows running it.
+func (s *BulkOperationServiceOp) BulkMutate(ctx context.Context, mutation string, variables io.Reader) (results []BulkMutationResult, err error) {
+	ctx, span := s.client.gql.Tracer().Start(ctx, tracing.SpanBulkMutation,
+		tracing.Attr(tracing.AttrOperation, utils.GetDescriptionFromQuery(mutation)),
+		tracing.Attr(tracing.AttrQuery, mutation),
+	)
+	defer func() {
+		span.End(err)
+	}()
+
+	file, err := os.CreateTemp("", "bulk_op_vars_*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("create variables file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+	lines, size, err := copyBulkMutationVariables(file, variables)
+	if err != nil {
+		return nil, err
+	}
+	results = make([]BulkMutationResult, lines)
+	for i := range results {
+		results[i] = BulkMutationResult{Line: i}
+	}
+	if lines == 0 {
+		return results, nil
+	}
+
+	// The staged upload expires, it is only created once the operation can be run
+	release, err := s.acquireBulkOperation(ctx, model.BulkOperationTypeMutation)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewind variables file: %w", err)
+	}
+	path, err := s.uploadBulkMutationVariables(ctx, file, size)
+	if err != nil {
+		return nil, fmt.Errorf("upload variables: %w", err)
+	}
+
+	id, err := s.postBulkOperation(ctx, model.BulkOperationTypeMutation, func() (string, error) {
+		return s.PostBulkMutation(ctx, mutation, path)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("post bulk mutation: %w", err)
+	}
+
+	res, err := s.WaitForBulkResult(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("wait for bulk mutation: %w", err)
+	}
+	release()
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("rewind variables file: %w", err)
+	}
+	if err = readBulkMutationVariables(file, results); err != nil {
+		return nil, err
+	}
+
+	// A failed operation may have run some of the lines
+	opErr, _ := res.Err().(*BulkOperationError)
+	url := res.URL
+	if opErr != nil {
+		url = res.PartialDataURL
+	}
+	if url != "" {
+		err = s.readBulkResultFile(ctx, url, func(r io.Reader) error {
+			return parseBulkMutationResults(r, results)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opErr != nil {
+		opErr.Partial = url != ""
+		return results, opErr
+	}
+	return results, nil
+}
+
+// PostBulkMutation starts a bulk operation running mutation with the variables uploaded to stagedUploadPath
+// and returns its ID.
+func (s *BulkOperationServiceOp) PostBulkMutation(ctx context.Context, mutation, stagedUploadPath string) (string, error) {
+	m := mutationBulkOperationRunMutation{}
+	vars := map[string]interface{}{
+		"mutation":         null.StringFrom(mutation),
+		"stagedUploadPath": null.StringFrom(stagedUploadPath),
+	}
+
+	err := s.client.gql.Mutate(ctx, &m, vars)
+	if err != nil {
+		return "", fmt.Errorf("error posting bulk mutation: %w", err)
+	}
+	if len(m.BulkOperationRunMutationResult.UserErrors) > 0 {
+		userErrors, _ := json.MarshalIndent(m.BulkOperationRunMutationResult.UserErrors, "", "    ")
+		for _, userErr := range m.BulkOperationRunMutationResult.UserErrors {
+			if isBulkOperationInProgress(userErr.Code, userErr.Message) {
+				return "", fmt.Errorf("error posting bulk mutation: %w: %s", ErrBulkOperationInProgress, userErrors)
+			}
+		}
+		return "", fmt.Errorf("error posting bulk mutation: %s", userErrors)
+	}
+
+	return m.BulkOperationRunMutationResult.BulkOperation.ID, nil
+}
+
+// scanBulkMutationVariables calls fn with each line of variables, the blank ones skipped.
+func scanBulkMutationVariables(variables io.Reader, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(variables)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read variables: %w", err)
+	}
+	return nil
+}
+
+// copyBulkMutationVariables validates the lines of variables and writes them as JSONL to w,
+// returning the number of lines and bytes written.
+func copyBulkMutationVariables(w io.Writer, variables io.Reader) (lines int, size int64, err error) {
+	bw := bufio.NewWriter(w)
+	err = scanBulkMutationVariables(variables, func(line []byte) error {
+		if !json.Valid(line) {
+			return fmt.Errorf("variables line %d is not valid JSON", lines)
+		}
+		size += int64(len(line)) + 1
+		if size > MaxBulkMutationVariablesSize {
+			return fmt.Errorf("%w: more than %d bytes", ErrBulkMutationVariablesTooLarge, MaxBulkMutationVariablesSize)
+		}
+		lines++
+		_, _ = bw.Write(line)
+		return bw.WriteByte('\n')
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = bw.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("write variables: %w", err)
+	}
+	return lines, size, nil
+}
+
+// readBulkMutationVariables sets the variables of the results from the JSONL written by copyBulkMutationVariables.
+func readBulkMutationVariables(r io.Reader, results []BulkMutationResult) error {
+	i := 0
+	return scanBulkMutationVariables(r, func(line []byte) error {
+		if i < len(results) {
+			results[i].Variables = json.RawMessage(bytes.Clone(line))
+		}
+		i++
+		return nil
+	})
+}
+
+// uploadBulkMutationVariables uploads size bytes of JSONL variables through a staged upload, streaming them
+// from r, and returns the staged upload path
+func (s *BulkOperationServiceOp) uploadBulkMutationVariables(ctx context.Context, r io.Reader, size int64) (string, error) {
+	files := &FileServiceOp{client: s.client}
+	target, err := files.stagedUploadsCreate(ctx, strconv.FormatInt(size, 10), bulkMutationVariablesFilename, bulkMutationVariablesMimeType,
+		model.StagedUploadTargetGenerateUploadResourceBulkMutationVariables)
+	if err != nil {
+		return "", fmt.Errorf("create staged upload: %w", err)
+	}
+
+	var path string
+	for _, param := range target.Parameters {
+		if param.Name == stagedUploadKeyParameter {
+			path = param.Value
+		}
+	}
+	if path == "" {
+		return "", fmt.Errorf("staged upload target has no %q parameter", stagedUploadKeyParameter)
+	}
+	if target.URL == nil {
+		return "", fmt.Errorf("staged upload target has no URL")
+	}
+
+	// The multipart form is sent as its parameters and file header, the file, then its closing boundary,
+	// so that its length is known without buffering the file
+	head := &bytes.Buffer{}
+	writer := multipart.NewWriter(head)
+	for _, param := range target.Parameters {
+		if err = writer.WriteField(param.Name, param.Value); err != nil {
+			return "", fmt.Errorf("write form field: %w", err)
+		}
+	}
+	if _, err = writer.CreateFormFile(fileFieldName, bulkMutationVariablesFilename); err != nil {
+		return "", fmt.Errorf("create form file: %w", err)
+	}
+	headLen := head.Len()
+	if err = writer.Close(); err != nil {
+		return "", fmt.Errorf("close form: %w", err)
+	}
+	tail := bytes.Clone(head.Bytes()[headLen:])
+	head.Truncate(headLen)
+
+	body := io.MultiReader(head, io.LimitReader(r, size), bytes.NewReader(tail))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *target.URL, body)
+	if err != nil {
+		return "", fmt.Errorf("create upload request: %w", err)
+	}
+	req.ContentLength = int64(headLen) + size + int64(len(tail))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := s.client.gql.ExternalHTTPClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("upload to stage: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return "", fmt.Errorf("upload to stage: non-201 Created status code: %v: %s", resp.Status, respBody)
+	}
+	return path, nil
+}
+
+// bulkMutationResultLine is a line of the results of a bulk mutation
+type bulkMutationResultLine struct {
+	Data       map[string]json.RawMessage `json:"data"`
+	Errors     []*graphql.GraphQLError    `json:"errors"`
+	LineNumber *int                       `json:"__lineNumber"`
+}
+
+func parseBulkMutationResults(r io.Reader, results []BulkMutationResult) error {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if perr := parseBulkMutationResult(line, results); perr != nil {
+				return perr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading the result file: %w", err)
+		}
+	}
+}
+
+func parseBulkMutationResult(line []byte, results []BulkMutationResult) error {
+	var out bulkMutationResultLine
+	if err := json.Unmarshal(line, &out); err != nil {
+		return fmt.Errorf("unmarshalling: %w", err)
+	}
+	if out.LineNumber == nil || *out.LineNumber < 0 || *out.LineNumber >= len(results) {
+		return fmt.Errorf("result line has an unexpected __lineNumber: %s", line)
+	}
+	result := &results[*out.LineNumber]
+	result.Errors = out.Errors
+	if out.Data == nil {
+		return nil
+	}
+	result.Data, _ = json.Marshal(out.Data)
+	// The payload of the mutation, e.g. productUpdate, holds the user errors
+	for _, payload := range out.Data {
+		var p struct {
+			UserErrors []model.BulkMutationUserError `json:"userErrors"`
+		}
+		if json.Unmarshal(payload, &p) == nil {
+			result.UserErrors = append(result.UserErrors, p.UserErrors...)
+		}
+	}
+	return nil
+}
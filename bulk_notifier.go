@@ -0,0 +1,104 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql/webhook"
+)
+
+const (
+	// DefaultBulkNotificationTimeout is how long the clients wait for the notification of a bulk operation
+	// before polling it, unless another timeout is set with the notifier
+	DefaultBulkNotificationTimeout = 10 * time.Minute
+	// maxBulkPollInterval caps the backoff of the polling once the notification timed out
+	maxBulkPollInterval = time.Minute
+)
+
+// BulkOperationNotifier notifies the clients waiting for a bulk operation that it finished, e.g. on the
+// bulk_operations/finish webhook, so that they don't poll it. Implement it with a message broker such as
+// Redis Pub/Sub when the webhooks are received by another process than the one running the bulk operations.
+type BulkOperationNotifier interface {
+	// Subscribe returns a channel receiving or closed once the bulk operation id finished,
+	// and the function to call once the channel isn't needed anymore.
+	Subscribe(id string) (finished <-chan struct{}, unsubscribe func())
+}
+
+// BulkNotifier is a BulkOperationNotifier notified in process, by calling Notify or by handling
+// the bulk_operations/finish webhooks of a webhook.Receiver.
+// A BulkNotifier is safe for concurrent use.
+type BulkNotifier struct {
+	mu            sync.Mutex
+	subscriptions map[string]*bulkSubscription
+}
+
+// bulkSubscription is closed once its operation finished, refs counting its subscribers
+type bulkSubscription struct {
+	finished chan struct{}
+	refs     int
+}
+
+var (
+	_ BulkOperationNotifier = &BulkNotifier{}
+	_ webhook.Handler       = &BulkNotifier{}
+)
+
+// NewBulkNotifier returns a notifier without subscriptions.
+func NewBulkNotifier() *BulkNotifier {
+	return &BulkNotifier{subscriptions: make(map[string]*bulkSubscription)}
+}
+
+// Subscribe returns a channel closed once Notify is called with id.
+func (n *BulkNotifier) Subscribe(id string) (<-chan struct{}, func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sub, ok := n.subscriptions[id]
+	if !ok {
+		sub = &bulkSubscription{finished: make(chan struct{})}
+		n.subscriptions[id] = sub
+	}
+	sub.refs++
+
+	var once sync.Once
+	return sub.finished, func() {
+		once.Do(func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			sub.refs--
+			// The subscription may have been notified and replaced since
+			if sub.refs == 0 && n.subscriptions[id] == sub {
+				delete(n.subscriptions, id)
+			}
+		})
+	}
+}
+
+// Notify notifies the subscribers of the bulk operation id that it finished.
+// The operations without subscribers are ignored.
+func (n *BulkNotifier) Notify(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if sub, ok := n.subscriptions[id]; ok {
+		close(sub.finished)
+		delete(n.subscriptions, id)
+	}
+}
+
+// ServeWebhook notifies the bulk operation of a bulk_operations/finish webhook, so that the notifier can be
+// registered as the handler of the topic:
+//
+//	receiver.Handle(model.WebhookSubscriptionTopicBulkOperationsFinish, notifier)
+func (n *BulkNotifier) ServeWebhook(ctx context.Context, w *webhook.Webhook) error {
+	payload, err := webhook.Decode(w)
+	if err != nil {
+		return webhook.Permanent(err)
+	}
+	op, ok := payload.(*webhook.BulkOperationsFinish)
+	if !ok {
+		return webhook.Permanent(fmt.Errorf("unexpected webhook topic %s", w.RawTopic))
+	}
+	n.Notify(op.ID)
+	return nil
+}
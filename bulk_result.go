@@ -0,0 +1,119 @@
+package shopify
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+)
+
+// BulkResult describes a finished bulk operation and its result.
+type BulkResult struct {
+	ID     string
+	Type   model.BulkOperationType
+	Status model.BulkOperationStatus
+	// ErrorCode is set when the operation failed, e.g. TIMEOUT or ACCESS_DENIED
+	ErrorCode       *model.BulkOperationErrorCode
+	ObjectCount     int64
+	RootObjectCount int64
+	// FileSize is the size in bytes of the result file, 0 if there is none
+	FileSize int64
+	// URL is the URL of the result file, empty if the operation didn't complete or the result is empty
+	URL string
+	// PartialDataURL is the URL of the result file of a failed operation, with the objects produced before
+	// it failed, empty if there are none
+	PartialDataURL string
+	CreatedAt      time.Time
+	CompletedAt    *time.Time
+}
+
+func newBulkResult(q *model.BulkOperation) *BulkResult {
+	r := &BulkResult{
+		ID:          q.ID,
+		Type:        q.Type,
+		Status:      q.Status,
+		ErrorCode:   q.ErrorCode,
+		CreatedAt:   q.CreatedAt,
+		CompletedAt: q.CompletedAt,
+	}
+	r.ObjectCount, _ = strconv.ParseInt(q.ObjectCount, 10, 64)
+	r.RootObjectCount, _ = strconv.ParseInt(q.RootObjectCount, 10, 64)
+	if q.FileSize != nil {
+		r.FileSize, _ = strconv.ParseInt(*q.FileSize, 10, 64)
+	}
+	if q.URL != nil {
+		r.URL = *q.URL
+	}
+	if q.PartialDataURL != nil {
+		r.PartialDataURL = *q.PartialDataURL
+	}
+	return r
+}
+
+// Completed reports whether the operation completed successfully.
+func (r *BulkResult) Completed() bool {
+	return r.Status == model.BulkOperationStatusCompleted && (r.ErrorCode == nil || *r.ErrorCode == "")
+}
+
+// Err returns a *BulkOperationError if the operation didn't complete successfully, nil otherwise.
+func (r *BulkResult) Err() error {
+	if r.Completed() {
+		return nil
+	}
+	return &BulkOperationError{Result: r}
+}
+
+// BulkOperationError is returned when a bulk operation failed or was canceled. Get it with errors.As
+// to read the status and error code of the operation:
+//
+//	var opErr *shopify.BulkOperationError
+//	if errors.As(err, &opErr) && opErr.Code() == model.BulkOperationErrorCodeTimeout {
+type BulkOperationError struct {
+	Result *BulkResult
+	// Partial reports whether the partial data of the operation was decoded, see WithPartialData
+	Partial bool
+}
+
+func (e *BulkOperationError) Error() string {
+	msg := fmt.Sprintf("bulk operation didn't complete, status=%s, error_code=%s", e.Result.Status, e.Code())
+	if e.Partial {
+		msg += ", partial data decoded"
+	}
+	return msg
+}
+
+// Code returns the error code of the operation, empty if it was canceled.
+func (e *BulkOperationError) Code() model.BulkOperationErrorCode {
+	if e.Result.ErrorCode == nil {
+		return ""
+	}
+	return *e.Result.ErrorCode
+}
+
+// BulkQueryOption is used to configure BulkQuery, BulkQueryStream and BulkQueryEach
+type BulkQueryOption func(o *bulkQueryOptions)
+
+type bulkQueryOptions struct {
+	partialData bool
+}
+
+// WithPartialData optionally decodes the objects produced by a bulk query before it failed, from its partial data.
+// The error returned is still a *BulkOperationError, with Partial set when partial data was decoded.
+func WithPartialData() BulkQueryOption {
+	return func(o *bulkQueryOptions) {
+		o.partialData = true
+	}
+}
+
+// WaitForBulkResult waits until the bulk operation id is finished and returns its result, whatever its status.
+// Call Err on the result to check that it completed.
+func (s *BulkOperationServiceOp) WaitForBulkResult(ctx context.Context, id string) (*BulkResult, error) {
+	q, err := s.WaitForBulkOperation(ctx, id, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for bulk operation: %w", err)
+	}
+	s.observeBulkOperation(ctx, q)
+	return newBulkResult(q), nil
+}
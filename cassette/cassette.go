@@ -0,0 +1,283 @@
+// Package cassette records HTTP interactions with Shopify to a file and replays them later without network access.
+//
+// Requests to the GraphQL endpoint are matched by their normalized query and variables, other requests,
+// such as bulk operation result downloads and staged uploads, by their method and URL.
+// Access tokens and the signatures of presigned URLs are redacted from the recordings, and more can be redacted
+// with WithRedactor.
+//
+//	c, err := cassette.Load("testdata/products.json")
+//	client := shopify.NewClientWithOpts(domain, graphqlclient.WithToken(token), graphqlclient.WithCassette(c))
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Mode selects whether a cassette replays or records interactions.
+type Mode int
+
+const (
+	// ModeReplay answers every request from the recordings and fails the ones that have none
+	ModeReplay Mode = iota
+	// ModeRecord sends every request and records the interaction
+	ModeRecord
+)
+
+// redacted replaces the value of the headers holding credentials.
+const redacted = "[REDACTED]"
+
+// ErrNoRecording is returned, wrapped, for a request that doesn't match any recording in replay mode.
+var ErrNoRecording = errors.New("cassette: no recording")
+
+// redactedHeaders are the request headers holding credentials, never written to the recordings.
+var redactedHeaders = []string{
+	"X-Shopify-Access-Token",
+	"X-Shopify-Storefront-Access-Token",
+	"Authorization",
+}
+
+// Interaction is a recorded request and its response.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request. The body of requests outside the GraphQL endpoint, such as file uploads, isn't recorded.
+type Request struct {
+	// Key is the normalized form of the request used to match it on replay
+	Key     string      `json:"key"`
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    string      `json:"body,omitempty"`
+}
+
+// Response is a recorded response.
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body"`
+}
+
+// Cassette holds the interactions of a file. A Cassette is safe for concurrent use.
+type Cassette struct {
+	path string
+	mode Mode
+
+	redactors []Redactor
+
+	mu           sync.Mutex
+	interactions []*Interaction
+	// replayed counts how many interactions of each key have been replayed
+	replayed map[string]int
+}
+
+// Option is used to configure a cassette
+type Option func(c *Cassette)
+
+// WithRedactor optionally rewrites the interactions before they are recorded, after the access tokens and
+// signatures have been redacted, e.g. to redact the personal data of the responses.
+func WithRedactor(r Redactor) Option {
+	return func(c *Cassette) {
+		c.redactors = append(c.redactors, r)
+	}
+}
+
+// Load reads the cassette at path to replay its interactions.
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read cassette: %w", err)
+	}
+	c := &Cassette{path: path, mode: ModeReplay, replayed: make(map[string]int)}
+	if err = json.Unmarshal(data, &c.interactions); err != nil {
+		return nil, fmt.Errorf("decode cassette %s: %w", path, err)
+	}
+	// Cassettes recorded before signatures were stripped keep matching
+	for _, i := range c.interactions {
+		if !strings.Contains(i.Request.Key, "/graphql.json ") {
+			i.Request.Key = i.Request.Method + " " + stripSignature(i.Request.URL)
+		}
+	}
+	return c, nil
+}
+
+// NewRecorder returns a cassette recording the interactions, written to path by Save.
+func NewRecorder(path string, opts ...Option) *Cassette {
+	c := &Cassette{path: path, mode: ModeRecord, replayed: make(map[string]int)}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// New returns a recorder when mode is ModeRecord, or loads the cassette at path otherwise.
+func New(path string, mode Mode, opts ...Option) (*Cassette, error) {
+	if mode == ModeRecord {
+		return NewRecorder(path, opts...), nil
+	}
+	return Load(path)
+}
+
+// Mode returns the mode of the cassette.
+func (c *Cassette) Mode() Mode {
+	return c.mode
+}
+
+// Interactions returns the interactions recorded or loaded so far.
+func (c *Cassette) Interactions() []Interaction {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]Interaction, 0, len(c.interactions))
+	for _, i := range c.interactions {
+		out = append(out, *i)
+	}
+	return out
+}
+
+// Save writes the recorded interactions to the cassette file, creating its directory if needed.
+// It does nothing in replay mode.
+func (c *Cassette) Save() error {
+	if c.mode != ModeRecord {
+		return nil
+	}
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("encode cassette: %w", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return fmt.Errorf("create cassette directory: %w", err)
+	}
+	if err = os.WriteFile(c.path, data, 0o644); err != nil {
+		return fmt.Errorf("write cassette: %w", err)
+	}
+	return nil
+}
+
+// Wrap returns a round tripper recording the requests sent through next, or replaying them without calling next.
+// It has the signature of a graph.Middleware.
+func (c *Cassette) Wrap(next http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, err := readBody(req)
+		if err != nil {
+			return nil, err
+		}
+		key := requestKey(req, body)
+		if c.mode == ModeReplay {
+			return c.replay(req, key)
+		}
+		return c.record(next, req, key, body)
+	})
+}
+
+func (c *Cassette) replay(req *http.Request, key string) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var matches []*Interaction
+	for _, i := range c.interactions {
+		if i.Request.Key == key {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w in %s for %s", ErrNoRecording, c.path, key)
+	}
+	// Identical requests, such as bulk operation polling, are replayed in the recorded order, the last one repeating
+	n := c.replayed[key]
+	if n >= len(matches) {
+		n = len(matches) - 1
+	}
+	c.replayed[key]++
+
+	recorded := matches[n].Response
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Headers.Clone(),
+		Body:          io.NopCloser(strings.NewReader(recorded.Body)),
+		ContentLength: int64(len(recorded.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (c *Cassette) record(next http.RoundTripper, req *http.Request, key string, body []byte) (*http.Response, error) {
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	headers := req.Header.Clone()
+	for _, name := range redactedHeaders {
+		if headers.Get(name) != "" {
+			headers.Set(name, redacted)
+		}
+	}
+	respHeaders := resp.Header.Clone()
+	respHeaders.Del("Set-Cookie")
+
+	recorded := &Interaction{
+		Request: Request{
+			Key:     key,
+			Method:  req.Method,
+			URL:     stripSignature(req.URL.String()),
+			Headers: headers,
+		},
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Headers:    respHeaders,
+			Body:       redactBody(string(respBody)),
+		},
+	}
+	if isGraphQL(req) {
+		recorded.Request.Body = string(body)
+	}
+	for _, redact := range c.redactors {
+		redact(recorded)
+	}
+
+	c.mu.Lock()
+	c.interactions = append(c.interactions, recorded)
+	c.mu.Unlock()
+	return resp, nil
+}
+
+// readBody reads the body of req and replaces it, so that it can still be sent.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read request body: %w", err)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,190 @@
+package cassette_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gempages/go-shopify-graphql/cassette"
+	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
+)
+
+func TestRecordReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testdata", "products.json")
+	ctx := context.Background()
+
+	srv := shopifytest.NewServer()
+	productID := srv.AddProduct(map[string]any{"title": "Blue Shirt"})
+	srv.AddProduct(map[string]any{"title": "Red Shirt"})
+
+	recorder := cassette.NewRecorder(path)
+	client := srv.Client(graphqlclient.WithCassette(recorder))
+	product, err := client.Product.Get(ctx, productID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Bulk operations download their result outside the GraphQL endpoint
+	products, err := client.Product.List(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var v map[string]any
+	err = client.GraphQLClient().QueryString(ctx, "query { shop { id } }", nil, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = recorder.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	srv.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(string(data), shopifytest.AccessToken) {
+		t.Errorf("expected access token to be redacted")
+	}
+
+	c, err := cassette.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Interactions()) != len(recorder.Interactions()) {
+		t.Errorf("expected (%v) interactions, got (%v)", len(recorder.Interactions()), len(c.Interactions()))
+	}
+	client = srv.Client(graphqlclient.WithCassette(c))
+
+	replayedProduct, err := client.Product.Get(ctx, productID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if replayedProduct.ID != product.ID || replayedProduct.Title != product.Title {
+		t.Errorf("expected (%+v), got (%+v)", product, replayedProduct)
+	}
+	replayedProducts, err := client.Product.List(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(replayedProducts) != len(products) {
+		t.Errorf("expected (%v) products, got (%v)", len(products), len(replayedProducts))
+	}
+
+	// Formatting differences don't matter
+	err = client.GraphQLClient().QueryString(ctx, "query {\n  shop {\n    id\n  }\n}", nil, &v)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v["shop"].(map[string]any)["id"] != shopifytest.ShopID {
+		t.Errorf("expected (%v), got (%v)", shopifytest.ShopID, v)
+	}
+
+	err = client.GraphQLClient().QueryString(ctx, "query { shop { name } }", nil, &v)
+	if !errors.Is(err, cassette.ErrNoRecording) {
+		t.Errorf("expected (%v), got (%v)", cassette.ErrNoRecording, err)
+	}
+}
+
+func TestReplayOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metafields.json")
+	ctx := context.Background()
+	query := "query { shop { metafield(namespace: \"app\", key: \"plan\") { value } } }"
+
+	srv := shopifytest.NewServer()
+	recorder := cassette.NewRecorder(path)
+	client := srv.Client(graphqlclient.WithCassette(recorder))
+	var values []any
+	for _, plan := range []string{"basic", "pro"} {
+		srv.AddMetafield(shopifytest.ShopID, "app", "plan", plan, "single_line_text_field")
+		var v map[string]any
+		if err := client.GraphQLClient().QueryString(ctx, query, nil, &v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		values = append(values, v["shop"])
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	srv.Close()
+
+	c, err := cassette.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client = srv.Client(graphqlclient.WithCassette(c))
+	// The last recording repeats
+	for i, expected := range append(values, values[1]) {
+		var v map[string]any
+		if err = client.GraphQLClient().QueryString(ctx, query, nil, &v); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := v["shop"].(map[string]any)["metafield"]; got.(map[string]any)["value"] != expected.(map[string]any)["metafield"].(map[string]any)["value"] {
+			t.Errorf("expected (%v) on request %d, got (%v)", expected, i, got)
+		}
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload.json")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"shpat_secret","email":"owner@example.com",` +
+			`"url":"https://storage.example.com/file?X-Goog-Signature=abc&X-Goog-Date=20240101&name=a",` +
+			`"parameters":[{"name":"policy","value":"secret-policy"},{"name":"key","value":"tmp/a"}]}`))
+	}))
+	defer server.Close()
+
+	recorder := cassette.NewRecorder(path, cassette.WithRedactor(func(i *cassette.Interaction) {
+		i.Response.Body = strings.ReplaceAll(i.Response.Body, "owner@example.com", "[EMAIL]")
+	}))
+	client := &http.Client{Transport: recorder.Wrap(http.DefaultTransport)}
+	resp, err := client.Get(server.URL + "/file?X-Goog-Signature=abc&name=a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	live, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	// The client gets the response as is
+	if !strings.Contains(string(live), "shpat_secret") {
+		t.Errorf("expected the live response to be left as is, got (%s)", live)
+	}
+	if err = recorder.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, secret := range []string{"shpat_secret", "owner@example.com", "X-Goog-Signature", "X-Goog-Date", "secret-policy"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("expected (%v) to be redacted", secret)
+		}
+	}
+	if !strings.Contains(string(data), "tmp/a") {
+		t.Errorf("expected unsigned parameters to be kept")
+	}
+
+	c, err := cassette.Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client = &http.Client{Transport: c.Wrap(http.DefaultTransport)}
+	// Another signature of the same URL matches the recording
+	resp, err = client.Get(server.URL + "/file?X-Goog-Signature=def&name=a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = resp.Body.Close()
+	_, err = client.Get(server.URL + "/file?name=b")
+	if !errors.Is(err, cassette.ErrNoRecording) {
+		t.Errorf("expected (%v), got (%v)", cassette.ErrNoRecording, err)
+	}
+}
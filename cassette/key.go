@@ -0,0 +1,62 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/formatter"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// requestKey returns the normalized form of a request: its method and path followed by the normalized query
+// and variables for GraphQL requests, its method and URL without its signature otherwise.
+// The host is left out of GraphQL keys, so that a cassette can be replayed against another shop.
+func requestKey(req *http.Request, body []byte) string {
+	if !isGraphQL(req) {
+		return req.Method + " " + stripSignature(req.URL.String())
+	}
+
+	var in struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&in); err != nil {
+		return req.Method + " " + req.URL.Path + " " + normalizeWhitespace(string(body))
+	}
+
+	key := req.Method + " " + req.URL.Path + " " + normalizeQuery(in.Query)
+	if in.OperationName != "" {
+		key += " operationName=" + in.OperationName
+	}
+	if len(in.Variables) > 0 {
+		// Maps are encoded with sorted keys
+		variables, _ := json.Marshal(in.Variables)
+		key += " variables=" + string(variables)
+	}
+	return key
+}
+
+// normalizeQuery formats query in a canonical way, so that whitespace and comma differences don't matter.
+func normalizeQuery(query string) string {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	if err != nil {
+		return normalizeWhitespace(query)
+	}
+	var buf bytes.Buffer
+	formatter.NewFormatter(&buf).FormatQueryDocument(doc)
+	return normalizeWhitespace(buf.String())
+}
+
+func normalizeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func isGraphQL(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/graphql.json")
+}
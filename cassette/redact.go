@@ -0,0 +1,142 @@
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// Redactor rewrites an interaction before it is stored by a recorder, e.g. to redact secrets from the bodies.
+// It doesn't change the response returned to the client, nor the key the request is matched by on replay.
+type Redactor func(i *Interaction)
+
+// signedQueryParams are the query parameters of presigned URLs, such as staged upload targets and bulk operation
+// result files, which are short-lived credentials. They are stripped from the recorded URLs and ignored when
+// matching requests. The names are compared in lower case.
+var signedQueryParams = map[string]bool{
+	"x-goog-algorithm":     true,
+	"x-goog-credential":    true,
+	"x-goog-date":          true,
+	"x-goog-expires":       true,
+	"x-goog-signature":     true,
+	"x-goog-signedheaders": true,
+	"x-amz-algorithm":      true,
+	"x-amz-credential":     true,
+	"x-amz-date":           true,
+	"x-amz-expires":        true,
+	"x-amz-security-token": true,
+	"x-amz-signature":      true,
+	"x-amz-signedheaders":  true,
+	"googleaccessid":       true,
+	"expires":              true,
+	"signature":            true,
+}
+
+// signedFormParams are the names of the staged upload parameters holding credentials, in lower case.
+var signedFormParams = map[string]bool{
+	"policy":               true,
+	"signature":            true,
+	"googleaccessid":       true,
+	"x-goog-credential":    true,
+	"x-goog-signature":     true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+	"x-amz-signature":      true,
+}
+
+// redactedBodyFields are the JSON fields of the bodies holding credentials, such as the token returned by
+// oauth/access_token.
+var redactedBodyFields = map[string]bool{
+	"access_token": true,
+}
+
+// stripSignature returns rawURL without its signed query parameters.
+func stripSignature(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	query := u.Query()
+	stripped := false
+	for name := range query {
+		if signedQueryParams[strings.ToLower(name)] {
+			query.Del(name)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return rawURL
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// redactBody redacts the credentials of a JSON body: access tokens, signed URLs and signed staged upload
+// parameters. Other bodies are returned as is.
+func redactBody(body string) string {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return body
+	}
+	if !redactValue(v) {
+		return body
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return body
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// redactValue redacts v in place and reports whether anything was redacted.
+func redactValue(v any) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]any:
+		// Staged upload parameters are name/value pairs
+		if name, ok := v["name"].(string); ok && signedFormParams[strings.ToLower(name)] {
+			if _, ok = v["value"].(string); ok {
+				v["value"] = redacted
+				changed = true
+			}
+		}
+		for key, value := range v {
+			if s, ok := value.(string); ok {
+				if redactedBodyFields[key] {
+					v[key] = redacted
+					changed = true
+				} else if stripped := stripSignature(s); stripped != s && isURL(s) {
+					v[key] = stripped
+					changed = true
+				}
+				continue
+			}
+			if redactValue(value) {
+				changed = true
+			}
+		}
+	case []any:
+		for i, value := range v {
+			if s, ok := value.(string); ok {
+				if stripped := stripSignature(s); stripped != s && isURL(s) {
+					v[i] = stripped
+					changed = true
+				}
+				continue
+			}
+			if redactValue(value) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
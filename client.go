@@ -1,12 +1,12 @@
 package shopify
 
 import (
+	"errors"
 	"os"
+	"time"
 
 	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
 	"github.com/gempages/go-shopify-graphql/graphql"
-
-	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -16,6 +16,11 @@ const (
 
 type Client struct {
 	gql *graphql.Client
+	// bulkCoordinator limits the bulk operations run at once, defaultBulkCoordinator if nil
+	bulkCoordinator *BulkCoordinator
+	// bulkNotifier notifies the finished bulk operations, nil to poll them
+	bulkNotifier        BulkOperationNotifier
+	bulkNotifierTimeout time.Duration
 
 	Product             ProductService
 	Variant             VariantService
@@ -44,17 +49,20 @@ type ListOptions struct {
 	Reverse bool
 }
 
-func NewDefaultClient() (shopClient *Client) {
+// ErrMissingCredentials is returned by NewDefaultClient when the environment doesn't hold the private app credentials.
+var ErrMissingCredentials = errors.New("shopify app API Key and/or Password and/or Store Name not set")
+
+// NewDefaultClient returns a new Shopify Admin GRAPHQL client with the private app credentials
+// of the STORE_API_KEY, STORE_PASSWORD and STORE_NAME environment variables.
+func NewDefaultClient() (*Client, error) {
 	apiKey := os.Getenv("STORE_API_KEY")
 	password := os.Getenv("STORE_PASSWORD")
 	storeName := os.Getenv("STORE_NAME")
 	if apiKey == "" || password == "" || storeName == "" {
-		log.Fatalln("Shopify app API Key and/or Password and/or Store Name not set")
+		return nil, ErrMissingCredentials
 	}
 
-	shopClient = NewClient(apiKey, password, storeName)
-
-	return
+	return NewClient(apiKey, password, storeName), nil
 }
 
 // NewClient returns a new Shopify Admin GRAPHQL client with
@@ -98,6 +106,41 @@ func (c *Client) SetRetries(retryCount int) {
 	c.gql.SetRetries(retryCount)
 }
 
+// SetRetryPolicy sets the policy used to retry failed requests
+func (c *Client) SetRetryPolicy(policy graphql.RetryPolicy) {
+	c.gql.SetRetryPolicy(policy)
+}
+
+// SetResponseHook sets a hook which receives the query cost and throttle status of every request
+func (c *Client) SetResponseHook(hook graphql.ResponseHook) {
+	c.gql.SetResponseHook(hook)
+}
+
+// SetBulkCoordinator sets the coordinator limiting the bulk operations run at once for the shop of the client.
+// Defaults to a coordinator shared by all the clients, running DefaultBulkConcurrency operations of each type per shop.
+func (c *Client) SetBulkCoordinator(coordinator *BulkCoordinator) {
+	c.bulkCoordinator = coordinator
+}
+
+// SetBulkOperationNotifier makes the client wait for the notification of notifier, e.g. a BulkNotifier handling
+// the bulk_operations/finish webhooks, instead of polling the bulk operations until they finish.
+// If no notification arrives within timeout, DefaultBulkNotificationTimeout if zero, the client polls the operation
+// with a backoff. A nil notifier restores polling.
+func (c *Client) SetBulkOperationNotifier(notifier BulkOperationNotifier, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultBulkNotificationTimeout
+	}
+	c.bulkNotifier = notifier
+	c.bulkNotifierTimeout = timeout
+}
+
+func (c *Client) bulkOperationCoordinator() *BulkCoordinator {
+	if c.bulkCoordinator == nil {
+		return defaultBulkCoordinator
+	}
+	return c.bulkCoordinator
+}
+
 // NewClientWithOpts returns a new Shopify GRAPHQL client with custom graphql options
 func NewClientWithOpts(storeName string, opts ...graphqlclient.Option) *Client {
 	c := &Client{gql: graphqlclient.NewClient(storeName, opts...)}
@@ -125,6 +168,9 @@ func NewClientWithOpts(storeName string, opts ...graphqlclient.Option) *Client {
 // NewClientWithToken returns a new Shopify Admin GRAPHQL client with
 //
 //	authenticated domain and token
+//
+// The client has its own cost limiter, use a ClientPool rather than building a client per request so that
+// the requests to a shop share its rate limit state.
 func NewClientWithToken(apiKey string, storeName string) *Client {
 	c := &Client{gql: newShopifyGraphQLClientWithToken(apiKey, storeName)}
 
@@ -6,7 +6,6 @@ import (
 
 	"github.com/gempages/go-helper/errors"
 	"github.com/gempages/go-shopify-graphql-model/graph/model"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/gempages/go-shopify-graphql/graphql"
 )
@@ -312,7 +311,7 @@ func (s *CollectionServiceOp) CreateBulk(ctx context.Context, collections []mode
 	for _, c := range collections {
 		_, err := s.client.Collection.Create(ctx, c)
 		if err != nil {
-			log.Warnf("Couldn't create collection (%v): %s", c, err)
+			s.client.gql.Logger().WarnContext(ctx, "Couldn't create collection", graphql.LogKeyOperation, "collectionCreate", "collection", c, "error", err)
 		}
 	}
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 
+	gperrors "github.com/gempages/go-helper/errors"
 	"github.com/gempages/go-shopify-graphql-model/graph/model"
 
 	"github.com/gempages/go-shopify-graphql/graphql"
@@ -27,16 +28,18 @@ func NewDiscountErrorf(code model.DiscountErrorCode, format string, args ...any)
 	return &DiscountError{Code: code, Message: fmt.Sprintf(format, args...)}
 }
 
+// IsInvalidTokenError checks if Shopify rejected the access token, which it answers with 401 Unauthorized.
 func IsInvalidTokenError(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "Invalid API key or access token")
+	return err != nil && errors.Is(err, graphql.ErrUnauthorized)
 }
 
 func IsInvalidStorefrontTokenError(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "401 Unauthorized")
+	return err != nil && errors.Is(err, graphql.ErrUnauthorized)
 }
 
+// IsPermissionError checks if the request was forbidden or a field was denied because of missing access scopes.
 func IsPermissionError(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "403 Forbidden")
+	return err != nil && (errors.Is(err, graphql.ErrForbidden) || errors.Is(err, graphql.ErrAccessDenied))
 }
 
 func IsPaymentRequiredError(err error) bool {
@@ -47,17 +50,30 @@ func IsLockedError(err error) bool {
 	return err != nil && errors.Is(err, graphql.ErrLocked)
 }
 
+// IsRateLimitError checks if the request was throttled.
+// Shopify also rejects too frequent changes of some resources with a user error, which has no code, so its message is matched.
 func IsRateLimitError(err error) bool {
 	if err == nil {
 		return false
 	}
 	return errors.Is(err, graphql.ErrMaxCostExceeded) ||
-		strings.Contains(err.Error(), "Reduce request rates to resume uninterrupted service") ||
+		errors.Is(err, graphql.ErrThrottled) ||
+		errors.Is(err, graphql.ErrTooManyRequests) ||
+		// Returned as a user error, which has no code
 		strings.Contains(err.Error(), "The rate of change to")
 }
 
+// IsNotExistError checks if the requested resource doesn't exist.
+// Shopify reports missing resources through user errors without a code, so their messages are matched as a last resort.
 func IsNotExistError(err error) bool {
-	return err != nil && (strings.Contains(err.Error(), "doesn't exist") || strings.Contains(err.Error(), "does not exist"))
+	if err == nil {
+		return false
+	}
+	var notExistErr *gperrors.NotExistsError
+	if errors.As(err, &notExistErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "doesn't exist") || strings.Contains(err.Error(), "does not exist")
 }
 
 // IsValidationDiscountError checks if the error indicates that the active period in discount overlaps with another price rule.
@@ -73,6 +89,8 @@ func IsValidationDiscountError(err error) bool {
 	return false
 }
 
+// IsFileNotExistError checks if the files to update or delete don't exist.
+// The services return the user errors of the files mutations formatted as text, so the FILE_DOES_NOT_EXIST code is matched in it.
 func IsFileNotExistError(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "FILE_DOES_NOT_EXIST")
 }
@@ -101,6 +119,8 @@ func IsGatewayTimeout(err error) bool {
 	return err != nil && errors.Is(err, graphql.ErrGatewayTimeout)
 }
 
+// IsAddressTakenError checks if a webhook subscription already exists for the topic and address.
+// The user error returned by Shopify has no code, so its message is matched.
 func IsAddressTakenError(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "Address for this topic has already been taken")
 }
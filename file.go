@@ -155,7 +155,7 @@ func (s *FileServiceOp) Upload(ctx context.Context, input *UploadInput) (model.F
 
 func (s *FileServiceOp) upload(ctx context.Context, input *UploadInput) (*model.FileCreatePayload, error) {
 	fileSizeStr := cast.ToString(input.FileSize)
-	stageCreated, err := s.stagedUploadsCreate(fileSizeStr, input.Filename, input.Mimetype)
+	stageCreated, err := s.stagedUploadsCreate(ctx, fileSizeStr, input.Filename, input.Mimetype, fileTargetResource(input.Mimetype))
 	if err != nil {
 		return nil, fmt.Errorf("s.stagedUploadsCreate: %w", err)
 	}
@@ -174,12 +174,13 @@ func (s *FileServiceOp) upload(ctx context.Context, input *UploadInput) (*model.
 	return result, nil
 }
 
-func (s *FileServiceOp) stagedUploadsCreate(fileSize, fileName, mimetype string) (*model.StagedMediaUploadTarget, error) {
+func (s *FileServiceOp) stagedUploadsCreate(
+	ctx context.Context, fileSize, fileName, mimetype string, resource model.StagedUploadTargetGenerateUploadResource,
+) (*model.StagedMediaUploadTarget, error) {
 	m := mutationStagedUploadsCreate{}
 	method := model.StagedUploadHTTPMethodTypePost
 
-	resource := fileTargetResource(mimetype)
-	err := s.client.gql.Mutate(context.Background(), &m, map[string]interface{}{
+	err := s.client.gql.Mutate(ctx, &m, map[string]interface{}{
 		"input": []model.StagedUploadInput{
 			{
 				FileSize:   &fileSize,
@@ -217,7 +218,7 @@ func (s *FileServiceOp) uploadFileToStage(
 		"Content-Length": fileSize,
 	}
 
-	err = performHTTPPostWithHeaders(ctx, *postTempTargetURL, multiForm.data, postTempTargetHeaders)
+	err = performHTTPPostWithHeaders(ctx, s.client.gql.ExternalHTTPClient(), *postTempTargetURL, multiForm.data, postTempTargetHeaders)
 	if err != nil {
 		return err
 	}
@@ -381,7 +382,7 @@ func createMultipartFormWithFile(
 	}, nil
 }
 
-func performHTTPPostWithHeaders(ctx context.Context, url string, body io.Reader, headers map[string]string) error {
+func performHTTPPostWithHeaders(ctx context.Context, client *http.Client, url string, body io.Reader, headers map[string]string) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
 	if err != nil {
 		return err
@@ -391,9 +392,9 @@ func performHTTPPostWithHeaders(ctx context.Context, url string, body io.Reader,
 		req.Header.Set(key, value)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("DefaultClient.Do: %w", err)
+		return fmt.Errorf("client.Do: %w", err)
 	}
 	defer resp.Body.Close()
 
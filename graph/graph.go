@@ -2,9 +2,13 @@ package graphqlclient
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 
+	"github.com/gempages/go-shopify-graphql/cassette"
 	"github.com/gempages/go-shopify-graphql/graphql"
+	"github.com/gempages/go-shopify-graphql/tracing"
 )
 
 const (
@@ -61,6 +65,108 @@ func WithPrivateAppAuth(apiKey string, password string) Option {
 	}
 }
 
+// WithCostLimiter optionally sets the limiter used to throttle queries by their cost.
+// By default, each client has its own limiter, so a client built per request starts with a full bucket and doesn't
+// throttle at all: pass the same limiter to the clients of a shop for them to share its leaky bucket, as
+// shopify.ClientPool does, or nil to disable cost-aware throttling.
+func WithCostLimiter(limiter *graphql.CostLimiter) Option {
+	return func(t *transport) {
+		t.costLimiter = limiter
+		t.costLimiterSet = true
+	}
+}
+
+// WithResponseHook optionally sets a hook which receives the query cost and throttle status of every request
+func WithResponseHook(hook graphql.ResponseHook) Option {
+	return func(t *transport) {
+		t.responseHook = hook
+	}
+}
+
+// WithRetryPolicy optionally sets the policy used to retry failed requests
+func WithRetryPolicy(policy graphql.RetryPolicy) Option {
+	return func(t *transport) {
+		t.retryPolicy = &policy
+	}
+}
+
+// WithPartialData optionally enables partial data mode, see graphql.Client.SetPartialData
+func WithPartialData() Option {
+	return func(t *transport) {
+		t.partialData = true
+	}
+}
+
+// WithBaseURL optionally sets the scheme, host and path prefix of the API endpoint, e.g. "http://127.0.0.1:8080/shopify",
+// to point the client at a local stand-in of Shopify. The API version and Storefront path rules still apply.
+// Defaults to "https://<shop domain>".
+func WithBaseURL(baseURL string) Option {
+	return func(t *transport) {
+		t.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// Middleware wraps a http.RoundTripper with additional behavior, such as logging or recording.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// WithHTTPClient optionally sets the HTTP client used to send requests, e.g. to configure a timeout.
+// The authentication transport is layered on top of the client's transport.
+func WithHTTPClient(client *http.Client) Option {
+	return func(t *transport) {
+		t.httpClient = client
+	}
+}
+
+// WithBaseTransport optionally sets the transport that sends requests after the authentication transport
+// and middlewares, e.g. to configure a proxy or connection pool. Defaults to http.DefaultTransport.
+func WithBaseTransport(base http.RoundTripper) Option {
+	return func(t *transport) {
+		t.base = base
+	}
+}
+
+// WithMiddleware optionally adds middlewares between the authentication transport and the base transport.
+// The first middleware added is the outermost one.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(t *transport) {
+		t.middlewares = append(t.middlewares, middlewares...)
+	}
+}
+
+// WithCassette optionally records the requests of the client to the cassette, or replays them from it,
+// including bulk operation result downloads and staged uploads. The cassette is the innermost middleware.
+func WithCassette(c *cassette.Cassette) Option {
+	return func(t *transport) {
+		t.cassette = c
+	}
+}
+
+// WithTracer optionally sets the tracer of GraphQL requests, bulk operations and downloads, e.g. oteltracing.New.
+// Spans carry the shop domain and API version. Defaults to tracing.Sentry(), pass nil to disable tracing.
+func WithTracer(tracer tracing.Tracer) Option {
+	return func(t *transport) {
+		t.tracer = tracer
+		t.tracerSet = true
+	}
+}
+
+// WithLogger optionally sets the logger of the client, logging with the shop domain under the "shop" key.
+// Defaults to slog.Default(), pass nil to discard the logs.
+func WithLogger(logger *slog.Logger) Option {
+	return func(t *transport) {
+		t.logger = logger
+		t.loggerSet = true
+	}
+}
+
+// WithMetrics optionally sets the hook receiving the measurements of the client, e.g. prommetrics.New,
+// labeled with the shop domain
+func WithMetrics(metrics graphql.Metrics) Option {
+	return func(t *transport) {
+		t.metrics = metrics
+	}
+}
+
 type transport struct {
 	accessToken           string
 	storeFrontAccessToken string
@@ -68,9 +174,28 @@ type transport struct {
 	password              string
 	apiVersion            string
 	apiPath               string
+	baseURL               string
+	costLimiter           *graphql.CostLimiter
+	costLimiterSet        bool
+	responseHook          graphql.ResponseHook
+	retryPolicy           *graphql.RetryPolicy
+	partialData           bool
+	httpClient            *http.Client
+	base                  http.RoundTripper
+	middlewares           []Middleware
+	cassette              *cassette.Cassette
+	tracer                tracing.Tracer
+	tracerSet             bool
+	logger                *slog.Logger
+	loggerSet             bool
+	metrics               graphql.Metrics
+	// next is the round tripper chain built from the base transport and middlewares
+	next http.RoundTripper
 }
 
 func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A round tripper must not modify the original request
+	req = req.Clone(req.Context())
 	if t.accessToken != "" {
 		req.Header.Set(shopifyAccessTokenHeader, t.accessToken)
 	} else if t.apiKey != "" && t.password != "" {
@@ -79,7 +204,7 @@ func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		req.Header.Set(shopifyStoreFrontAccessTokenHeader, t.storeFrontAccessToken)
 	}
 
-	return http.DefaultTransport.RoundTrip(req)
+	return t.next.RoundTrip(req)
 }
 
 // NewClient creates a new client (in fact, just a simple wrapper for a graphql.Client)
@@ -93,15 +218,71 @@ func NewClient(shopifyDomain string, opts ...Option) *graphql.Client {
 		opt(trans)
 	}
 
-	httpClient := &http.Client{Transport: trans}
-	url := buildAPIEndpoint(shopifyDomain, trans.apiPath, trans.apiVersion)
+	next := trans.base
+	if next == nil && trans.httpClient != nil {
+		next = trans.httpClient.Transport
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if trans.cassette != nil {
+		next = trans.cassette.Wrap(next)
+	}
+	for i := len(trans.middlewares) - 1; i >= 0; i-- {
+		next = trans.middlewares[i](next)
+	}
+	trans.next = next
+
+	httpClient := &http.Client{}
+	if trans.httpClient != nil {
+		*httpClient = *trans.httpClient
+	}
+	httpClient.Transport = trans
+	baseURL := trans.baseURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("%s://%s", apiProtocol, shopifyDomain)
+	}
+	url := buildAPIEndpoint(baseURL, trans.apiPath, trans.apiVersion)
 	graphClient := graphql.NewClient(url, httpClient)
+	// Requests outside the GraphQL endpoint go through the same chain, without the shop credentials
+	graphClient.SetExternalHTTPClient(&http.Client{Transport: next})
+	if trans.costLimiterSet {
+		graphClient.SetCostLimiter(trans.costLimiter)
+	} else {
+		graphClient.SetCostLimiter(graphql.NewCostLimiter())
+	}
+	graphClient.SetResponseHook(trans.responseHook)
+	if trans.retryPolicy != nil {
+		graphClient.SetRetryPolicy(*trans.retryPolicy)
+	}
+	graphClient.SetPartialData(trans.partialData)
+	tracer := tracing.Sentry()
+	if trans.tracerSet {
+		tracer = trans.tracer
+	}
+	if tracer != nil {
+		tracer = tracing.WithAttributes(tracer,
+			tracing.Attr(tracing.AttrShopDomain, shopifyDomain),
+			tracing.Attr(tracing.AttrAPIVersion, trans.apiVersion),
+		)
+	}
+	graphClient.SetTracer(tracer)
+	logger := slog.Default()
+	if trans.loggerSet {
+		logger = trans.logger
+	}
+	if logger != nil {
+		logger = logger.With(graphql.LogKeyShop, shopifyDomain)
+	}
+	graphClient.SetLogger(logger)
+	graphClient.SetMetrics(trans.metrics)
+	graphClient.SetShop(shopifyDomain)
 	return graphClient
 }
 
-func buildAPIEndpoint(domain string, path string, version string) string {
+func buildAPIEndpoint(baseURL string, path string, version string) string {
 	if version == defaultAPIVersion {
-		return fmt.Sprintf("%s://%s/%s/%s", apiProtocol, domain, path, apiEndpoint)
+		return fmt.Sprintf("%s/%s/%s", baseURL, path, apiEndpoint)
 	}
-	return fmt.Sprintf("%s://%s/%s/%s/%s", apiProtocol, domain, path, version, apiEndpoint)
+	return fmt.Sprintf("%s/%s/%s/%s", baseURL, path, version, apiEndpoint)
 }
@@ -0,0 +1,149 @@
+package graphqlclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql/graphql"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransportChain(t *testing.T) {
+	var (
+		calls []string
+		token string
+		url   string
+	)
+	base := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls = append(calls, "base")
+		token = req.Header.Get(shopifyAccessTokenHeader)
+		url = req.URL.String()
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"data":{"shop":{"name":"test"}}}`)),
+			Request:    req,
+		}, nil
+	})
+	middleware := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				calls = append(calls, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	client := NewClient("test.myshopify.com",
+		WithToken("token"),
+		WithVersion("2024-10"),
+		WithHTTPClient(&http.Client{}),
+		WithBaseTransport(base),
+		WithMiddleware(middleware("first"), middleware("second")),
+	)
+
+	var v interface{}
+	err := client.QueryString(context.Background(), "{shop{name}}", nil, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(calls, ",") != "first,second,base" {
+		t.Errorf("unexpected round trip order %v", calls)
+	}
+	if token != "token" {
+		t.Errorf("expected the access token to be set, got %q", token)
+	}
+	if url != "https://test.myshopify.com/admin/api/2024-10/graphql.json" {
+		t.Errorf("unexpected URL %v", url)
+	}
+
+	calls = nil
+	resp, err := client.ExternalHTTPClient().Get("https://storage.googleapis.com/result.jsonl")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if strings.Join(calls, ",") != "first,second,base" {
+		t.Errorf("unexpected round trip order %v", calls)
+	}
+	if token != "" {
+		t.Errorf("expected no access token on external requests, got %q", token)
+	}
+}
+
+func TestWithBaseURL(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":{"shop":{"name":"test"}}}`))
+	}))
+	defer server.Close()
+
+	clients := []*graphql.Client{
+		NewClient("test.myshopify.com", WithToken("token"), WithBaseURL(server.URL+"/shopify/")),
+		NewClient("test.myshopify.com", WithToken("token"), WithBaseURL(server.URL), WithVersion("2024-10")),
+		NewClient("test.myshopify.com", WithStoreFrontToken("token"), WithBaseURL(server.URL), WithStoreFrontVersion("2024-10")),
+	}
+	for _, client := range clients {
+		var v interface{}
+		err := client.QueryString(context.Background(), "{shop{name}}", nil, &v)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	want := []string{"/shopify/admin/api/graphql.json", "/admin/api/2024-10/graphql.json", "/api/2024-10/graphql.json"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("expected (%v), got (%v)", want, paths)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":{"shop":{"name":"test"}}}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := NewClient("test.myshopify.com",
+		WithToken("token"),
+		WithBaseURL(server.URL),
+		WithRetryPolicy(graphql.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+		WithLogger(logger),
+	)
+	var v interface{}
+	err := client.QueryString(context.Background(), "{shop{name}}", nil, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var record map[string]any
+	if err = json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single log record, got (%s)", buf.String())
+	}
+	if record[graphql.LogKeyShop] != "test.myshopify.com" || record[graphql.LogKeyOperation] != "shop" {
+		t.Errorf("expected shop and operation fields, got (%v)", record)
+	}
+}
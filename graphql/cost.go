@@ -0,0 +1,174 @@
+package graphql
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// maxTrackedQueries bounds the number of query costs remembered by a CostLimiter, the least recently used being evicted.
+const maxTrackedQueries = 1000
+
+// defaultQueryCost is the estimate used for a query that hasn't been sent yet.
+const defaultQueryCost = 1
+
+// QueryCost represents the "extensions.cost" object returned with every Shopify Admin API response.
+type QueryCost struct {
+	RequestedQueryCost float64         `json:"requestedQueryCost"`
+	ActualQueryCost    *float64        `json:"actualQueryCost"`
+	ThrottleStatus     *ThrottleStatus `json:"throttleStatus"`
+}
+
+// ThrottleStatus represents the state of the shop's leaky bucket after a request.
+type ThrottleStatus struct {
+	MaximumAvailable   float64 `json:"maximumAvailable"`
+	CurrentlyAvailable float64 `json:"currentlyAvailable"`
+	RestoreRate        float64 `json:"restoreRate"`
+}
+
+type extensions struct {
+	Cost *QueryCost `json:"cost"`
+}
+
+// CostLimiter models Shopify's leaky bucket for a single shop and blocks queries
+// until enough points are available for their requested cost.
+// A CostLimiter is safe for concurrent use and should be shared by every client of the same shop.
+type CostLimiter struct {
+	mu          sync.Mutex
+	maximum     float64
+	available   float64
+	restoreRate float64
+	updatedAt   time.Time
+	// pending is the sum of points reserved by requests that haven't got a response yet.
+	pending float64
+	// costs remembers the requested cost of the recent queries, keyed by the hash of their text,
+	// the least recently used ones being evicted
+	costs    map[uint64]*list.Element
+	costsLRU *list.List
+}
+
+type trackedCost struct {
+	key  uint64
+	cost float64
+}
+
+// NewCostLimiter returns a CostLimiter that doesn't block until it has seen a throttle status.
+func NewCostLimiter() *CostLimiter {
+	return &CostLimiter{
+		costs:    make(map[uint64]*list.Element),
+		costsLRU: list.New(),
+	}
+}
+
+// Status returns the estimated current state of the bucket.
+func (l *CostLimiter) Status() ThrottleStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	return ThrottleStatus{
+		MaximumAvailable:   l.maximum,
+		CurrentlyAvailable: l.available,
+		RestoreRate:        l.restoreRate,
+	}
+}
+
+// reserve waits until the bucket can afford query and takes its estimated cost out of it.
+// It returns the reserved points, which must be handed back to release.
+func (l *CostLimiter) reserve(ctx context.Context, query string) (float64, error) {
+	for {
+		l.mu.Lock()
+		cost := l.cost(query)
+		// Nothing is known about the bucket yet
+		if l.restoreRate <= 0 {
+			l.pending += cost
+			l.mu.Unlock()
+			return cost, nil
+		}
+		l.refill(time.Now())
+		// A query costing more than the bucket size is only let through once the bucket is full,
+		// Shopify will reject it with MAX_COST_EXCEEDED anyway.
+		if l.available >= cost || l.available >= l.maximum {
+			l.available -= cost
+			l.pending += cost
+			l.mu.Unlock()
+			return cost, nil
+		}
+		wait := time.Duration((cost - l.available) / l.restoreRate * float64(time.Second))
+		l.mu.Unlock()
+
+		if err := sleep(ctx, wait); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// release returns the points reserved for query and syncs the bucket with the cost reported by Shopify.
+func (l *CostLimiter) release(query string, reserved float64, cost *QueryCost) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending -= reserved
+	if l.pending < 0 {
+		l.pending = 0
+	}
+	if cost == nil {
+		// The request didn't reach Shopify's cost calculation, give the points back
+		l.available = min(l.available+reserved, l.maximum)
+		return
+	}
+
+	l.trackCost(query, cost.RequestedQueryCost)
+
+	if s := cost.ThrottleStatus; s != nil {
+		l.maximum = s.MaximumAvailable
+		l.restoreRate = s.RestoreRate
+		// Points reserved by in-flight requests aren't reflected in Shopify's figure yet
+		l.available = s.CurrentlyAvailable - l.pending
+		l.updatedAt = time.Now()
+	}
+}
+
+// cost returns the last requested cost of query, defaultQueryCost if it isn't known.
+func (l *CostLimiter) cost(query string) float64 {
+	e, ok := l.costs[queryKey(query)]
+	if !ok {
+		return defaultQueryCost
+	}
+	l.costsLRU.MoveToFront(e)
+	return e.Value.(*trackedCost).cost
+}
+
+// trackCost remembers the requested cost of query, evicting the least recently used cost once
+// maxTrackedQueries are tracked.
+func (l *CostLimiter) trackCost(query string, cost float64) {
+	key := queryKey(query)
+	if e, ok := l.costs[key]; ok {
+		e.Value.(*trackedCost).cost = cost
+		l.costsLRU.MoveToFront(e)
+		return
+	}
+	l.costs[key] = l.costsLRU.PushFront(&trackedCost{key: key, cost: cost})
+	for l.costsLRU.Len() > maxTrackedQueries {
+		oldest := l.costsLRU.Back()
+		l.costsLRU.Remove(oldest)
+		delete(l.costs, oldest.Value.(*trackedCost).key)
+	}
+}
+
+func queryKey(query string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(query))
+	return h.Sum64()
+}
+
+func (l *CostLimiter) refill(now time.Time) {
+	if l.updatedAt.IsZero() {
+		return
+	}
+	l.available += now.Sub(l.updatedAt).Seconds() * l.restoreRate
+	if l.available > l.maximum {
+		l.available = l.maximum
+	}
+	l.updatedAt = now
+}
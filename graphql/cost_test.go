@@ -0,0 +1,167 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCostLimiter(t *testing.T) {
+	const query = "{shop{name}}"
+
+	l := NewCostLimiter()
+	ctx := context.Background()
+
+	reserved, err := l.reserve(ctx, query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reserved != defaultQueryCost {
+		t.Errorf("expected (%v), got (%v)", defaultQueryCost, reserved)
+	}
+	l.release(query, reserved, &QueryCost{
+		RequestedQueryCost: 50,
+		ThrottleStatus: &ThrottleStatus{
+			MaximumAvailable:   1000,
+			CurrentlyAvailable: 0,
+			RestoreRate:        1000,
+		},
+	})
+
+	t1 := time.Now()
+	reserved, err = l.reserve(ctx, query)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reserved != 50 {
+		t.Errorf("expected (%v), got (%v)", 50, reserved)
+	}
+	if elapsed := time.Since(t1); elapsed < 40*time.Millisecond {
+		t.Errorf("expected to wait for the bucket to refill, waited %v", elapsed)
+	}
+
+	l.release(query, reserved, &QueryCost{
+		RequestedQueryCost: 50,
+		ThrottleStatus: &ThrottleStatus{
+			MaximumAvailable:   1000,
+			CurrentlyAvailable: 0,
+			RestoreRate:        1,
+		},
+	})
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = l.reserve(ctx, query)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected (%v), got (%v)", context.DeadlineExceeded, err)
+	}
+}
+
+func TestClientUpdatesCostLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":{"shop":{"name":"test"}},"extensions":{"cost":{"requestedQueryCost":2,"actualQueryCost":2,"throttleStatus":{"maximumAvailable":2000.0,"currentlyAvailable":1998,"restoreRate":100.0}}}}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, server.Client())
+	l := NewCostLimiter()
+	c.SetCostLimiter(l)
+
+	var v struct {
+		Shop struct {
+			Name string
+		}
+	}
+	err := c.QueryString(context.Background(), "{shop{name}}", nil, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.Shop.Name != "test" {
+		t.Errorf("expected (%v), got (%v)", "test", v.Shop.Name)
+	}
+	status := l.Status()
+	if status.MaximumAvailable != 2000 || status.RestoreRate != 100 || status.CurrentlyAvailable < 1998 {
+		t.Errorf("unexpected throttle status %+v", status)
+	}
+}
+
+func TestResponseHook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":{"products":{"edges":[]}},"extensions":{"cost":{"requestedQueryCost":252,"actualQueryCost":12,"throttleStatus":{"maximumAvailable":2000.0,"currentlyAvailable":1988,"restoreRate":100.0}}}}`))
+	}))
+	defer server.Close()
+
+	var infos []ResponseInfo
+	c := NewClient(server.URL, server.Client())
+	c.SetShop("example.myshopify.com")
+	c.SetResponseHook(func(ctx context.Context, info ResponseInfo) {
+		infos = append(infos, info)
+	})
+
+	var v interface{}
+	err := c.QueryString(context.Background(), "query products { products(first: 250) { edges { node { id } } } }", nil, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 response, got %v", len(infos))
+	}
+	info := infos[0]
+	if info.Shop != "example.myshopify.com" || info.Operation != "products" || info.Attempt != 1 || info.URL != server.URL || info.Err != nil {
+		t.Errorf("unexpected response info %+v", info)
+	}
+	if info.Cost == nil || info.Cost.RequestedQueryCost != 252 || info.Cost.ActualQueryCost == nil || *info.Cost.ActualQueryCost != 12 {
+		t.Fatalf("unexpected query cost %+v", info.Cost)
+	}
+	if info.Cost.ThrottleStatus == nil || info.Cost.ThrottleStatus.CurrentlyAvailable != 1988 {
+		t.Errorf("unexpected throttle status %+v", info.Cost.ThrottleStatus)
+	}
+}
+
+func TestCostLimiterReleaseWithoutCost(t *testing.T) {
+	const query = "{shop{name}}"
+
+	l := NewCostLimiter()
+	l.release(query, defaultQueryCost, &QueryCost{
+		RequestedQueryCost: 10,
+		ThrottleStatus: &ThrottleStatus{
+			MaximumAvailable:   100,
+			CurrentlyAvailable: 100,
+			RestoreRate:        50,
+		},
+	})
+	for i := 0; i < 3; i++ {
+		reserved, err := l.reserve(context.Background(), query)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// A transport error frees the reserved points without going above the bucket size
+		l.release(query, reserved+50, nil)
+	}
+	if s := l.Status(); s.CurrentlyAvailable != 100 {
+		t.Errorf("expected (%v), got (%v)", 100, s.CurrentlyAvailable)
+	}
+}
+
+func TestCostLimiterEvictsLeastRecentlyUsedCosts(t *testing.T) {
+	l := NewCostLimiter()
+	for i := 0; i <= maxTrackedQueries; i++ {
+		l.trackCost(fmt.Sprintf("{q%d}", i), 10)
+		// Keep the first query in use
+		l.cost("{q0}")
+	}
+	if len(l.costs) != maxTrackedQueries {
+		t.Errorf("expected (%v), got (%v)", maxTrackedQueries, len(l.costs))
+	}
+	if cost := l.cost("{q0}"); cost != 10 {
+		t.Errorf("expected (%v), got (%v)", 10, cost)
+	}
+	if cost := l.cost("{q1}"); cost != defaultQueryCost {
+		t.Errorf("expected (%v), got (%v)", defaultQueryCost, cost)
+	}
+}
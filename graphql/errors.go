@@ -2,6 +2,8 @@ package graphql
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 )
 
 var (
@@ -11,11 +13,157 @@ var (
 	ErrPaymentRequired = errors.New("payment required")
 	// ErrMaxCostExceeded means API rate limit has been reached. The API supports a maximum of 1000 cost points per app per store per minute.
 	// This quota replenishes at a rate of 50 cost points per second.
-	ErrMaxCostExceeded    = errors.New("max cost exceeded")
-	ErrUnauthorized       = errors.New("unauthorized")
-	ErrForbidden          = errors.New("forbidden")
+	ErrMaxCostExceeded = errors.New("max cost exceeded")
+	// ErrThrottled means the query cost is higher than the points currently available in the bucket.
+	ErrThrottled       = errors.New("throttled")
+	ErrTooManyRequests = errors.New("too many requests")
+	ErrUnauthorized    = errors.New("unauthorized")
+	// ErrForbidden means the whole request was rejected with 403 Forbidden.
+	ErrForbidden = errors.New("forbidden")
+	// ErrAccessDenied means a GraphQL error with the ACCESS_DENIED code, a field or mutation the app lacks the access scopes for.
+	ErrAccessDenied       = errors.New("access denied")
 	ErrNotFound           = errors.New("not found")
 	ErrInternal           = errors.New("internal error")
 	ErrServiceUnavailable = errors.New("service unavailable")
 	ErrGatewayTimeout     = errors.New("gateway timeout")
 )
+
+// Error codes returned by Shopify in the "extensions.code" field of a GraphQL error.
+const (
+	ErrorCodeThrottled           = "THROTTLED"
+	ErrorCodeMaxCostExceeded     = MaxCostExceeded
+	ErrorCodeAccessDenied        = "ACCESS_DENIED"
+	ErrorCodeShopInactive        = "SHOP_INACTIVE"
+	ErrorCodeInternalServerError = "INTERNAL_SERVER_ERROR"
+)
+
+// codeErrors maps error codes to the sentinel errors they match with errors.Is.
+var codeErrors = map[string]error{
+	ErrorCodeThrottled:           ErrThrottled,
+	ErrorCodeMaxCostExceeded:     ErrMaxCostExceeded,
+	ErrorCodeAccessDenied:        ErrAccessDenied,
+	ErrorCodeInternalServerError: ErrInternal,
+}
+
+// Location is a position in the GraphQL document an error refers to.
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLError represents an error of the "errors" array in a response from a GraphQL server.
+//
+// Specification: https://spec.graphql.org/October2021/#sec-Errors.
+type GraphQLError struct {
+	Message   string     `json:"message"`
+	Path      []any      `json:"path,omitempty"`
+	Locations []Location `json:"locations,omitempty"`
+	// Extensions holds every entry of the "extensions" object, such as code, documentation, cost and maxCost
+	Extensions map[string]any `json:"extensions,omitempty"`
+}
+
+// Error implements error interface.
+func (e *GraphQLError) Error() string {
+	return e.Message
+}
+
+// Is reports whether the error code matches one of the sentinel errors of this package, e.g. ErrThrottled.
+func (e *GraphQLError) Is(target error) bool {
+	sentinel, ok := codeErrors[e.Code()]
+	return ok && sentinel == target
+}
+
+// Code returns the "extensions.code" of the error.
+func (e *GraphQLError) Code() string {
+	code, _ := e.Extensions["code"].(string)
+	return code
+}
+
+// Documentation returns the "extensions.documentation" link of the error.
+func (e *GraphQLError) Documentation() string {
+	doc, _ := e.Extensions["documentation"].(string)
+	return doc
+}
+
+// PathString returns the path of the field that caused the error joined by dots, e.g. "products.edges.0.node.metafield".
+func (e *GraphQLError) PathString() string {
+	parts := make([]string, len(e.Path))
+	for i, p := range e.Path {
+		parts[i] = fmt.Sprint(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// GraphQLErrors represents the "errors" array in a response from a GraphQL server.
+// If returned via error interface, the slice is expected to contain at least 1 element.
+// Use errors.As to get either the whole slice or its first *GraphQLError.
+type GraphQLErrors []*GraphQLError
+
+// Error implements error interface.
+func (e GraphQLErrors) Error() string {
+	if len(e) == 0 {
+		return "graphql: no errors"
+	}
+	return e[0].Message
+}
+
+// Unwrap returns every error of the slice, so that errors.Is and errors.As inspect all of them.
+func (e GraphQLErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i := range e {
+		errs[i] = e[i]
+	}
+	return errs
+}
+
+// HasErrorCode reports whether err contains a GraphQL error with one of the given codes.
+func HasErrorCode(err error, codes ...string) bool {
+	var gqlErrs GraphQLErrors
+	if errors.As(err, &gqlErrs) {
+		for _, e := range gqlErrs {
+			for _, code := range codes {
+				if e.Code() == code {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var gqlErr *GraphQLError
+	if errors.As(err, &gqlErr) {
+		for _, code := range codes {
+			if gqlErr.Code() == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PartialDataError is returned in partial data mode when a response holds both data and errors.
+// The data that resolved has been populated into the output value.
+type PartialDataError struct {
+	Errors GraphQLErrors
+}
+
+// Error implements error interface.
+func (e *PartialDataError) Error() string {
+	return fmt.Sprintf("partial data, failed paths [%s]: %s", strings.Join(e.FailedPaths(), ", "), e.Errors.Error())
+}
+
+// Unwrap returns the GraphQL errors of the response.
+func (e *PartialDataError) Unwrap() error {
+	return e.Errors
+}
+
+// FailedPaths returns the paths of the fields that failed to resolve, e.g. "products.edges.0.node.metafield".
+// Errors without a path, such as query validation errors, are left out.
+func (e *PartialDataError) FailedPaths() []string {
+	paths := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		if len(err.Path) > 0 {
+			paths = append(paths, err.PathString())
+		}
+	}
+	return paths
+}
@@ -0,0 +1,104 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGraphQLErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"errors":[{"message":"Throttled","extensions":{"code":"THROTTLED","documentation":"https://shopify.dev/api/usage/rate-limits"}},{"message":"Access denied for metafield field.","locations":[{"line":1,"column":20}],"path":["products","edges",0,"node","metafield"],"extensions":{"code":"ACCESS_DENIED"}}]}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, server.Client())
+	var v interface{}
+	err := c.QueryString(context.Background(), "{products{edges{node{metafield{id}}}}}", nil, &v)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if err.Error() != "after 1 attempts: Throttled" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+
+	var gqlErrs GraphQLErrors
+	if !errors.As(err, &gqlErrs) {
+		t.Fatalf("expected GraphQLErrors, got %T", err)
+	}
+	if len(gqlErrs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", len(gqlErrs))
+	}
+	if gqlErrs[0].Code() != ErrorCodeThrottled || gqlErrs[0].Documentation() != "https://shopify.dev/api/usage/rate-limits" {
+		t.Errorf("unexpected error %+v", gqlErrs[0])
+	}
+	denied := gqlErrs[1]
+	if denied.Code() != ErrorCodeAccessDenied || denied.PathString() != "products.edges.0.node.metafield" {
+		t.Errorf("unexpected error %+v", denied)
+	}
+	if !reflect.DeepEqual(denied.Locations, []Location{{Line: 1, Column: 20}}) {
+		t.Errorf("unexpected locations %+v", denied.Locations)
+	}
+
+	var gqlErr *GraphQLError
+	if !errors.As(err, &gqlErr) || gqlErr.Code() != ErrorCodeThrottled {
+		t.Errorf("expected the first GraphQLError, got %+v", gqlErr)
+	}
+	if !errors.Is(err, ErrThrottled) || !errors.Is(err, ErrAccessDenied) || errors.Is(err, ErrForbidden) || errors.Is(err, ErrMaxCostExceeded) {
+		t.Error("unexpected sentinel error matching")
+	}
+	if !HasErrorCode(err, ErrorCodeAccessDenied) || HasErrorCode(err, ErrorCodeShopInactive) {
+		t.Error("unexpected error code matching")
+	}
+}
+
+func TestPartialData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":{"product":{"id":"gid://shopify/Product/1","title":"Shirt","metafield":null}},"errors":[{"message":"Access denied for metafield field.","path":["product","metafield"],"extensions":{"code":"ACCESS_DENIED"}}]}`))
+	}))
+	defer server.Close()
+
+	type product struct {
+		Product struct {
+			ID        string
+			Title     string
+			Metafield *struct {
+				Value string
+			}
+		}
+	}
+	q := `{product(id:"gid://shopify/Product/1"){id,title,metafield(key:"a"){value}}}`
+	c := NewClient(server.URL, server.Client())
+
+	var v product
+	err := c.QueryStringWithErrors(context.Background(), q, nil, &v)
+	var partialErr *PartialDataError
+	if !errors.As(err, &partialErr) {
+		t.Fatalf("expected PartialDataError, got %v", err)
+	}
+	if !reflect.DeepEqual(partialErr.FailedPaths(), []string{"product.metafield"}) {
+		t.Errorf("unexpected failed paths %v", partialErr.FailedPaths())
+	}
+	if v.Product.ID != "gid://shopify/Product/1" || v.Product.Title != "Shirt" || v.Product.Metafield != nil {
+		t.Errorf("unexpected data %+v", v)
+	}
+	if !HasErrorCode(err, ErrorCodeAccessDenied) {
+		t.Error("expected the GraphQL errors to be unwrapped")
+	}
+
+	err = c.QueryString(context.Background(), q, nil, &v)
+	if errors.As(err, &partialErr) {
+		t.Error("expected partial data mode to be disabled by default")
+	}
+
+	c.SetPartialData(true)
+	err = c.QueryString(context.Background(), q, nil, &v)
+	if !errors.As(err, &partialErr) {
+		t.Errorf("expected PartialDataError, got %v", err)
+	}
+}
@@ -6,17 +6,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"time"
 
 	"github.com/gempages/go-helper/errors"
 	gpstrings "github.com/gempages/go-helper/strings"
-	"github.com/gempages/go-helper/tracing"
-	"github.com/getsentry/sentry-go"
 	"golang.org/x/net/context/ctxhttp"
 
-	pkghttp "github.com/gempages/go-shopify-graphql/http"
+	"github.com/gempages/go-shopify-graphql/tracing"
 	"github.com/gempages/go-shopify-graphql/utils"
 )
 
@@ -24,11 +22,37 @@ const MaxCostExceeded = "MAX_COST_EXCEEDED"
 
 // Client is a GraphQL client.
 type Client struct {
-	url        string // GraphQL server URL.
-	httpClient *http.Client
-	retries    int
+	url         string // GraphQL server URL.
+	httpClient  *http.Client
+	retryPolicy RetryPolicy
+	limiter     *CostLimiter
+	onResponse  ResponseHook
+	partialData bool
+	tracer      tracing.Tracer
+	logger      *slog.Logger
+	metrics     Metrics
+	shop        string
+	// externalHTTPClient sends requests outside the GraphQL endpoint
+	externalHTTPClient *http.Client
 }
 
+// ResponseInfo describes the outcome of a single GraphQL request attempt.
+type ResponseInfo struct {
+	// Shop is the shop domain of the client, see SetShop
+	Shop string
+	URL  string
+	// Operation is the comma separated list of the root fields of the query
+	Operation string
+	Attempt   int
+	Duration  time.Duration
+	// Cost is nil when the response doesn't include the "extensions.cost" object
+	Cost *QueryCost
+	Err  error
+}
+
+// ResponseHook is called after every request attempt.
+type ResponseHook func(ctx context.Context, info ResponseInfo)
+
 // NewClient creates a GraphQL client targeting the specified GraphQL server URL.
 // If httpClient is nil, then http.DefaultClient is used.
 func NewClient(url string, httpClient *http.Client) *Client {
@@ -38,13 +62,107 @@ func NewClient(url string, httpClient *http.Client) *Client {
 	return &Client{
 		url:        url,
 		httpClient: httpClient,
+		tracer:     tracing.Sentry(),
+		logger:     slog.Default(),
+		metrics:    noopMetrics{},
 	}
 }
 
-// SetRetries set a context for graphql client
-// set input ctx for graphql client
+// SetRetries sets the maximum number of attempts of a request, keeping the rest of the retry policy
 func (c *Client) SetRetries(retries int) {
-	c.retries = retries
+	c.retryPolicy.MaxAttempts = retries
+}
+
+// SetRetryPolicy sets the policy used to retry failed requests
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retryPolicy = policy
+}
+
+// SetCostLimiter sets the limiter used to wait for enough query cost points before each request.
+// Clients of the same shop should share one limiter. A nil limiter disables cost-aware throttling.
+func (c *Client) SetCostLimiter(limiter *CostLimiter) {
+	c.limiter = limiter
+}
+
+// CostLimiter returns the cost limiter of the client, nil if cost-aware throttling is disabled.
+func (c *Client) CostLimiter() *CostLimiter {
+	return c.limiter
+}
+
+// SetResponseHook sets a hook which receives the query cost and throttle status of every request
+func (c *Client) SetResponseHook(hook ResponseHook) {
+	c.onResponse = hook
+}
+
+// SetPartialData enables or disables partial data mode.
+// In partial data mode, a response holding both data and errors populates the output value with the data that resolved
+// and returns a *PartialDataError listing the paths that failed.
+func (c *Client) SetPartialData(enabled bool) {
+	c.partialData = enabled
+}
+
+// SetTracer sets the tracer used to trace every request. A nil tracer disables tracing.
+func (c *Client) SetTracer(tracer tracing.Tracer) {
+	if tracer == nil {
+		tracer = tracing.Noop()
+	}
+	c.tracer = tracer
+}
+
+// Tracer returns the tracer of the client, also used by the services to trace bulk operations and downloads.
+func (c *Client) Tracer() tracing.Tracer {
+	return c.tracer
+}
+
+// SetLogger sets the logger of the client. A nil logger discards the logs.
+func (c *Client) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.New(discardHandler{})
+	}
+	c.logger = logger
+}
+
+// Logger returns the logger of the client, also used by the services.
+func (c *Client) Logger() *slog.Logger {
+	return c.logger
+}
+
+// SetMetrics sets the hook receiving the measurements of the client. A nil hook disables the measurements.
+func (c *Client) SetMetrics(metrics Metrics) {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+	c.metrics = metrics
+}
+
+// Metrics returns the metrics hook of the client, also used by the services.
+func (c *Client) Metrics() Metrics {
+	return c.metrics
+}
+
+// SetShop sets the shop domain the measurements and the response infos are labeled with.
+func (c *Client) SetShop(shop string) {
+	c.shop = shop
+}
+
+// Shop returns the shop domain of the client.
+func (c *Client) Shop() string {
+	return c.shop
+}
+
+// SetExternalHTTPClient sets the HTTP client used for requests outside the GraphQL endpoint,
+// such as bulk operation result downloads and staged uploads. It must not carry the shop credentials.
+func (c *Client) SetExternalHTTPClient(httpClient *http.Client) {
+	c.externalHTTPClient = httpClient
+}
+
+// ExternalHTTPClient returns the HTTP client used for requests outside the GraphQL endpoint.
+// If none was set, then http.DefaultClient is returned.
+func (c *Client) ExternalHTTPClient() *http.Client {
+	if c.externalHTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.externalHTTPClient
 }
 
 // QueryString executes a single GraphQL query request,
@@ -78,8 +196,24 @@ func (c *Client) MutateString(ctx context.Context, m string, variables map[strin
 	return c.do(ctx, m, variables, v)
 }
 
+// QueryStringWithErrors is like QueryString but always runs in partial data mode:
+// the fields that resolved are populated into `v` even when the response holds errors,
+// which are returned as a *PartialDataError.
+func (c *Client) QueryStringWithErrors(ctx context.Context, q string, variables map[string]interface{}, v interface{}) error {
+	return c.doOperation(ctx, q, variables, v, true)
+}
+
+// MutateStringWithErrors is like MutateString but always runs in partial data mode, see QueryStringWithErrors.
+func (c *Client) MutateStringWithErrors(ctx context.Context, m string, variables map[string]interface{}, v interface{}) error {
+	return c.doOperation(ctx, m, variables, v, true)
+}
+
 // do executes a single GraphQL operation.
 func (c *Client) do(ctx context.Context, query string, variables map[string]interface{}, v interface{}) error {
+	return c.doOperation(ctx, query, variables, v, c.partialData)
+}
+
+func (c *Client) doOperation(ctx context.Context, query string, variables map[string]interface{}, v interface{}, partial bool) error {
 	var err error
 	in := struct {
 		Query     string                 `json:"query"`
@@ -89,22 +223,27 @@ func (c *Client) do(ctx context.Context, query string, variables map[string]inte
 		Variables: variables,
 	}
 
-	// sentry tracing
-	span := sentry.StartSpan(ctx, "shopify_graphql.send")
-	span.Description = utils.GetDescriptionFromQuery(query)
-	span.Data = map[string]interface{}{
-		"GraphQL Query":     query,
-		"GraphQL Variables": variables,
-		"URL":               c.url,
-	}
+	operation := utils.GetDescriptionFromQuery(query)
+
+	ctx, span := c.tracer.Start(ctx, tracing.SpanGraphQLSend,
+		tracing.Attr(tracing.AttrOperation, operation),
+		tracing.Attr(tracing.AttrQuery, query),
+		tracing.Attr(tracing.AttrVariables, variables),
+		tracing.Attr(tracing.AttrURL, c.url),
+	)
+	attempts := 0
+	var cost *QueryCost
 	defer func() {
-		tracing.FinishSpan(span, err)
+		span.SetAttributes(tracing.Attr(tracing.AttrRetryCount, attempts-1))
+		if cost != nil {
+			span.SetAttributes(tracing.Attr(tracing.AttrRequestedQueryCost, cost.RequestedQueryCost))
+			if cost.ActualQueryCost != nil {
+				span.SetAttributes(tracing.Attr(tracing.AttrActualQueryCost, *cost.ActualQueryCost))
+			}
+		}
+		span.End(err)
 	}()
-	ctx = span.Context()
-	// end sentry tracing
 
-	retries := c.retries
-	attempts := 0
 	for {
 		attempts++
 		// Create new data buffer for each attempt
@@ -113,115 +252,141 @@ func (c *Client) do(ctx context.Context, query string, variables map[string]inte
 		if err != nil {
 			return err
 		}
-		err = c.doRequest(ctx, &buf, v)
+		cost, err = c.doAttempt(ctx, query, operation, attempts, &buf, v, partial)
 		if err == nil {
 			break
 		}
-		if retries <= 1 {
+		if attempts >= c.retryPolicy.MaxAttempts {
 			return fmt.Errorf("after %v attempts: %w", attempts, err)
 		}
-		if c.shouldRetry(err) {
-			retries--
-			time.Sleep(time.Duration(attempts) * time.Second)
-			continue
+		if !c.retryPolicy.retryable(err) {
+			return err
+		}
+		delay := c.retryPolicy.delay(attempts, err)
+		c.logger.DebugContext(ctx, "Retrying request", LogKeyOperation, operation, "attempt", attempts, "delay", delay, "error", err)
+		c.metrics.ObserveRetry(ctx, RetryMetric{Shop: c.shop, Operation: operation, Attempt: attempts, Err: err})
+		if werr := sleep(ctx, delay); werr != nil {
+			err = fmt.Errorf("after %v attempts: %w: %w", attempts, werr, err)
+			return err
+		}
+		if isThrottledError(err) || errors.Is(err, ErrTooManyRequests) {
+			c.metrics.ObserveThrottleWait(ctx, ThrottleWaitMetric{Shop: c.shop, Operation: operation, Wait: delay})
 		}
-		return err
 	}
 	return nil
 }
 
-func (c *Client) doRequest(ctx context.Context, body io.Reader, v interface{}) error {
+// doAttempt waits for the cost limiter, if any, sends the request and reports the response
+// to the response hook and metrics hook.
+func (c *Client) doAttempt(ctx context.Context, query, operation string, attempt int, body io.Reader, v interface{}, partial bool) (*QueryCost, error) {
+	var reserved float64
+	if c.limiter != nil {
+		var err error
+		waitStart := time.Now()
+		reserved, err = c.limiter.reserve(ctx, query)
+		if wait := time.Since(waitStart); wait >= minThrottleWait {
+			c.metrics.ObserveThrottleWait(ctx, ThrottleWaitMetric{Shop: c.shop, Operation: operation, Wait: wait})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wait for query cost: %w", err)
+		}
+	}
+
+	start := time.Now()
+	statusCode, cost, err := c.doRequest(ctx, body, v, partial)
+	duration := time.Since(start)
+	if c.limiter != nil {
+		c.limiter.release(query, reserved, cost)
+	}
+	if c.onResponse != nil {
+		c.onResponse(ctx, ResponseInfo{
+			Shop:      c.shop,
+			URL:       c.url,
+			Operation: operation,
+			Attempt:   attempt,
+			Duration:  duration,
+			Cost:      cost,
+			Err:       err,
+		})
+	}
+	c.metrics.ObserveRequest(ctx, RequestMetric{
+		Shop:       c.shop,
+		Operation:  operation,
+		StatusCode: statusCode,
+		Duration:   duration,
+		Cost:       cost,
+		Err:        err,
+	})
+	return cost, err
+}
+
+func (c *Client) doRequest(ctx context.Context, body io.Reader, v interface{}, partial bool) (int, *QueryCost, error) {
 	resp, err := ctxhttp.Post(ctx, c.httpClient, c.url, "application/json", body)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode == http.StatusPaymentRequired {
-		return ErrPaymentRequired
+		return resp.StatusCode, nil, ErrPaymentRequired
 	}
 	if resp.StatusCode == http.StatusLocked {
-		return ErrLocked
+		return resp.StatusCode, nil, ErrLocked
 	}
 	if resp.StatusCode == http.StatusUnauthorized {
-		return ErrUnauthorized
+		return resp.StatusCode, nil, ErrUnauthorized
 	}
 	if resp.StatusCode == http.StatusForbidden {
-		return ErrForbidden
+		return resp.StatusCode, nil, ErrForbidden
 	}
 	if resp.StatusCode == http.StatusNotFound {
-		return ErrNotFound
+		return resp.StatusCode, nil, ErrNotFound
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return resp.StatusCode, nil, withRetryAfter(resp, ErrTooManyRequests)
 	}
 	if resp.StatusCode == http.StatusInternalServerError {
-		return ErrInternal
+		return resp.StatusCode, nil, ErrInternal
 	}
 	if resp.StatusCode == http.StatusServiceUnavailable {
-		return ErrServiceUnavailable
+		return resp.StatusCode, nil, withRetryAfter(resp, ErrServiceUnavailable)
 	}
 	if resp.StatusCode == http.StatusGatewayTimeout {
-		return ErrGatewayTimeout
+		return resp.StatusCode, nil, ErrGatewayTimeout
 	}
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return errors.NewErrorWithContext(ctx, fmt.Errorf("non-200 OK status code: %v", resp.Status), map[string]any{
+		return resp.StatusCode, nil, errors.NewErrorWithContext(ctx, fmt.Errorf("non-200 OK status code: %v", resp.Status), map[string]any{
 			"body": gpstrings.CutLength(string(body), 500)})
 	}
 	var out struct {
-		Data   *json.RawMessage
-		Errors graphErrors
+		Data       *json.RawMessage
+		Errors     GraphQLErrors
+		Extensions *extensions
 	}
 	err = json.NewDecoder(resp.Body).Decode(&out)
 	if err != nil {
 		body, _ := io.ReadAll(resp.Body)
-		return errors.NewErrorWithContext(ctx, fmt.Errorf("JSON decode response: %w", err), map[string]any{
+		return resp.StatusCode, nil, errors.NewErrorWithContext(ctx, fmt.Errorf("JSON decode response: %w", err), map[string]any{
 			"body": gpstrings.CutLength(string(body), 500)})
 	}
+	var cost *QueryCost
+	if out.Extensions != nil {
+		cost = out.Extensions.Cost
+	}
 	if out.Data != nil {
 		err := json.Unmarshal(*out.Data, v)
 		if err != nil {
-			return errors.NewErrorWithContext(ctx, fmt.Errorf("unmarshal data: %w", err), map[string]any{
+			return resp.StatusCode, cost, errors.NewErrorWithContext(ctx, fmt.Errorf("unmarshal data: %w", err), map[string]any{
 				"out.Data": gpstrings.CutLength(string(*out.Data), 500)})
 		}
 	}
 	if len(out.Errors) > 0 {
-		for _, e := range out.Errors {
-			if e.Extensions.Code == MaxCostExceeded {
-				return ErrMaxCostExceeded
-			}
+		if partial && out.Data != nil {
+			return resp.StatusCode, cost, &PartialDataError{Errors: out.Errors}
 		}
-		return out.Errors
-	}
-	return nil
-}
-
-func (c *Client) shouldRetry(err error) bool {
-	if uerr, isURLErr := err.(*url.Error); isURLErr {
-		return uerr.Timeout() || uerr.Temporary()
+		return resp.StatusCode, cost, out.Errors
 	}
-	return isThrottledError(err) || pkghttp.IsConnectionError(err) || errors.Is(err, ErrMaxCostExceeded) ||
-		errors.Is(err, ErrGatewayTimeout) || errors.Is(err, ErrServiceUnavailable)
-}
-
-// errors represents the "errors" array in a response from a GraphQL server.
-// If returned via error interface, the slice is expected to contain at least 1 element.
-//
-// Specification: https://facebook.github.io/graphql/#sec-Errors.
-type graphErrors []struct {
-	Message    string
-	Extensions struct {
-		Code          string
-		Cost          int
-		MaxCost       int `json:"maxCost"`
-		Documentation string
-	}
-	Locations []struct {
-		Line   int
-		Column int
-	}
-}
-
-// Error implements error interface.
-func (e graphErrors) Error() string {
-	return e[0].Message
+	return resp.StatusCode, cost, nil
 }
 
 type operationType uint8
@@ -233,5 +398,5 @@ const (
 )
 
 func isThrottledError(err error) bool {
-	return err != nil && err.Error() == "Throttled"
+	return errors.Is(err, ErrThrottled)
 }
@@ -0,0 +1,25 @@
+package graphql
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Log attribute keys
+const (
+	// LogKeyShop is the key of the shop domain
+	LogKeyShop = "shop"
+	// LogKeyOperation is the key of the GraphQL operation, the root fields of the query
+	LogKeyOperation = "operation"
+)
+
+// discardHandler drops every record, slog.DiscardHandler isn't available before Go 1.24
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool { return false }
+
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler { return d }
+
+func (d discardHandler) WithGroup(string) slog.Handler { return d }
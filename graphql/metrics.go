@@ -0,0 +1,73 @@
+package graphql
+
+import (
+	"context"
+	"time"
+)
+
+// minThrottleWait is the shortest wait for query cost points reported as a throttle wait
+const minThrottleWait = time.Millisecond
+
+// Metrics receives the measurements of the client and of the bulk operation service, e.g. to export them to Prometheus,
+// see the prommetrics package. Measurements are labeled with the shop domain and the operation name,
+// the comma separated list of the root fields of the query.
+type Metrics interface {
+	// ObserveRequest is called after every request attempt
+	ObserveRequest(ctx context.Context, m RequestMetric)
+	// ObserveRetry is called before a failed request is retried
+	ObserveRetry(ctx context.Context, m RetryMetric)
+	// ObserveThrottleWait is called after waiting for query cost points,
+	// or for a request rejected because of the rate limit to be retried
+	ObserveThrottleWait(ctx context.Context, m ThrottleWaitMetric)
+	// ObserveBulkOperation is called once a bulk operation is finished
+	ObserveBulkOperation(ctx context.Context, m BulkOperationMetric)
+}
+
+// RequestMetric describes a request attempt.
+type RequestMetric struct {
+	Shop      string
+	Operation string
+	// StatusCode is 0 when no response was received
+	StatusCode int
+	Duration   time.Duration
+	// Cost is nil when the response doesn't include the "extensions.cost" object
+	Cost *QueryCost
+	Err  error
+}
+
+// RetryMetric describes the retry of a failed request.
+type RetryMetric struct {
+	Shop      string
+	Operation string
+	// Attempt is the number of the attempt that failed
+	Attempt int
+	Err     error
+}
+
+// ThrottleWaitMetric describes a wait caused by the rate limit.
+type ThrottleWaitMetric struct {
+	Shop      string
+	Operation string
+	Wait      time.Duration
+}
+
+// BulkOperationMetric describes a finished bulk operation.
+type BulkOperationMetric struct {
+	Shop      string
+	Operation string
+	// Status is the final status of the bulk operation, such as COMPLETED or FAILED
+	Status string
+	// Duration is the time between the creation and the completion of the bulk operation
+	Duration    time.Duration
+	ObjectCount int64
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveRequest(context.Context, RequestMetric) {}
+
+func (noopMetrics) ObserveRetry(context.Context, RetryMetric) {}
+
+func (noopMetrics) ObserveThrottleWait(context.Context, ThrottleWaitMetric) {}
+
+func (noopMetrics) ObserveBulkOperation(context.Context, BulkOperationMetric) {}
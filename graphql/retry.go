@@ -0,0 +1,143 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	pkghttp "github.com/gempages/go-shopify-graphql/http"
+)
+
+const (
+	defaultRetryBaseDelay = time.Second
+	defaultRetryMaxDelay  = 30 * time.Second
+	defaultRetryJitter    = 0.2
+)
+
+// RetryPolicy controls how a failed request is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one. Values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, it doubles on every following retry. Defaults to 1s.
+	BaseDelay time.Duration
+	// MaxDelay caps the computed delay. Defaults to 30s.
+	MaxDelay time.Duration
+	// Jitter is the fraction of each delay, between 0 and 1, that is randomized. Defaults to 0.2.
+	// Use a negative value to disable jitter.
+	Jitter float64
+	// Retryable reports whether a request failing with err should be retried. Defaults to IsRetryableError.
+	Retryable func(err error) bool
+}
+
+// retryable reports whether err should be retried according to the policy.
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsRetryableError(err)
+}
+
+// delay returns how long to wait before the next attempt, after the given number of failed attempts.
+// A Retry-After delay sent by the server takes precedence when it's longer than the computed one.
+func (p RetryPolicy) delay(attempts int, err error) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	jitter := p.Jitter
+	if jitter == 0 {
+		jitter = defaultRetryJitter
+	}
+
+	d := base
+	for i := 1; i < attempts && d < maxDelay; i++ {
+		d *= 2
+	}
+	if d > maxDelay {
+		d = maxDelay
+	}
+	if jitter > 0 {
+		if jitter > 1 {
+			jitter = 1
+		}
+		d -= time.Duration(rand.Float64() * jitter * float64(d))
+	}
+
+	if after, ok := RetryAfter(err); ok && after > d {
+		d = after
+	}
+	return d
+}
+
+// IsRetryableError reports whether err is a transient error worth retrying:
+// throttling, network timeouts, connection errors and 429, 503 and 504 responses.
+func IsRetryableError(err error) bool {
+	var uerr *url.Error
+	if errors.As(err, &uerr) && (uerr.Timeout() || uerr.Temporary()) {
+		return true
+	}
+	return isThrottledError(err) || pkghttp.IsConnectionError(err) || errors.Is(err, ErrMaxCostExceeded) ||
+		errors.Is(err, ErrTooManyRequests) || errors.Is(err, ErrGatewayTimeout) || errors.Is(err, ErrServiceUnavailable)
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of the response that caused err, if any.
+func RetryAfter(err error) (time.Duration, bool) {
+	var rerr *retryAfterError
+	if errors.As(err, &rerr) {
+		return rerr.after, true
+	}
+	return 0, false
+}
+
+// retryAfterError wraps an error caused by a response carrying a Retry-After header.
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// withRetryAfter wraps err with the delay of the Retry-After header of resp, if the header is valid.
+func withRetryAfter(resp *http.Response, err error) error {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return err
+	}
+	if seconds, perr := strconv.Atoi(value); perr == nil && seconds >= 0 {
+		return &retryAfterError{err: err, after: time.Duration(seconds) * time.Second}
+	}
+	if date, perr := http.ParseTime(value); perr == nil {
+		after := time.Until(date)
+		if after < 0 {
+			after = 0
+		}
+		return &retryAfterError{err: err, after: after}
+	}
+	return err
+}
+
+// sleep waits for d, or returns early with the context error when ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,129 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  time.Second,
+		Jitter:    -1,
+	}
+	testTable := []struct {
+		attempts int
+		err      error
+		want     time.Duration
+	}{
+		{attempts: 1, err: ErrServiceUnavailable, want: 100 * time.Millisecond},
+		{attempts: 2, err: ErrServiceUnavailable, want: 200 * time.Millisecond},
+		{attempts: 3, err: ErrServiceUnavailable, want: 400 * time.Millisecond},
+		{attempts: 10, err: ErrServiceUnavailable, want: time.Second},
+		{attempts: 1, err: &retryAfterError{err: ErrTooManyRequests, after: 2 * time.Second}, want: 2 * time.Second},
+		{attempts: 3, err: &retryAfterError{err: ErrTooManyRequests, after: 0}, want: 400 * time.Millisecond},
+	}
+	for _, tc := range testTable {
+		if got := p.delay(tc.attempts, tc.err); got != tc.want {
+			t.Errorf("attempts %v: expected (%v), got (%v)", tc.attempts, tc.want, got)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.delay(1, ErrServiceUnavailable); got < 50*time.Millisecond || got > 100*time.Millisecond {
+			t.Fatalf("jittered delay out of range: %v", got)
+		}
+	}
+}
+
+func TestRetryHonorsRetryAfter(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":{"shop":{"name":"test"}}}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, server.Client())
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	var v interface{}
+	t1 := time.Now()
+	err := c.QueryString(context.Background(), "{shop{name}}", nil, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected (%v) calls, got (%v)", 2, calls)
+	}
+	if elapsed := time.Since(t1); elapsed < time.Second {
+		t.Errorf("expected to wait for Retry-After, waited %v", elapsed)
+	}
+}
+
+func TestRetryStopsWhenContextIsDone(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, server.Client())
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: 10 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var v interface{}
+	t1 := time.Now()
+	err := c.QueryString(ctx, "{shop{name}}", nil, &v)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrServiceUnavailable) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(t1); elapsed > time.Second {
+		t.Errorf("retry didn't stop when the context was done, waited %v", elapsed)
+	}
+}
+
+func TestRetryableErrors(t *testing.T) {
+	if !IsRetryableError(ErrTooManyRequests) || !IsRetryableError(ErrMaxCostExceeded) || !IsRetryableError(ErrGatewayTimeout) {
+		t.Error("expected transient errors to be retryable")
+	}
+	if IsRetryableError(ErrUnauthorized) || IsRetryableError(ErrPaymentRequired) {
+		t.Error("expected permanent errors not to be retryable")
+	}
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, server.Client())
+	c.SetRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		Retryable: func(err error) bool {
+			return false
+		},
+	})
+	var v interface{}
+	err := c.QueryString(context.Background(), "{shop{name}}", nil, &v)
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected (%v) calls, got (%v)", 1, calls)
+	}
+}
@@ -0,0 +1,94 @@
+package graphql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql/tracing"
+)
+
+type recordingTracer struct {
+	spans []*recordingSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	span := &recordingSpan{name: name, attrs: map[string]any{}}
+	span.SetAttributes(attrs...)
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+type recordingSpan struct {
+	name  string
+	attrs map[string]any
+	ended bool
+	err   error
+}
+
+func (s *recordingSpan) SetAttributes(attrs ...tracing.Attribute) {
+	for _, attr := range attrs {
+		s.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *recordingSpan) End(err error) {
+	s.ended = true
+	s.err = err
+}
+
+func TestTracer(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":{"shop":{"name":"test"}},"extensions":{"cost":{"requestedQueryCost":2,"actualQueryCost":1,"throttleStatus":{"maximumAvailable":2000.0,"currentlyAvailable":1999,"restoreRate":100.0}}}}`))
+	}))
+	defer server.Close()
+
+	tracer := &recordingTracer{}
+	c := NewClient(server.URL, server.Client())
+	c.SetTracer(tracer)
+	c.SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+
+	var v interface{}
+	err := c.QueryString(context.Background(), "{shop{name}}", map[string]interface{}{"first": 1}, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tracer.spans) != 1 {
+		t.Fatalf("expected (%v) span, got (%v)", 1, len(tracer.spans))
+	}
+	span := tracer.spans[0]
+	if span.name != tracing.SpanGraphQLSend || !span.ended || span.err != nil {
+		t.Errorf("unexpected span %+v", span)
+	}
+	expected := map[string]any{
+		tracing.AttrOperation:          "shop",
+		tracing.AttrQuery:              "{shop{name}}",
+		tracing.AttrURL:                server.URL,
+		tracing.AttrRetryCount:         1,
+		tracing.AttrRequestedQueryCost: 2.0,
+		tracing.AttrActualQueryCost:    1.0,
+	}
+	for key, value := range expected {
+		if span.attrs[key] != value {
+			t.Errorf("expected (%v) to be (%v), got (%v)", key, value, span.attrs[key])
+		}
+	}
+
+	c.SetTracer(nil)
+	err = c.QueryString(context.Background(), "{shop{name}}", nil, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tracer.spans) != 1 {
+		t.Errorf("expected no span once tracing is disabled, got (%v)", len(tracer.spans))
+	}
+}
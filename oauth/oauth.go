@@ -0,0 +1,316 @@
+// Package oauth implements the OAuth flows of public Shopify apps: the authorization code grant, run when a merchant
+// installs the app, and the token exchange, which swaps an App Bridge session token for an access token.
+//
+//	config := &oauth.Config{ClientID: apiKey, ClientSecret: apiSecret, Scopes: []string{"read_products"}, RedirectURL: callbackURL}
+//
+//	// Install
+//	state, err := oauth.NewState()
+//	url, err := config.AuthorizeURL(shop, state, false)
+//
+//	// Callback
+//	err = config.VerifyCallback(r.URL.Query(), state)
+//	token, err := config.Exchange(ctx, r.URL.Query().Get("shop"), r.URL.Query().Get("code"))
+//
+//	// App opened by the merchant
+//	err = config.VerifyRequest(r.URL.Query())
+package oauth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidShop  = errors.New("oauth: invalid shop domain")
+	ErrInvalidHMAC  = errors.New("oauth: invalid HMAC")
+	ErrInvalidState = errors.New("oauth: invalid state")
+	// ErrExpiredRequest is returned for a request whose timestamp is missing or too far from now, which may be replayed
+	ErrExpiredRequest = errors.New("oauth: expired request")
+)
+
+// DefaultRequestMaxAge is how far from now the timestamp of the requests checked by VerifyRequest can be,
+// unless another age is set in the Config
+const DefaultRequestMaxAge = 5 * time.Minute
+
+// shopRegex matches the myshopify domains of the shops
+var shopRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9\-]*\.myshopify\.com$`)
+
+// Token types requested by a token exchange
+const (
+	OfflineAccessToken TokenType = "urn:shopify:params:oauth:token-type:offline-access-token"
+	OnlineAccessToken  TokenType = "urn:shopify:params:oauth:token-type:online-access-token"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	idTokenType            = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+// TokenType is the type of access token requested by a token exchange.
+type TokenType string
+
+// Config holds the credentials and settings of an app.
+type Config struct {
+	// ClientID is the API key of the app
+	ClientID string
+	// ClientSecret is the API secret key of the app, also used to sign the callback requests
+	ClientSecret string
+	Scopes       []string
+	// RedirectURL is the callback URL of the app, which must be allowed in the app settings
+	RedirectURL string
+	// HTTPClient sends the token requests. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// BaseURL optionally replaces "https://<shop>" in the token requests, e.g. to point at a local stand-in of Shopify
+	BaseURL string
+	// RequestMaxAge is how far from now the timestamp of the requests checked by VerifyRequest can be.
+	// Defaults to DefaultRequestMaxAge.
+	RequestMaxAge time.Duration
+}
+
+// Token is an access token granted to the app.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	// Scope is the comma separated list of the access scopes granted to the app
+	Scope string `json:"scope"`
+	// ExpiresIn is the number of seconds until an online access token expires, 0 for an offline one
+	ExpiresIn int `json:"expires_in,omitempty"`
+	// AssociatedUserScope is the comma separated list of the access scopes granted to the user of an online access token
+	AssociatedUserScope string `json:"associated_user_scope,omitempty"`
+	// AssociatedUser is the user of an online access token
+	AssociatedUser *AssociatedUser `json:"associated_user,omitempty"`
+}
+
+// Online reports whether the token is an online access token, bound to a user.
+func (t *Token) Online() bool {
+	return t.AssociatedUser != nil
+}
+
+// AssociatedUser is the staff member an online access token was granted for.
+type AssociatedUser struct {
+	ID            int64  `json:"id"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	AccountOwner  bool   `json:"account_owner"`
+	Locale        string `json:"locale"`
+	Collaborator  bool   `json:"collaborator"`
+}
+
+// Error is the error of a rejected token request.
+type Error struct {
+	StatusCode int
+	// Code is the OAuth error code, such as "invalid_request" or "invalid_subject_token", if any
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("oauth: token request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("oauth: token request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Description)
+}
+
+// ValidShop reports whether shop is a myshopify domain, such as "example.myshopify.com".
+func ValidShop(shop string) bool {
+	return shopRegex.MatchString(shop)
+}
+
+// NewState returns a random nonce to pass as the state of the authorization request and check in the callback.
+func NewState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate state: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AuthorizeURL returns the URL to redirect the merchant to for installing the app on shop.
+// state must be stored, e.g. in a cookie, and passed to VerifyCallback.
+// online requests an online access token, bound to the merchant's user, instead of an offline one.
+func (c *Config) AuthorizeURL(shop, state string, online bool) (string, error) {
+	if !ValidShop(shop) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidShop, shop)
+	}
+	params := url.Values{}
+	params.Set("client_id", c.ClientID)
+	params.Set("scope", strings.Join(c.Scopes, ","))
+	params.Set("redirect_uri", c.RedirectURL)
+	params.Set("state", state)
+	if online {
+		params.Set("grant_options[]", "per-user")
+	}
+	return fmt.Sprintf("https://%s/admin/oauth/authorize?%s", shop, params.Encode()), nil
+}
+
+// VerifyCallback checks the query parameters of the callback request: the HMAC signature, the shop domain
+// and the state nonce, which must match state, the one passed to AuthorizeURL.
+// It returns ErrInvalidState if state is empty, use VerifyRequest for the requests without a state.
+func (c *Config) VerifyCallback(query url.Values, state string) error {
+	if err := c.verify(query); err != nil {
+		return err
+	}
+	if state == "" || !hmac.Equal([]byte(query.Get("state")), []byte(state)) {
+		return ErrInvalidState
+	}
+	return nil
+}
+
+// VerifyRequest checks the query parameters of the requests Shopify sends when the merchant opens the app:
+// the HMAC signature, the shop domain and the timestamp, which must be within RequestMaxAge of now
+// for the request not to be replayed.
+func (c *Config) VerifyRequest(query url.Values) error {
+	if err := c.verify(query); err != nil {
+		return err
+	}
+	timestamp, err := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp %q", ErrExpiredRequest, query.Get("timestamp"))
+	}
+	maxAge := c.RequestMaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultRequestMaxAge
+	}
+	age := time.Since(time.Unix(timestamp, 0))
+	if age > maxAge || age < -maxAge {
+		return fmt.Errorf("%w: timestamp %d", ErrExpiredRequest, timestamp)
+	}
+	return nil
+}
+
+// verify checks the HMAC signature and the shop domain of the query parameters of a request.
+func (c *Config) verify(query url.Values) error {
+	if !c.ValidHMAC(query) {
+		return ErrInvalidHMAC
+	}
+	if !ValidShop(query.Get("shop")) {
+		return fmt.Errorf("%w: %q", ErrInvalidShop, query.Get("shop"))
+	}
+	return nil
+}
+
+// ValidHMAC reports whether the "hmac" parameter of query is the signature of the other parameters.
+func (c *Config) ValidHMAC(query url.Values) bool {
+	signature, err := hex.DecodeString(query.Get("hmac"))
+	if err != nil || len(signature) == 0 {
+		return false
+	}
+	return hmac.Equal(signature, c.sign(query))
+}
+
+// sign returns the signature of the query parameters but "hmac" and "signature", sorted by key.
+func (c *Config) sign(query url.Values) []byte {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		if key == "hmac" || key == "signature" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		value := values[0]
+		if len(values) > 1 {
+			// Such as ids[]=1&ids[]=2, signed as ids=["1", "2"]
+			quoted := make([]string, len(values))
+			for i, v := range values {
+				quoted[i] = fmt.Sprintf("%q", v)
+			}
+			value = "[" + strings.Join(quoted, ", ") + "]"
+		}
+		pairs = append(pairs, strings.TrimSuffix(key, "[]")+"="+value)
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.ClientSecret))
+	mac.Write([]byte(strings.Join(pairs, "&")))
+	return mac.Sum(nil)
+}
+
+// Exchange exchanges the authorization code of the callback for an access token of shop.
+func (c *Config) Exchange(ctx context.Context, shop, code string) (*Token, error) {
+	return c.requestToken(ctx, shop, map[string]string{
+		"client_id":     c.ClientID,
+		"client_secret": c.ClientSecret,
+		"code":          code,
+	})
+}
+
+// ExchangeSessionToken exchanges an App Bridge session token, the bearer token of the requests of an embedded app,
+// for an access token of shop of the given type.
+func (c *Config) ExchangeSessionToken(ctx context.Context, shop, sessionToken string, tokenType TokenType) (*Token, error) {
+	return c.requestToken(ctx, shop, map[string]string{
+		"client_id":            c.ClientID,
+		"client_secret":        c.ClientSecret,
+		"grant_type":           tokenExchangeGrantType,
+		"subject_token":        sessionToken,
+		"subject_token_type":   idTokenType,
+		"requested_token_type": string(tokenType),
+	})
+}
+
+func (c *Config) requestToken(ctx context.Context, shop string, params map[string]string) (*Token, error) {
+	if !ValidShop(shop) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidShop, shop)
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("encode token request: %w", err)
+	}
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = "https://" + shop
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/admin/oauth/access_token", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send token request: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		oauthErr := &Error{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(data, oauthErr)
+		return nil, oauthErr
+	}
+
+	token := &Token{}
+	if err = json.Unmarshal(data, token); err != nil {
+		return nil, fmt.Errorf("decode token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, fmt.Errorf("decode token response: empty access token")
+	}
+	return token, nil
+}
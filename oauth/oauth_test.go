@@ -0,0 +1,189 @@
+package oauth
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuthorizeURL(t *testing.T) {
+	c := &Config{ClientID: "key", Scopes: []string{"read_products", "write_orders"}, RedirectURL: "https://app.example.com/callback"}
+
+	authorizeURL, err := c.AuthorizeURL("example.myshopify.com", "nonce", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, _ := url.Parse(authorizeURL)
+	if u.Host != "example.myshopify.com" || u.Path != "/admin/oauth/authorize" {
+		t.Errorf("expected (%v), got (%v)", "https://example.myshopify.com/admin/oauth/authorize", authorizeURL)
+	}
+	expected := url.Values{
+		"client_id":       {"key"},
+		"scope":           {"read_products,write_orders"},
+		"redirect_uri":    {"https://app.example.com/callback"},
+		"state":           {"nonce"},
+		"grant_options[]": {"per-user"},
+	}
+	if u.Query().Encode() != expected.Encode() {
+		t.Errorf("expected (%v), got (%v)", expected.Encode(), u.Query().Encode())
+	}
+
+	_, err = c.AuthorizeURL("evil.com/.myshopify.com", "nonce", false)
+	if !errors.Is(err, ErrInvalidShop) {
+		t.Errorf("expected (%v), got (%v)", ErrInvalidShop, err)
+	}
+}
+
+func TestVerifyCallback(t *testing.T) {
+	c := &Config{ClientSecret: "hush"}
+	// Example of the Shopify documentation
+	query := url.Values{
+		"code":      {"0907a61c0c8d55e99db179b68161bc00"},
+		"shop":      {"some-shop.myshopify.com"},
+		"state":     {"0.6784241404160823"},
+		"timestamp": {"1337178173"},
+		"hmac":      {"700e2dadb827fcc8609e9d5ce208b2e9cdaab9df07390d2cbca10d7c328fc4bf"},
+	}
+
+	if err := c.VerifyCallback(query, "0.6784241404160823"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := c.VerifyCallback(query, "other"); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected (%v), got (%v)", ErrInvalidState, err)
+	}
+	// The state is always checked
+	if err := c.VerifyCallback(query, ""); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected (%v), got (%v)", ErrInvalidState, err)
+	}
+	withoutState := url.Values{"code": {"0907a61c0c8d55e99db179b68161bc00"}, "shop": {"some-shop.myshopify.com"}, "timestamp": {"1337178173"}}
+	withoutState.Set("hmac", hex.EncodeToString(c.sign(withoutState)))
+	if err := c.VerifyCallback(withoutState, ""); !errors.Is(err, ErrInvalidState) {
+		t.Errorf("expected (%v), got (%v)", ErrInvalidState, err)
+	}
+
+	tampered := url.Values{}
+	for k, v := range query {
+		tampered[k] = v
+	}
+	tampered.Set("shop", "other-shop.myshopify.com")
+	if err := c.VerifyCallback(tampered, ""); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("expected (%v), got (%v)", ErrInvalidHMAC, err)
+	}
+
+	invalidShop := url.Values{"shop": {"example.com"}, "timestamp": {"1337178173"}}
+	invalidShop.Set("hmac", hex.EncodeToString(c.sign(invalidShop)))
+	if err := c.VerifyCallback(invalidShop, ""); !errors.Is(err, ErrInvalidShop) {
+		t.Errorf("expected (%v), got (%v)", ErrInvalidShop, err)
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	c := &Config{ClientSecret: "hush"}
+	signed := func(timestamp time.Time) url.Values {
+		query := url.Values{"shop": {"some-shop.myshopify.com"}, "host": {"YWRtaW4uc2hvcGlmeS5jb20"}, "timestamp": {strconv.FormatInt(timestamp.Unix(), 10)}}
+		query.Set("hmac", hex.EncodeToString(c.sign(query)))
+		return query
+	}
+
+	if err := c.VerifyRequest(signed(time.Now())); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := c.VerifyRequest(signed(time.Now().Add(-DefaultRequestMaxAge - time.Minute))); !errors.Is(err, ErrExpiredRequest) {
+		t.Errorf("expected (%v), got (%v)", ErrExpiredRequest, err)
+	}
+	if err := c.VerifyRequest(signed(time.Now().Add(DefaultRequestMaxAge + time.Minute))); !errors.Is(err, ErrExpiredRequest) {
+		t.Errorf("expected (%v), got (%v)", ErrExpiredRequest, err)
+	}
+
+	c.RequestMaxAge = time.Hour
+	if err := c.VerifyRequest(signed(time.Now().Add(-30 * time.Minute))); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tampered := signed(time.Now())
+	tampered.Set("timestamp", strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10))
+	if err := c.VerifyRequest(tampered); !errors.Is(err, ErrInvalidHMAC) {
+		t.Errorf("expected (%v), got (%v)", ErrInvalidHMAC, err)
+	}
+
+	withoutTimestamp := url.Values{"shop": {"some-shop.myshopify.com"}}
+	withoutTimestamp.Set("hmac", hex.EncodeToString(c.sign(withoutTimestamp)))
+	if err := c.VerifyRequest(withoutTimestamp); !errors.Is(err, ErrExpiredRequest) {
+		t.Errorf("expected (%v), got (%v)", ErrExpiredRequest, err)
+	}
+}
+
+func TestSignArrayParameters(t *testing.T) {
+	c := &Config{ClientSecret: "hush"}
+	query := url.Values{"ids[]": {"1", "2"}, "shop": {"some-shop.myshopify.com"}}
+	expected := (&Config{ClientSecret: "hush"}).sign(url.Values{"ids": {`["1", "2"]`}, "shop": {"some-shop.myshopify.com"}})
+	if got := c.sign(query); hex.EncodeToString(got) != hex.EncodeToString(expected) {
+		t.Errorf("expected (%x), got (%x)", expected, got)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	var requests []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/admin/oauth/access_token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var params map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&params)
+		requests = append(requests, params)
+		switch {
+		case params["code"] == "valid":
+			w.Write([]byte(`{"access_token":"offline","scope":"read_products"}`))
+		case params["subject_token"] == "valid":
+			w.Write([]byte(`{"access_token":"online","scope":"read_products","expires_in":86399,"associated_user_scope":"read_products","associated_user":{"id":902541635,"email":"john@example.com","account_owner":true}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_subject_token","error_description":"Session token is invalid"}`))
+		}
+	}))
+	defer server.Close()
+
+	c := &Config{ClientID: "key", ClientSecret: "hush", BaseURL: server.URL}
+	ctx := context.Background()
+
+	token, err := c.Exchange(ctx, "example.myshopify.com", "valid")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.AccessToken != "offline" || token.Online() {
+		t.Errorf("expected offline token, got (%+v)", token)
+	}
+	if requests[0]["client_id"] != "key" || requests[0]["client_secret"] != "hush" {
+		t.Errorf("expected app credentials, got (%v)", requests[0])
+	}
+
+	token, err = c.ExchangeSessionToken(ctx, "example.myshopify.com", "valid", OnlineAccessToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.AccessToken != "online" || !token.Online() || token.AssociatedUser.ID != 902541635 || token.ExpiresIn != 86399 {
+		t.Errorf("expected online token, got (%+v)", token)
+	}
+	if requests[1]["grant_type"] != tokenExchangeGrantType || requests[1]["requested_token_type"] != string(OnlineAccessToken) {
+		t.Errorf("expected token exchange request, got (%v)", requests[1])
+	}
+
+	_, err = c.ExchangeSessionToken(ctx, "example.myshopify.com", "expired", OfflineAccessToken)
+	var oauthErr *Error
+	if !errors.As(err, &oauthErr) || oauthErr.StatusCode != http.StatusBadRequest || oauthErr.Code != "invalid_subject_token" {
+		t.Errorf("expected invalid_subject_token error, got (%v)", err)
+	}
+
+	_, err = c.Exchange(ctx, "example.com", "valid")
+	if !errors.Is(err, ErrInvalidShop) {
+		t.Errorf("expected (%v), got (%v)", ErrInvalidShop, err)
+	}
+}
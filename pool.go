@@ -0,0 +1,208 @@
+package shopify
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/graphql"
+)
+
+const defaultPoolSize = 1000
+
+// TokenStore looks up the access token of a shop, e.g. in the database of the app.
+type TokenStore interface {
+	Token(ctx context.Context, shop string) (string, error)
+}
+
+// TokenStoreFunc adapts a function to the TokenStore interface.
+type TokenStoreFunc func(ctx context.Context, shop string) (string, error)
+
+func (f TokenStoreFunc) Token(ctx context.Context, shop string) (string, error) {
+	return f(ctx, shop)
+}
+
+// PoolOption is used to configure a ClientPool
+type PoolOption func(p *ClientPool)
+
+// WithPoolSize optionally sets the maximum number of cached clients, the least recently used ones being evicted.
+// Defaults to 1000.
+func WithPoolSize(size int) PoolOption {
+	return func(p *ClientPool) {
+		if size > 0 {
+			p.size = size
+		}
+	}
+}
+
+// WithPoolTransport optionally sets the transport shared by the clients of the pool.
+// Defaults to a clone of http.DefaultTransport keeping more idle connections.
+func WithPoolTransport(transport http.RoundTripper) PoolOption {
+	return func(p *ClientPool) {
+		p.transport = transport
+	}
+}
+
+// WithClientOptions optionally adds options to every client of the pool, such as the API version or a tracer.
+func WithClientOptions(opts ...graphqlclient.Option) PoolOption {
+	return func(p *ClientPool) {
+		p.opts = append(p.opts, opts...)
+	}
+}
+
+// WithPoolBulkCoordinator optionally sets the coordinator limiting the bulk operations run at once per shop
+// by the clients of the pool. Defaults to the coordinator shared by all the clients.
+func WithPoolBulkCoordinator(coordinator *BulkCoordinator) PoolOption {
+	return func(p *ClientPool) {
+		p.bulkCoordinator = coordinator
+	}
+}
+
+// WithPoolBulkOperationNotifier optionally sets the notifier of the finished bulk operations on the clients
+// of the pool, see Client.SetBulkOperationNotifier.
+func WithPoolBulkOperationNotifier(notifier BulkOperationNotifier, timeout time.Duration) PoolOption {
+	return func(p *ClientPool) {
+		p.bulkNotifier = notifier
+		p.bulkNotifierTimeout = timeout
+	}
+}
+
+// ClientPool caches the clients of many shops, looking up their access tokens in a TokenStore.
+// The clients share one transport, so that connections are reused, while each shop keeps its own rate limit state:
+// a cost limiter kept by the pool apart from the cached clients, so that an evicted or invalidated client still in
+// use and its replacement share the leaky bucket of the shop. A cost limiter set with WithClientOptions overrides it.
+// A ClientPool is safe for concurrent use.
+type ClientPool struct {
+	tokens    TokenStore
+	size      int
+	transport http.RoundTripper
+	opts      []graphqlclient.Option
+	// bulkCoordinator is set on every client of the pool if not nil
+	bulkCoordinator *BulkCoordinator
+	// bulkNotifier is set on every client of the pool if not nil
+	bulkNotifier        BulkOperationNotifier
+	bulkNotifierTimeout time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List
+	clients map[string]*list.Element
+	// limiters holds the cost limiter of every shop a client was built for, they aren't evicted with the clients
+	limiters map[string]*graphql.CostLimiter
+}
+
+type poolEntry struct {
+	shop   string
+	client *Client
+}
+
+// NewClientPool returns a pool building the clients of the shops with the tokens of the store.
+func NewClientPool(tokens TokenStore, opts ...PoolOption) *ClientPool {
+	p := &ClientPool{
+		tokens:   tokens,
+		size:     defaultPoolSize,
+		lru:      list.New(),
+		clients:  make(map[string]*list.Element),
+		limiters: make(map[string]*graphql.CostLimiter),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.transport == nil {
+		p.transport = newPoolTransport()
+	}
+	return p
+}
+
+// Client returns the client of shop, the shop's myshopify domain, building it on first use.
+func (p *ClientPool) Client(ctx context.Context, shop string) (*Client, error) {
+	if client := p.get(shop); client != nil {
+		return client, nil
+	}
+
+	token, err := p.tokens.Token(ctx, shop)
+	if err != nil {
+		return nil, fmt.Errorf("get token of %s: %w", shop, err)
+	}
+	opts := []graphqlclient.Option{
+		graphqlclient.WithVersion(shopifyAPIVersion),
+		graphqlclient.WithToken(token),
+		graphqlclient.WithBaseTransport(p.transport),
+		graphqlclient.WithCostLimiter(p.costLimiter(shop)),
+	}
+	client := NewClientWithOpts(shop, append(opts, p.opts...)...)
+	if p.bulkCoordinator != nil {
+		client.SetBulkCoordinator(p.bulkCoordinator)
+	}
+	if p.bulkNotifier != nil {
+		client.SetBulkOperationNotifier(p.bulkNotifier, p.bulkNotifierTimeout)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Another goroutine may have built the client in the meantime
+	if e, ok := p.clients[shop]; ok {
+		p.lru.MoveToFront(e)
+		return e.Value.(*poolEntry).client, nil
+	}
+	p.clients[shop] = p.lru.PushFront(&poolEntry{shop: shop, client: client})
+	for p.lru.Len() > p.size {
+		oldest := p.lru.Back()
+		p.lru.Remove(oldest)
+		delete(p.clients, oldest.Value.(*poolEntry).shop)
+	}
+	return client, nil
+}
+
+// Invalidate removes the client of shop, e.g. after the app was uninstalled or the access token was rotated,
+// so that the next call to Client looks up the token again.
+func (p *ClientPool) Invalidate(shop string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.clients[shop]; ok {
+		p.lru.Remove(e)
+		delete(p.clients, shop)
+	}
+}
+
+// Len returns the number of cached clients.
+func (p *ClientPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// costLimiter returns the cost limiter of shop, creating it on first use.
+func (p *ClientPool) costLimiter(shop string) *graphql.CostLimiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	limiter, ok := p.limiters[shop]
+	if !ok {
+		limiter = graphql.NewCostLimiter()
+		p.limiters[shop] = limiter
+	}
+	return limiter
+}
+
+func (p *ClientPool) get(shop string) *Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.clients[shop]
+	if !ok {
+		return nil
+	}
+	p.lru.MoveToFront(e)
+	return e.Value.(*poolEntry).client
+}
+
+// newPoolTransport returns a transport keeping idle connections to many shops.
+func newPoolTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 1000
+	transport.MaxIdleConnsPerHost = 10
+	transport.IdleConnTimeout = 90 * time.Second
+	return transport
+}
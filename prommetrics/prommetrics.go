@@ -0,0 +1,242 @@
+// Package prommetrics exports the measurements of the client to Prometheus.
+//
+//	metrics, err := prommetrics.New(prometheus.DefaultRegisterer)
+//	client := shopify.NewClientWithOpts(domain, graphqlclient.WithToken(token), graphqlclient.WithMetrics(metrics))
+//
+// Clients of several shops can share one Metrics. The measurements are labeled with the operation, and with the shop
+// domain only when WithShopLabel is given, since every shop adds its own series.
+package prommetrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/gempages/go-shopify-graphql/graphql"
+)
+
+const namespace = "shopify_graphql"
+
+const (
+	// DefaultMaxOperations is the number of distinct operations labeled unless another limit is set with
+	// WithMaxOperations, the others being labeled OtherOperation
+	DefaultMaxOperations = 100
+	// OtherOperation labels the operations over the limit
+	OtherOperation = "other"
+	// UnknownOperation labels the requests whose operation couldn't be parsed
+	UnknownOperation = "unknown"
+	// maxOperationLength truncates the operation labels, made of the root fields of the queries
+	maxOperationLength = 100
+)
+
+// Label names
+const (
+	// LabelShop is the shop domain, only set with WithShopLabel
+	LabelShop = "shop"
+	// LabelOperation is the sorted root fields of the query, such as "products"
+	LabelOperation = "operation"
+	// LabelCode is the class of the status code of the response, such as "2xx", or "none" when no response was received
+	LabelCode = "code"
+	// LabelStatus is the final status of a bulk operation
+	LabelStatus = "status"
+)
+
+// Metrics holds the Prometheus collectors.
+type Metrics struct {
+	shopLabel     bool
+	maxOperations int
+
+	mu         sync.Mutex
+	operations map[string]bool
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	retries         *prometheus.CounterVec
+	throttleWait    *prometheus.HistogramVec
+	queryCost       *prometheus.CounterVec
+	bulkDuration    *prometheus.HistogramVec
+	bulkObjectCount *prometheus.HistogramVec
+}
+
+var _ graphql.Metrics = &Metrics{}
+
+// Option is used to configure the metrics
+type Option func(m *Metrics)
+
+// WithShopLabel optionally labels the measurements with the shop domain. Every shop adds its own series,
+// so only enable it for a bounded number of shops.
+func WithShopLabel() Option {
+	return func(m *Metrics) {
+		m.shopLabel = true
+	}
+}
+
+// WithMaxOperations optionally sets the number of distinct operations labeled, DefaultMaxOperations by default.
+// The operations seen once the limit is reached are labeled OtherOperation.
+func WithMaxOperations(n int) Option {
+	return func(m *Metrics) {
+		m.maxOperations = n
+	}
+}
+
+// New returns the collectors of the client measurements, registered with reg.
+func New(reg prometheus.Registerer, opts ...Option) (*Metrics, error) {
+	m := &Metrics{
+		maxOperations: DefaultMaxOperations,
+		operations:    make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	labels := []string{LabelOperation}
+	if m.shopLabel {
+		labels = []string{LabelShop, LabelOperation}
+	}
+	m.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Number of GraphQL request attempts, by class of status code.",
+	}, append(labels, LabelCode))
+	m.requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of GraphQL request attempts.",
+		Buckets:   prometheus.DefBuckets,
+	}, labels)
+	m.retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Number of retried GraphQL requests.",
+	}, labels)
+	m.throttleWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "throttle_wait_seconds",
+		Help:      "Time spent waiting for query cost points or for throttled requests to be retried.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, labels)
+	m.queryCost = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "query_cost_total",
+		Help:      "Query cost points consumed, the actual cost or the requested one when unknown.",
+	}, labels)
+	m.bulkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bulk_operation_duration_seconds",
+		Help:      "Time between the creation and the completion of bulk operations.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, append(labels, LabelStatus))
+	m.bulkObjectCount = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bulk_operation_objects",
+		Help:      "Number of objects returned by bulk operations.",
+		Buckets:   prometheus.ExponentialBuckets(1, 10, 8),
+	}, labels)
+	for _, c := range m.collectors() {
+		if err := reg.Register(c); err != nil {
+			return nil, fmt.Errorf("register collector: %w", err)
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requests,
+		m.requestDuration,
+		m.retries,
+		m.throttleWait,
+		m.queryCost,
+		m.bulkDuration,
+		m.bulkObjectCount,
+	}
+}
+
+func (m *Metrics) ObserveRequest(_ context.Context, r graphql.RequestMetric) {
+	labels := m.labels(r.Shop, r.Operation)
+	m.requests.WithLabelValues(append(labels, codeClass(r.StatusCode))...).Inc()
+	m.requestDuration.WithLabelValues(labels...).Observe(r.Duration.Seconds())
+	if r.Cost != nil {
+		cost := r.Cost.RequestedQueryCost
+		if r.Cost.ActualQueryCost != nil {
+			cost = *r.Cost.ActualQueryCost
+		}
+		m.queryCost.WithLabelValues(labels...).Add(cost)
+	}
+}
+
+func (m *Metrics) ObserveRetry(_ context.Context, r graphql.RetryMetric) {
+	m.retries.WithLabelValues(m.labels(r.Shop, r.Operation)...).Inc()
+}
+
+func (m *Metrics) ObserveThrottleWait(_ context.Context, w graphql.ThrottleWaitMetric) {
+	m.throttleWait.WithLabelValues(m.labels(w.Shop, w.Operation)...).Observe(w.Wait.Seconds())
+}
+
+func (m *Metrics) ObserveBulkOperation(_ context.Context, b graphql.BulkOperationMetric) {
+	labels := m.labels(b.Shop, b.Operation)
+	m.bulkDuration.WithLabelValues(append(labels, b.Status)...).Observe(b.Duration.Seconds())
+	m.bulkObjectCount.WithLabelValues(labels...).Observe(float64(b.ObjectCount))
+}
+
+// labels returns the shop, if labeled, and operation label values of a measurement.
+// The returned slice has room for one more label value.
+func (m *Metrics) labels(shop, operation string) []string {
+	operation = m.operation(operation)
+	if m.shopLabel {
+		return append(make([]string, 0, 3), shop, operation)
+	}
+	return append(make([]string, 0, 2), operation)
+}
+
+// operation returns the label of operation, OtherOperation once the limit of distinct operations is reached.
+func (m *Metrics) operation(operation string) string {
+	operation = normalizeOperation(operation)
+	if operation == UnknownOperation {
+		return operation
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.operations[operation] {
+		return operation
+	}
+	if len(m.operations) >= m.maxOperations {
+		return OtherOperation
+	}
+	m.operations[operation] = true
+	return operation
+}
+
+// normalizeOperation sorts and dedupes the comma separated root fields of an operation, so that aliased
+// and reordered fields share a label, and truncates it.
+func normalizeOperation(operation string) string {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(operation, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" && !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return UnknownOperation
+	}
+	sort.Strings(fields)
+	operation = strings.Join(fields, ",")
+	if len(operation) > maxOperationLength {
+		operation = operation[:maxOperationLength]
+	}
+	return operation
+}
+
+func codeClass(statusCode int) string {
+	if statusCode == 0 {
+		return "none"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
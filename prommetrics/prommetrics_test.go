@@ -0,0 +1,138 @@
+package prommetrics_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	shopify "github.com/gempages/go-shopify-graphql"
+	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/graphql"
+	"github.com/gempages/go-shopify-graphql/prommetrics"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
+)
+
+func TestMetrics(t *testing.T) {
+	srv := shopifytest.NewServer()
+	defer srv.Close()
+	srv.AddProduct(map[string]any{"title": "Blue Shirt"})
+	srv.AddProduct(map[string]any{"title": "Red Shirt"})
+
+	reg := prometheus.NewRegistry()
+	metrics, err := prommetrics.New(reg, prommetrics.WithShopLabel())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := srv.Client(
+		graphqlclient.WithMetrics(metrics),
+		graphqlclient.WithRetryPolicy(graphql.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}),
+	)
+	ctx := context.Background()
+
+	srv.Throttle(1)
+	_, err = client.Product.ListWithFields(ctx, &shopify.ListProductArgs{Fields: "id", First: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	products, err := client.Product.List(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(products) != 2 {
+		t.Errorf("expected (%v) products, got (%v)", 2, len(products))
+	}
+
+	shop := shopifytest.Domain
+	if got := value(t, reg, "shopify_graphql_requests_total", shop, "products", "2xx"); got != 2 {
+		t.Errorf("expected (%v) requests, got (%v)", 2, got)
+	}
+	if got := value(t, reg, "shopify_graphql_retries_total", shop, "products"); got != 1 {
+		t.Errorf("expected (%v) retry, got (%v)", 1, got)
+	}
+	if got := value(t, reg, "shopify_graphql_query_cost_total", shop, "products"); got <= 0 {
+		t.Errorf("expected query cost, got (%v)", got)
+	}
+	if got := value(t, reg, "shopify_graphql_throttle_wait_seconds", shop, "products"); got != 1 {
+		t.Errorf("expected (%v) throttle wait, got (%v)", 1, got)
+	}
+
+	expected := `
+		# HELP shopify_graphql_bulk_operation_objects Number of objects returned by bulk operations.
+		# TYPE shopify_graphql_bulk_operation_objects histogram
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="1"} 0
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="10"} 1
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="100"} 1
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="1000"} 1
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="10000"} 1
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="100000"} 1
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="1e+06"} 1
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="1e+07"} 1
+		shopify_graphql_bulk_operation_objects_bucket{operation="products",shop="shopifytest.myshopify.com",le="+Inf"} 1
+		shopify_graphql_bulk_operation_objects_sum{operation="products",shop="shopifytest.myshopify.com"} 2
+		shopify_graphql_bulk_operation_objects_count{operation="products",shop="shopifytest.myshopify.com"} 1
+	`
+	if err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "shopify_graphql_bulk_operation_objects"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOperationLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := prommetrics.New(reg, prommetrics.WithMaxOperations(2))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx := context.Background()
+	for _, operation := range []string{"shop,products", "products,shop,products", "", "orders", "customers"} {
+		metrics.ObserveRetry(ctx, graphql.RetryMetric{Shop: shopifytest.Domain, Operation: operation})
+	}
+
+	expected := `
+		# HELP shopify_graphql_retries_total Number of retried GraphQL requests.
+		# TYPE shopify_graphql_retries_total counter
+		shopify_graphql_retries_total{operation="orders"} 1
+		shopify_graphql_retries_total{operation="other"} 1
+		shopify_graphql_retries_total{operation="products,shop"} 2
+		shopify_graphql_retries_total{operation="unknown"} 1
+	`
+	if err = testutil.GatherAndCompare(reg, strings.NewReader(expected), "shopify_graphql_retries_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+// value returns the value of the counter, or the sample count of the histogram, of the given shop and operation,
+// and status code class if any.
+func value(t *testing.T, reg *prometheus.Registry, name, shop, operation string, code ...string) float64 {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels[prommetrics.LabelShop] != shop || labels[prommetrics.LabelOperation] != operation {
+				continue
+			}
+			if len(code) > 0 && labels[prommetrics.LabelCode] != code[0] {
+				continue
+			}
+			if metric.GetHistogram() != nil {
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+			return metric.GetCounter().GetValue()
+		}
+	}
+	return 0
+}
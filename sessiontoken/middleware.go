@@ -0,0 +1,163 @@
+package sessiontoken
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql"
+)
+
+// ErrMissingToken is passed to the error handler when the request has no bearer token
+var ErrMissingToken = errors.New("sessiontoken: missing bearer token")
+
+// ErrNoClientPool is returned by Client when the middleware has no client pool
+var ErrNoClientPool = errors.New("sessiontoken: no client pool")
+
+// retryInvalidSessionHeader tells App Bridge to fetch a new session token and retry the request
+const retryInvalidSessionHeader = "X-Shopify-Retry-Invalid-Session-Request"
+
+type contextKey int
+
+const (
+	claimsKey contextKey = iota
+	tokenKey
+	poolKey
+)
+
+// Option is used to configure the middleware
+type Option func(m *middleware)
+
+// WithLeeway optionally sets the clock skew tolerated when checking the expiration of the tokens. Defaults to DefaultLeeway.
+func WithLeeway(leeway time.Duration) Option {
+	return func(m *middleware) {
+		m.leeway = leeway
+	}
+}
+
+// WithClock optionally sets the function returning the current time, e.g. in tests. Defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(m *middleware) {
+		m.now = now
+	}
+}
+
+// WithErrorHandler optionally sets the handler of the requests whose token is missing or invalid.
+// By default, they are answered with 401 Unauthorized and the header asking App Bridge to retry with a new token.
+func WithErrorHandler(handler func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(m *middleware) {
+		m.onError = handler
+	}
+}
+
+// WithClientPool optionally sets the pool Client gets the client of the shop from.
+func WithClientPool(pool *shopify.ClientPool) Option {
+	return func(m *middleware) {
+		m.pool = pool
+	}
+}
+
+type middleware struct {
+	clientID     string
+	clientSecret string
+	leeway       time.Duration
+	now          func() time.Time
+	onError      func(w http.ResponseWriter, r *http.Request, err error)
+	pool         *shopify.ClientPool
+}
+
+// Middleware returns a middleware verifying the session token of every request with the API key and secret of the app,
+// and putting its claims into the request context, see ShopFromContext and UserIDFromContext.
+func Middleware(clientID, clientSecret string, opts ...Option) func(http.Handler) http.Handler {
+	m := &middleware{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		leeway:       DefaultLeeway,
+		now:          time.Now,
+		onError:      unauthorized,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				m.onError(w, r, ErrMissingToken)
+				return
+			}
+			claims, err := Verify(token, m.clientID, m.clientSecret, m.now(), m.leeway)
+			if err != nil {
+				m.onError(w, r, err)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsKey, claims)
+			ctx = context.WithValue(ctx, tokenKey, token)
+			if m.pool != nil {
+				ctx = context.WithValue(ctx, poolKey, m.pool)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClaimsFromContext returns the claims of the session token verified by the middleware.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// TokenFromContext returns the session token verified by the middleware, e.g. to exchange it for an access token.
+func TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenKey).(string)
+	return token, ok
+}
+
+// ShopFromContext returns the myshopify domain of the shop of the session token verified by the middleware.
+func ShopFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return claims.Shop(), true
+}
+
+// UserIDFromContext returns the ID of the user of the session token verified by the middleware.
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return claims.UserID(), true
+}
+
+// Client returns the client of the shop of the session token from the client pool of the middleware.
+func Client(ctx context.Context) (*shopify.Client, error) {
+	shop, ok := ShopFromContext(ctx)
+	if !ok {
+		return nil, ErrMissingToken
+	}
+	pool, ok := ctx.Value(poolKey).(*shopify.ClientPool)
+	if !ok {
+		return nil, ErrNoClientPool
+	}
+	return pool.Client(ctx, shop)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(auth[len("Bearer "):])
+	return token, token != ""
+}
+
+func unauthorized(w http.ResponseWriter, _ *http.Request, _ error) {
+	w.Header().Set(retryInvalidSessionHeader, "1")
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
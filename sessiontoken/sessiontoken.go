@@ -0,0 +1,147 @@
+// Package sessiontoken verifies the App Bridge session tokens that embedded apps send
+// in the "Authorization: Bearer" header, and provides an http.Handler middleware putting the shop and user
+// of the verified token into the request context.
+//
+//	pool := shopify.NewClientPool(tokens)
+//	mux.Handle("/api/", sessiontoken.Middleware(apiKey, apiSecret, sessiontoken.WithClientPool(pool))(api))
+//
+//	func products(w http.ResponseWriter, r *http.Request) {
+//		client, err := sessiontoken.Client(r.Context())
+//		...
+//	}
+package sessiontoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql/oauth"
+)
+
+var (
+	ErrMalformed        = errors.New("sessiontoken: malformed token")
+	ErrInvalidSignature = errors.New("sessiontoken: invalid signature")
+	ErrInvalidAudience  = errors.New("sessiontoken: invalid audience")
+	ErrExpired          = errors.New("sessiontoken: token expired")
+	ErrNotYetValid      = errors.New("sessiontoken: token not valid yet")
+	ErrInvalidShop      = errors.New("sessiontoken: invalid shop")
+)
+
+// DefaultLeeway is the clock skew tolerated when checking the "exp" and "nbf" claims
+const DefaultLeeway = 5 * time.Second
+
+// Claims are the claims of a session token.
+type Claims struct {
+	// Issuer is the admin URL of the shop, e.g. "https://example.myshopify.com/admin"
+	Issuer string `json:"iss"`
+	// Destination is the URL of the shop, e.g. "https://example.myshopify.com"
+	Destination string `json:"dest"`
+	// Audience is the API key of the app
+	Audience string `json:"aud"`
+	// Subject is the ID of the user
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+	IssuedAt  int64  `json:"iat"`
+	ID        string `json:"jti"`
+	// SessionID is the ID of the admin session of the user
+	SessionID string `json:"sid"`
+}
+
+// Shop returns the myshopify domain of the shop, the host of the destination.
+func (c *Claims) Shop() string {
+	u, err := url.Parse(c.Destination)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// UserID returns the ID of the user the token was issued for.
+func (c *Claims) UserID() string {
+	return c.Subject
+}
+
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Verify checks the HS256 signature of token with the app secret and its "aud", "exp", "nbf" and "dest" claims,
+// tolerating a clock skew of leeway, and returns its claims.
+func Verify(token, clientID, clientSecret string, now time.Time, leeway time.Duration) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode header: %w", ErrMalformed, err)
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err = json.Unmarshal(headerData, &h); err != nil {
+		return nil, fmt.Errorf("%w: decode header: %w", ErrMalformed, err)
+	}
+	// Only HS256 is accepted, to rule out tokens signed with "none" or another algorithm
+	if h.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: unexpected algorithm %q", ErrInvalidSignature, h.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode signature: %w", ErrMalformed, err)
+	}
+	if !hmac.Equal(signature, sign(parts[0]+"."+parts[1], clientSecret)) {
+		return nil, ErrInvalidSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode payload: %w", ErrMalformed, err)
+	}
+	claims := &Claims{}
+	if err = json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%w: decode payload: %w", ErrMalformed, err)
+	}
+
+	if claims.Audience != clientID {
+		return nil, ErrInvalidAudience
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)) {
+		return nil, ErrExpired
+	}
+	if now.Add(leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrNotYetValid
+	}
+	shop := claims.Shop()
+	if !oauth.ValidShop(shop) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidShop, claims.Destination)
+	}
+	if issuer, err := url.Parse(claims.Issuer); err != nil || issuer.Host != shop {
+		return nil, fmt.Errorf("%w: issuer %q doesn't match destination %q", ErrInvalidShop, claims.Issuer, claims.Destination)
+	}
+	return claims, nil
+}
+
+// Sign returns a session token of claims signed with the app secret, e.g. to test handlers locally.
+func Sign(claims *Claims, clientSecret string) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("encode claims: %w", err)
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sign(unsigned, clientSecret)), nil
+}
+
+func sign(unsigned, secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,133 @@
+package sessiontoken_test
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql"
+	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/sessiontoken"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
+)
+
+const (
+	clientID     = "api-key"
+	clientSecret = "api-secret"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func claims() *sessiontoken.Claims {
+	return &sessiontoken.Claims{
+		Issuer:      "https://example.myshopify.com/admin",
+		Destination: "https://example.myshopify.com",
+		Audience:    clientID,
+		Subject:     "42",
+		ExpiresAt:   now.Add(time.Minute).Unix(),
+		NotBefore:   now.Add(-time.Second).Unix(),
+		IssuedAt:    now.Add(-time.Second).Unix(),
+		ID:          "f8912129-1af6-4cad-9ca3-76b0f7621087",
+		SessionID:   "aaea182f-1e86-4c8a-8f0c-a3e3f2b8d3b1",
+	}
+}
+
+func sign(t *testing.T, c *sessiontoken.Claims, secret string) string {
+	t.Helper()
+	token, err := sessiontoken.Sign(c, secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	valid := sign(t, claims(), clientSecret)
+	c, err := sessiontoken.Verify(valid, clientID, clientSecret, now, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Shop() != "example.myshopify.com" || c.UserID() != "42" {
+		t.Errorf("expected (%v, %v), got (%v, %v)", "example.myshopify.com", "42", c.Shop(), c.UserID())
+	}
+
+	unsigned := strings.Join(strings.Split(valid, ".")[:2], ".")
+	noneHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	tests := []struct {
+		name     string
+		token    string
+		now      time.Time
+		leeway   time.Duration
+		expected error
+	}{
+		{"malformed", "abc", now, 0, sessiontoken.ErrMalformed},
+		{"wrong secret", sign(t, claims(), "other"), now, 0, sessiontoken.ErrInvalidSignature},
+		{"none algorithm", noneHeader + "." + strings.Split(unsigned, ".")[1] + ".", now, 0, sessiontoken.ErrInvalidSignature},
+		{"wrong audience", sign(t, func() *sessiontoken.Claims { c := claims(); c.Audience = "other"; return c }(), clientSecret), now, 0, sessiontoken.ErrInvalidAudience},
+		{"expired", valid, now.Add(2 * time.Minute), 0, sessiontoken.ErrExpired},
+		{"expired within leeway", valid, now.Add(time.Minute + time.Second), 5 * time.Second, nil},
+		{"not valid yet", valid, now.Add(-time.Minute), 0, sessiontoken.ErrNotYetValid},
+		{"invalid destination", sign(t, func() *sessiontoken.Claims { c := claims(); c.Destination = "https://evil.com"; return c }(), clientSecret), now, 0, sessiontoken.ErrInvalidShop},
+		{"issuer of another shop", sign(t, func() *sessiontoken.Claims { c := claims(); c.Issuer = "https://other.myshopify.com/admin"; return c }(), clientSecret), now, 0, sessiontoken.ErrInvalidShop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sessiontoken.Verify(tt.token, clientID, clientSecret, tt.now, tt.leeway)
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("expected (%v), got (%v)", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	srv := shopifytest.NewServer()
+	defer srv.Close()
+	pool := shopify.NewClientPool(shopify.TokenStoreFunc(func(ctx context.Context, shop string) (string, error) {
+		return shopifytest.AccessToken, nil
+	}), shopify.WithClientOptions(graphqlclient.WithBaseURL(srv.URL)))
+
+	var shop, userID string
+	handler := sessiontoken.Middleware(clientID, clientSecret,
+		sessiontoken.WithClock(func() time.Time { return now }),
+		sessiontoken.WithClientPool(pool),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shop, _ = sessiontoken.ShopFromContext(r.Context())
+		userID, _ = sessiontoken.UserIDFromContext(r.Context())
+		client, err := sessiontoken.Client(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var out map[string]any
+		if err = client.GraphQLClient().QueryString(r.Context(), "query { shop { id } }", nil, &out); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/products", nil)
+	req.Header.Set("Authorization", "Bearer "+sign(t, claims(), clientSecret))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected (%v), got (%v): %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if shop != "example.myshopify.com" || userID != "42" {
+		t.Errorf("expected (%v, %v), got (%v, %v)", "example.myshopify.com", "42", shop, userID)
+	}
+
+	for _, auth := range []string{"", "Basic abc", "Bearer " + sign(t, claims(), "other")} {
+		req = httptest.NewRequest(http.MethodGet, "/api/products", nil)
+		req.Header.Set("Authorization", auth)
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("X-Shopify-Retry-Invalid-Session-Request") != "1" {
+			t.Errorf("expected (%v) with retry header for %q, got (%v)", http.StatusUnauthorized, auth, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package shopifytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+func init() {
+	mutations["bulkOperationRunQuery"] = func(s *Server, args map[string]any) (any, error) {
+		query, _ := args["query"].(string)
+		return s.runBulkQuery(query), nil
+	}
+	mutations["bulkOperationRunMutation"] = func(s *Server, args map[string]any) (any, error) {
+		mutation, _ := args["mutation"].(string)
+		path, _ := args["stagedUploadPath"].(string)
+		return s.runBulkMutation(mutation, path), nil
+	}
+	mutations["stagedUploadsCreate"] = func(s *Server, args map[string]any) (any, error) {
+		inputs, _ := args["input"].([]any)
+		targets := make([]any, 0, len(inputs))
+		for _, in := range inputs {
+			input, _ := in.(map[string]any)
+			filename, _ := input["filename"].(string)
+			s.nextID++
+			key := fmt.Sprintf("%s%d/%s", stagedUploadPrefix, s.nextID, filename)
+			targets = append(targets, map[string]any{
+				"url":         s.URL + "/" + stagedUploadPrefix,
+				"resourceUrl": s.URL + "/" + key,
+				"parameters": []any{
+					map[string]any{"name": "key", "value": key},
+					map[string]any{"name": "Content-Type", "value": input["mimeType"]},
+					map[string]any{"name": "success_action_status", "value": "201"},
+				},
+			})
+		}
+		return payload("stagedTargets", targets), nil
+	}
+	mutations["bulkOperationCancel"] = func(s *Server, args map[string]any) (any, error) {
+		op := s.lookup(args["id"], "BulkOperation")
+		if op == nil {
+			return payload("bulkOperation", nil, UserError{Field: []string{"id"}, Message: "Bulk operation does not exist"}), nil
+		}
+		if op["status"] != "CREATED" && op["status"] != "RUNNING" {
+			return payload("bulkOperation", op, UserError{
+				Field:   []string{"id"},
+				Message: fmt.Sprintf("A bulk operation cannot be canceled when it is %s", op["status"]),
+			}), nil
+		}
+		op["status"] = "CANCELED"
+		return payload("bulkOperation", op), nil
+	}
+}
+
+// runBulkQuery executes query right away and stores the completed bulk operation, whose result is served
+// in the JSONL format of Shopify: one line per node, nested connection nodes referring to their parent with __parentId.
+// The caller must hold s.mu.
+func (s *Server) runBulkQuery(query string) map[string]any {
+	if op := s.runningBulkOperation("QUERY"); op != nil {
+		return payload("bulkOperation", nil, UserError{
+			Message: fmt.Sprintf("A bulk query operation for this app and shop is already in progress: %s.", op["id"]),
+			Code:    "OPERATION_IN_PROGRESS",
+		})
+	}
+	doc, gerr := parser.ParseQuery(&ast.Source{Input: query})
+	if gerr != nil {
+		return payload("bulkOperation", nil, UserError{Field: []string{"query"}, Message: "Invalid bulk query: " + gerr.Error()})
+	}
+	if len(doc.Operations) != 1 || doc.Operations[0].Operation != ast.Query {
+		return payload("bulkOperation", nil, UserError{Field: []string{"query"}, Message: "Bulk query must contain a single query operation"})
+	}
+
+	e := &executor{s: s, doc: doc}
+	data, errs := e.run(doc.Operations[0])
+	if len(errs) > 0 {
+		userErrors := make([]UserError, 0, len(errs))
+		for _, err := range errs {
+			userErrors = append(userErrors, UserError{Field: []string{"query"}, Message: "Invalid bulk query: " + err.Message})
+		}
+		return payload("bulkOperation", nil, userErrors...)
+	}
+
+	var result bulkResult
+	for _, key := range sortedKeys(data) {
+		result.flatten(data[key], nil)
+	}
+	failure, failed := s.nextBulkFailure(&result)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	op := map[string]any{
+		"type":            "QUERY",
+		"status":          "COMPLETED",
+		"errorCode":       nil,
+		"query":           query,
+		"objectCount":     fmt.Sprint(result.objects),
+		"rootObjectCount": fmt.Sprint(result.roots),
+		"fileSize":        nil,
+		"url":             nil,
+		"partialDataUrl":  nil,
+		"completedAt":     now,
+	}
+	id := s.put("BulkOperation", op)
+	if failed {
+		s.failBulkOperation(id, failure, &result)
+	} else if result.objects > 0 {
+		path := fmt.Sprintf("/bulk/%s.jsonl", legacyID(id))
+		s.files[path] = result.buf.Bytes()
+		s.objects[id]["url"] = s.URL + path
+		s.objects[id]["fileSize"] = fmt.Sprint(result.buf.Len())
+	}
+	return payload("bulkOperation", id)
+}
+
+// runningBulkOperation returns the created or running bulk operation of type opType, e.g. one added with Add
+// to simulate a long operation, nil if there is none. The caller must hold s.mu.
+func (s *Server) runningBulkOperation(opType string) map[string]any {
+	for _, v := range s.list("BulkOperation") {
+		if op := v.(map[string]any); op["type"] == opType && (op["status"] == "CREATED" || op["status"] == "RUNNING") {
+			return op
+		}
+	}
+	return nil
+}
+
+// stagedUploadPrefix is the path staged uploads are posted to, and the prefix of their key
+const stagedUploadPrefix = "tmp/shopifytest/staged/"
+
+// serveStagedUpload stores the file of a multipart staged upload under its key, and serves it from the path /key.
+func (s *Server) serveStagedUpload(w http.ResponseWriter, r *http.Request) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := r.FormValue("key")
+	if !strings.HasPrefix(key, stagedUploadPrefix) {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.files["/"+key] = data
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusCreated)
+}
+
+// runBulkMutation runs mutation with the variables of each line of the staged upload right away and stores
+// the completed bulk operation, whose result has one line per variables line, with its __lineNumber.
+// The caller must hold s.mu.
+func (s *Server) runBulkMutation(mutation, stagedUploadPath string) map[string]any {
+	if op := s.runningBulkOperation("MUTATION"); op != nil {
+		return payload("bulkOperation", nil, UserError{
+			Message: fmt.Sprintf("A bulk mutation operation for this app and shop is already in progress: %s.", op["id"]),
+			Code:    "OPERATION_IN_PROGRESS",
+		})
+	}
+	variables, ok := s.files["/"+stagedUploadPath]
+	if !ok {
+		return payload("bulkOperation", nil, UserError{Field: []string{"stagedUploadPath"}, Message: "The staged upload path is invalid", Code: "NO_SUCH_FILE"})
+	}
+	doc, gerr := parser.ParseQuery(&ast.Source{Input: mutation})
+	if gerr != nil || len(doc.Operations) != 1 || doc.Operations[0].Operation != ast.Mutation {
+		return payload("bulkOperation", nil, UserError{Field: []string{"mutation"}, Message: "Invalid bulk mutation", Code: "INVALID_MUTATION"})
+	}
+
+	var result bulkResult
+	for i, line := range bytes.Split(bytes.TrimSpace(variables), []byte("\n")) {
+		var vars map[string]any
+		if err := json.Unmarshal(line, &vars); err != nil {
+			return payload("bulkOperation", nil, UserError{Field: []string{"stagedUploadPath"}, Message: "Invalid JSONL: " + err.Error(), Code: "INVALID_STAGED_UPLOAD_FILE"})
+		}
+		e := &executor{s: s, doc: doc, vars: vars}
+		data, errs := e.run(doc.Operations[0])
+		out := map[string]any{"__lineNumber": i}
+		if data != nil {
+			out["data"] = data
+		}
+		if len(errs) > 0 {
+			out["errors"] = errs
+		}
+		b, _ := json.Marshal(out)
+		result.buf.Write(b)
+		result.buf.WriteByte('\n')
+		result.objects++
+		result.roots++
+	}
+	failure, failed := s.nextBulkFailure(&result)
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	op := map[string]any{
+		"type":            "MUTATION",
+		"status":          "COMPLETED",
+		"errorCode":       nil,
+		"query":           mutation,
+		"objectCount":     fmt.Sprint(result.objects),
+		"rootObjectCount": fmt.Sprint(result.roots),
+		"fileSize":        fmt.Sprint(result.buf.Len()),
+		"partialDataUrl":  nil,
+		"createdAt":       now,
+		"completedAt":     now,
+	}
+	id := s.put("BulkOperation", op)
+	if failed {
+		s.failBulkOperation(id, failure, &result)
+		return payload("bulkOperation", id)
+	}
+	path := fmt.Sprintf("/bulk/%s.jsonl", legacyID(id))
+	s.files[path] = result.buf.Bytes()
+	s.objects[id]["url"] = s.URL + path
+	return payload("bulkOperation", id)
+}
+
+// bulkFailure is the failure of a bulk operation queued by FailBulkOperation.
+type bulkFailure struct {
+	errorCode string
+	objects   int
+}
+
+// nextBulkFailure dequeues the failure of the next bulk operation, truncating its result to the lines produced
+// before it failed. The caller must hold s.mu.
+func (s *Server) nextBulkFailure(result *bulkResult) (bulkFailure, bool) {
+	if len(s.bulkFailures) == 0 {
+		return bulkFailure{}, false
+	}
+	failure := s.bulkFailures[0]
+	s.bulkFailures = s.bulkFailures[1:]
+	result.truncate(failure.objects)
+	return failure, true
+}
+
+// failBulkOperation marks the stored bulk operation id as failed, serving its result as partial data.
+// The caller must hold s.mu.
+func (s *Server) failBulkOperation(id string, failure bulkFailure, result *bulkResult) {
+	op := s.objects[id]
+	op["status"] = "FAILED"
+	op["errorCode"] = failure.errorCode
+	op["url"] = nil
+	op["objectCount"] = fmt.Sprint(result.objects)
+	op["rootObjectCount"] = fmt.Sprint(result.roots)
+	if result.objects > 0 {
+		path := fmt.Sprintf("/bulk/%s.partial.jsonl", legacyID(id))
+		s.files[path] = result.buf.Bytes()
+		op["partialDataUrl"] = s.URL + path
+		op["fileSize"] = fmt.Sprint(result.buf.Len())
+	}
+}
+
+// bulkResult accumulates the JSONL lines of a bulk operation.
+type bulkResult struct {
+	buf     bytes.Buffer
+	objects int
+	roots   int
+}
+
+// truncate keeps the first n lines of the result.
+func (r *bulkResult) truncate(n int) {
+	if n >= r.objects {
+		return
+	}
+	lines := bytes.SplitAfter(r.buf.Bytes(), []byte("\n"))
+	var buf bytes.Buffer
+	roots := 0
+	for _, line := range lines[:n] {
+		buf.Write(line)
+		if !bytes.Contains(line, []byte(`"__parentId"`)) {
+			roots++
+		}
+	}
+	r.buf = buf
+	r.objects = n
+	r.roots = roots
+}
+
+// flatten writes the nodes of the connections found in value, the nodes of nested connections being written
+// after their parent.
+func (r *bulkResult) flatten(value any, parentID any) {
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return
+	}
+	if !isConnectionValue(obj) {
+		// The connections of an object such as the shop are written as roots
+		for _, key := range sortedKeys(obj) {
+			r.flatten(obj[key], parentID)
+		}
+		return
+	}
+	for _, node := range connectionNodes(obj) {
+		line := make(map[string]any, len(node))
+		var children []any
+		for _, key := range sortedKeys(node) {
+			if child, ok := node[key].(map[string]any); ok && isConnectionValue(child) {
+				children = append(children, child)
+				continue
+			}
+			line[key] = node[key]
+		}
+		if parentID != nil {
+			line["__parentId"] = parentID
+		} else {
+			r.roots++
+		}
+		r.objects++
+		b, _ := json.Marshal(line)
+		r.buf.Write(b)
+		r.buf.WriteByte('\n')
+
+		for _, child := range children {
+			r.flatten(child, node["id"])
+		}
+	}
+}
+
+func isConnectionValue(obj map[string]any) bool {
+	_, edges := obj["edges"].([]any)
+	_, nodes := obj["nodes"].([]any)
+	return edges || nodes
+}
+
+func connectionNodes(conn map[string]any) []map[string]any {
+	var out []map[string]any
+	if edges, ok := conn["edges"].([]any); ok {
+		for _, edge := range edges {
+			if node, ok := edge.(map[string]any)["node"].(map[string]any); ok {
+				out = append(out, node)
+			}
+		}
+		return out
+	}
+	nodes, _ := conn["nodes"].([]any)
+	for _, node := range nodes {
+		if node, ok := node.(map[string]any); ok {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
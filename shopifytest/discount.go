@@ -0,0 +1,246 @@
+package shopifytest
+
+import (
+	"time"
+)
+
+// Automatic app discounts are stored as a DiscountAutomaticNode whose automaticDiscount refers to a
+// DiscountAutomaticApp, its discountId being the ID of the node. The status of a discount is computed from its
+// startsAt and endsAt when it's queried.
+func init() {
+	queries["automaticDiscountNode"] = func(s *Server, args map[string]any) (any, error) {
+		return nilIfMissing(s.lookup(args["id"], "DiscountAutomaticNode")), nil
+	}
+	mutations["discountAutomaticAppCreate"] = func(s *Server, args map[string]any) (any, error) {
+		input, _ := args["automaticAppDiscount"].(map[string]any)
+		if userErrors := validateAutomaticAppDiscount(nil, input); len(userErrors) > 0 {
+			return payload("automaticAppDiscount", nil, userErrors...), nil
+		}
+		node := s.createAutomaticAppDiscount(input)
+		return payload("automaticAppDiscount", node["automaticDiscount"]), nil
+	}
+	mutations["discountAutomaticAppUpdate"] = func(s *Server, args map[string]any) (any, error) {
+		discount := s.automaticAppDiscount(args["id"])
+		if discount == nil {
+			return payload("automaticAppDiscount", nil, discountNotFound()), nil
+		}
+		input, _ := args["automaticAppDiscount"].(map[string]any)
+		if userErrors := validateAutomaticAppDiscount(discount, input); len(userErrors) > 0 {
+			return payload("automaticAppDiscount", nil, userErrors...), nil
+		}
+		s.updateAutomaticAppDiscount(discount, input)
+		return payload("automaticAppDiscount", discount), nil
+	}
+	mutations["discountAutomaticDelete"] = func(s *Server, args map[string]any) (any, error) {
+		node := s.lookup(args["id"], "DiscountAutomaticNode")
+		if node == nil {
+			return payload("deletedAutomaticDiscountId", nil, discountNotFound()), nil
+		}
+		if ref, ok := node["automaticDiscount"].(string); ok {
+			s.remove(ref)
+		}
+		s.remove(node["id"].(string))
+		return payload("deletedAutomaticDiscountId", node["id"]), nil
+	}
+	mutations["discountAutomaticActivate"] = func(s *Server, args map[string]any) (any, error) {
+		discount := s.automaticAppDiscount(args["id"])
+		if discount == nil {
+			return payload("automaticDiscountNode", nil, discountNotFound()), nil
+		}
+		// The discount starts now unless it's already started, and no longer ends if it's expired
+		now := time.Now().UTC()
+		if startsAt, ok := discountTime(discount["startsAt"]); !ok || startsAt.After(now) {
+			discount["startsAt"] = now.Format(time.RFC3339)
+		}
+		if endsAt, ok := discountTime(discount["endsAt"]); ok && !endsAt.After(now) {
+			discount["endsAt"] = nil
+		}
+		discount["updatedAt"] = now.Format(time.RFC3339)
+		return payload("automaticDiscountNode", discount["discountId"]), nil
+	}
+	mutations["discountAutomaticDeactivate"] = func(s *Server, args map[string]any) (any, error) {
+		discount := s.automaticAppDiscount(args["id"])
+		if discount == nil {
+			return payload("automaticDiscountNode", nil, discountNotFound()), nil
+		}
+		now := time.Now().UTC()
+		if endsAt, ok := discountTime(discount["endsAt"]); !ok || endsAt.After(now) {
+			discount["endsAt"] = now.Format(time.RFC3339)
+		}
+		discount["updatedAt"] = now.Format(time.RFC3339)
+		return payload("automaticDiscountNode", discount["discountId"]), nil
+	}
+
+	computedFields["status"] = func(s *Server, obj map[string]any, _ map[string]any) any {
+		if obj["__typename"] != "DiscountAutomaticApp" {
+			return obj["status"]
+		}
+		return discountStatus(obj, time.Now())
+	}
+
+	argumentTypes["automaticDiscountNode"] = map[string]string{"id": "ID!"}
+	argumentTypes["discountAutomaticAppCreate"] = map[string]string{"automaticAppDiscount": "DiscountAutomaticAppInput!"}
+	argumentTypes["discountAutomaticAppUpdate"] = map[string]string{"id": "ID!", "automaticAppDiscount": "DiscountAutomaticAppInput!"}
+	argumentTypes["discountAutomaticDelete"] = map[string]string{"id": "ID!"}
+	argumentTypes["discountAutomaticActivate"] = map[string]string{"id": "ID!"}
+	argumentTypes["discountAutomaticDeactivate"] = map[string]string{"id": "ID!"}
+}
+
+// createAutomaticAppDiscount stores an automatic app discount from its input and returns its node.
+// The caller must hold s.mu.
+func (s *Server) createAutomaticAppDiscount(input map[string]any) map[string]any {
+	nodeID := s.put("DiscountAutomaticNode", map[string]any{"metafields": []any{}})
+	functionID, _ := input["functionId"].(string)
+	discount := map[string]any{
+		"discountId":    nodeID,
+		"title":         input["title"],
+		"startsAt":      input["startsAt"],
+		"endsAt":        input["endsAt"],
+		"discountClass": "PRODUCT",
+		"appDiscountType": map[string]any{
+			"appKey":     "shopifytest",
+			"functionId": functionID,
+		},
+		"combinesWith": combinesWith(nil, input["combinesWith"]),
+	}
+	if discount["startsAt"] == nil {
+		discount["startsAt"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	node := s.objects[nodeID]
+	node["automaticDiscount"] = s.put("DiscountAutomaticApp", discount)
+	s.setDiscountMetafields(nodeID, input["metafields"])
+	return node
+}
+
+// updateAutomaticAppDiscount sets the fields of the discount given by its input, an explicit null endsAt
+// removing its end date. The caller must hold s.mu.
+func (s *Server) updateAutomaticAppDiscount(discount map[string]any, input map[string]any) {
+	for k, v := range input {
+		switch k {
+		case "endsAt":
+			discount[k] = v
+		case "combinesWith":
+			discount[k] = combinesWith(discount[k], v)
+		case "metafields":
+			s.setDiscountMetafields(discount["discountId"].(string), v)
+		case "functionId":
+			// The function of a discount can't be changed
+		default:
+			if v != nil {
+				discount[k] = v
+			}
+		}
+	}
+	discount["updatedAt"] = time.Now().UTC().Format(time.RFC3339)
+}
+
+// automaticAppDiscount returns the automatic app discount of the node with the given ID, the caller must hold s.mu.
+func (s *Server) automaticAppDiscount(id any) map[string]any {
+	node := s.lookup(id, "DiscountAutomaticNode")
+	if node == nil {
+		return nil
+	}
+	return s.lookup(node["automaticDiscount"], "DiscountAutomaticApp")
+}
+
+func (s *Server) setDiscountMetafields(ownerID string, inputs any) {
+	metafields, _ := inputs.([]any)
+	for _, in := range metafields {
+		input, _ := in.(map[string]any)
+		namespace, _ := input["namespace"].(string)
+		key, _ := input["key"].(string)
+		value, _ := input["value"].(string)
+		valueType, _ := input["type"].(string)
+		s.setMetafield(ownerID, namespace, key, value, valueType)
+	}
+}
+
+// validateAutomaticAppDiscount checks the input of an automatic app discount against the rules enforced by
+// Shopify, discount being the discount updated by the input, nil on create.
+func validateAutomaticAppDiscount(discount map[string]any, input map[string]any) []UserError {
+	var userErrors []UserError
+	create := discount == nil
+	if title, ok := input["title"]; create || ok {
+		if s, _ := title.(string); s == "" {
+			userErrors = append(userErrors, UserError{
+				Field:   []string{"automaticAppDiscount", "title"},
+				Message: "Title can't be blank",
+				Code:    "BLANK",
+			})
+		}
+	}
+	if create {
+		if functionID, _ := input["functionId"].(string); functionID == "" {
+			userErrors = append(userErrors, UserError{
+				Field:   []string{"automaticAppDiscount", "functionId"},
+				Message: "Function not found.",
+				Code:    "INVALID",
+			})
+		}
+	}
+	startsAt, endsAt := input["startsAt"], input["endsAt"]
+	if _, ok := input["startsAt"]; !ok && discount != nil {
+		startsAt = discount["startsAt"]
+	}
+	if _, ok := input["endsAt"]; !ok && discount != nil {
+		endsAt = discount["endsAt"]
+	}
+	start, hasStart := discountTime(startsAt)
+	end, hasEnd := discountTime(endsAt)
+	if !hasStart {
+		start = time.Now()
+	}
+	if hasEnd && !end.After(start) {
+		userErrors = append(userErrors, UserError{
+			Field:   []string{"automaticAppDiscount", "endsAt"},
+			Message: "Ends at needs to be after starts_at",
+			Code:    "INVALID",
+		})
+	}
+	return userErrors
+}
+
+// discountStatus returns the status of a discount at the given time: SCHEDULED before it starts, EXPIRED once it
+// ends and ACTIVE in between.
+func discountStatus(discount map[string]any, now time.Time) string {
+	if startsAt, ok := discountTime(discount["startsAt"]); ok && startsAt.After(now) {
+		return "SCHEDULED"
+	}
+	if endsAt, ok := discountTime(discount["endsAt"]); ok && !endsAt.After(now) {
+		return "EXPIRED"
+	}
+	return "ACTIVE"
+}
+
+// combinesWith merges the combinesWith input into the current combinations, which default to false.
+func combinesWith(current any, input any) map[string]any {
+	out := map[string]any{
+		"orderDiscounts":    false,
+		"productDiscounts":  false,
+		"shippingDiscounts": false,
+	}
+	if m, ok := current.(map[string]any); ok {
+		for k, v := range m {
+			out[k] = v
+		}
+	}
+	if m, ok := input.(map[string]any); ok {
+		for k, v := range m {
+			if v != nil {
+				out[k] = v
+			}
+		}
+	}
+	return out
+}
+
+func discountTime(v any) (time.Time, bool) {
+	s, _ := v.(string)
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+func discountNotFound() UserError {
+	// Shopify doesn't return a code for missing discounts
+	return UserError{Field: []string{"id"}, Message: "The discount doesn't exist."}
+}
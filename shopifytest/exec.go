@@ -0,0 +1,415 @@
+package shopifytest
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+
+	"github.com/gempages/go-shopify-graphql/graphql"
+)
+
+// abstractTypes lists the object types implementing the interfaces and unions used by the services' queries.
+// Inline fragments on any other type only match objects of that exact type.
+var abstractTypes = map[string][]string{
+	"Media":                        {"MediaImage", "Model3d", "Video", "ExternalVideo"},
+	"AppPricingDetails":            {"AppRecurringPricing", "AppUsagePricing"},
+	"AppSubscriptionDiscountValue": {"AppSubscriptionDiscountAmount", "AppSubscriptionDiscountPercentage"},
+	"WebhookSubscriptionEndpoint":  {"WebhookHttpEndpoint", "WebhookEventBridgeEndpoint", "WebhookPubSubEndpoint"},
+}
+
+// connectionArguments are the arguments of a connection which aren't used to filter its nodes.
+var connectionArguments = []string{"first", "last", "after", "before", "reverse", "sortKey", "query"}
+
+// executor resolves an operation against the server's store. The server's lock is held during execution.
+type executor struct {
+	s    *Server
+	doc  *ast.QueryDocument
+	vars map[string]any
+	errs []*graphql.GraphQLError
+}
+
+func (e *executor) execute(op *ast.OperationDefinition) (map[string]any, []*graphql.GraphQLError) {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+	return e.run(op)
+}
+
+// run executes op, the caller must hold the server's lock.
+func (e *executor) run(op *ast.OperationDefinition) (map[string]any, []*graphql.GraphQLError) {
+	// Unknown fields are rejected before anything is executed, like a validation error
+	for _, f := range e.fields(op.SelectionSet, "") {
+		if !e.s.resolvable(op.Operation, f.Name) {
+			typeName := "QueryRoot"
+			if op.Operation == ast.Mutation {
+				typeName = "Mutation"
+			}
+			e.errs = append(e.errs, &graphql.GraphQLError{
+				Message: fmt.Sprintf("Field '%s' doesn't exist on type '%s'", f.Name, typeName),
+				Path:    []any{string(op.Operation), f.Name},
+				Extensions: map[string]any{
+					"code":      "undefinedField",
+					"typeName":  typeName,
+					"fieldName": f.Name,
+				},
+			})
+		}
+	}
+	e.checkVariableTypes(op)
+	if len(e.errs) > 0 {
+		return nil, e.errs
+	}
+
+	data := make(map[string]any)
+	for _, f := range e.fields(op.SelectionSet, "") {
+		if f.Name == "__typename" {
+			data[f.Alias] = "QueryRoot"
+			continue
+		}
+		value, err := e.resolveRoot(op.Operation, f)
+		if err != nil {
+			e.fail(err, []any{f.Alias})
+			data[f.Alias] = nil
+			continue
+		}
+		data[f.Alias] = e.complete(value, f, []any{f.Alias})
+	}
+	return data, e.errs
+}
+
+// resolveRoot resolves a root field with its handler, a queued user error or the built-in resolver.
+func (e *executor) resolveRoot(op ast.Operation, f *ast.Field) (any, error) {
+	args, err := e.args(f)
+	if err != nil {
+		return nil, err
+	}
+	if handler, ok := e.s.handlers[f.Name]; ok {
+		// The handler may use the server
+		e.s.mu.Unlock()
+		defer e.s.mu.Lock()
+		return handler(args)
+	}
+	if op == ast.Mutation {
+		if queued := e.s.userErrors[f.Name]; len(queued) > 0 {
+			e.s.userErrors[f.Name] = queued[1:]
+			return map[string]any{"userErrors": userErrorValues(queued[0])}, nil
+		}
+		return mutations[f.Name](e.s, args)
+	}
+	return queries[f.Name](e.s, args)
+}
+
+// complete shapes value according to the selection set of f.
+func (e *executor) complete(value any, f *ast.Field, path []any) any {
+	if len(f.SelectionSet) == 0 {
+		return value
+	}
+	if value == nil {
+		if !e.isConnection(f.SelectionSet) {
+			return nil
+		}
+		// Connections are never null
+		value = []any{}
+	}
+	if id, ok := value.(string); ok {
+		obj := e.s.lookup(id, "")
+		if obj == nil {
+			return nil
+		}
+		value = obj
+	}
+	switch v := value.(type) {
+	case []any:
+		if e.isConnection(f.SelectionSet) {
+			conn, err := e.connection(v, f)
+			if err != nil {
+				e.fail(err, path)
+				return nil
+			}
+			return e.selectFields(conn, f.SelectionSet, path)
+		}
+		out := make([]any, 0, len(v))
+		for i, item := range v {
+			out = append(out, e.complete(item, f, append(slices.Clip(path), i)))
+		}
+		return out
+	case map[string]any:
+		return e.selectFields(v, f.SelectionSet, path)
+	}
+	return value
+}
+
+func (e *executor) selectFields(obj map[string]any, set ast.SelectionSet, path []any) map[string]any {
+	out := make(map[string]any)
+	typename, _ := obj["__typename"].(string)
+	for _, f := range e.fields(set, typename) {
+		if f.Name == "__typename" {
+			out[f.Alias] = obj["__typename"]
+			continue
+		}
+		fieldPath := append(slices.Clip(path), f.Alias)
+		value := obj[f.Name]
+		if resolve, ok := computedFields[f.Name]; ok {
+			args, err := e.args(f)
+			if err != nil {
+				e.fail(err, fieldPath)
+				out[f.Alias] = nil
+				continue
+			}
+			value = resolve(e.s, obj, args)
+		}
+		completed := e.complete(value, f, fieldPath)
+		if prev, ok := out[f.Alias].(map[string]any); ok {
+			if next, ok := completed.(map[string]any); ok {
+				completed = mergeMaps(prev, next)
+			}
+		}
+		out[f.Alias] = completed
+	}
+	return out
+}
+
+// fields flattens the fields of a selection set, including the fragments matching typename.
+// checkVariableTypes rejects the variables passed to the arguments of the root fields of op that aren't declared
+// with the type of the argument, see argumentTypes, e.g. a String! argument given an ID! variable.
+func (e *executor) checkVariableTypes(op *ast.OperationDefinition) {
+	for _, f := range e.fields(op.SelectionSet, "") {
+		types := argumentTypes[f.Name]
+		for _, arg := range f.Arguments {
+			expected, ok := types[arg.Name]
+			if !ok || arg.Value == nil || arg.Value.Kind != ast.Variable {
+				continue
+			}
+			def := op.VariableDefinitions.ForName(arg.Value.Raw)
+			if def == nil || def.Type.String() == expected {
+				continue
+			}
+			e.errs = append(e.errs, &graphql.GraphQLError{
+				Message: fmt.Sprintf("Type mismatch on variable $%s and argument %s (%s / %s)", def.Variable, arg.Name, def.Type.String(), expected),
+				Path:    []any{string(op.Operation), f.Name, arg.Name},
+				Extensions: map[string]any{
+					"code":         "variableMismatch",
+					"variableName": def.Variable,
+					"typeName":     def.Type.String(),
+					"argumentName": arg.Name,
+				},
+			})
+		}
+	}
+}
+
+func (e *executor) fields(set ast.SelectionSet, typename string) []*ast.Field {
+	var out []*ast.Field
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			out = append(out, sel)
+		case *ast.InlineFragment:
+			if typeMatches(typename, sel.TypeCondition) {
+				out = append(out, e.fields(sel.SelectionSet, typename)...)
+			}
+		case *ast.FragmentSpread:
+			if def := e.doc.Fragments.ForName(sel.Name); def != nil && typeMatches(typename, def.TypeCondition) {
+				out = append(out, e.fields(def.SelectionSet, typename)...)
+			}
+		}
+	}
+	return out
+}
+
+func (e *executor) isConnection(set ast.SelectionSet) bool {
+	for _, f := range e.fields(set, "") {
+		if f.Name == "edges" || f.Name == "nodes" || f.Name == "pageInfo" {
+			return true
+		}
+	}
+	return false
+}
+
+// connection paginates and filters items according to the arguments of f.
+func (e *executor) connection(items []any, f *ast.Field) (map[string]any, error) {
+	args, err := e.args(f)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if id, ok := item.(string); ok {
+			item = e.s.lookup(id, "")
+		}
+		obj, ok := item.(map[string]any)
+		if !ok || obj == nil || !matchesArguments(obj, args) {
+			continue
+		}
+		nodes = append(nodes, obj)
+	}
+	if reverse, _ := args["reverse"].(bool); reverse {
+		slices.Reverse(nodes)
+	}
+
+	start, end := 0, len(nodes)
+	if after, ok := args["after"].(string); ok {
+		start = cursorIndex(nodes, after) + 1
+	}
+	if before, ok := args["before"].(string); ok {
+		if i := cursorIndex(nodes, before); i >= 0 {
+			end = i
+		}
+	}
+	if start > end {
+		start = end
+	}
+	if first, ok := toInt(args["first"]); ok && start+first < end {
+		end = start + first
+	}
+	if last, ok := toInt(args["last"]); ok && end-last > start {
+		start = end - last
+	}
+
+	page := nodes[start:end]
+	edges := make([]any, 0, len(page))
+	pageNodes := make([]any, 0, len(page))
+	for i, node := range page {
+		edges = append(edges, map[string]any{
+			"cursor": cursor(node, start+i),
+			"node":   node,
+		})
+		pageNodes = append(pageNodes, node)
+	}
+	pageInfo := map[string]any{
+		"hasNextPage":     end < len(nodes),
+		"hasPreviousPage": start > 0,
+		"startCursor":     nil,
+		"endCursor":       nil,
+	}
+	if len(page) > 0 {
+		pageInfo["startCursor"] = cursor(page[0], start)
+		pageInfo["endCursor"] = cursor(page[len(page)-1], end-1)
+	}
+	return map[string]any{
+		"edges":    edges,
+		"nodes":    pageNodes,
+		"pageInfo": pageInfo,
+	}, nil
+}
+
+func (e *executor) args(f *ast.Field) (map[string]any, error) {
+	args := make(map[string]any, len(f.Arguments))
+	for _, arg := range f.Arguments {
+		value, err := arg.Value.Value(e.vars)
+		if err != nil {
+			return nil, err
+		}
+		args[arg.Name] = value
+	}
+	return args, nil
+}
+
+func (e *executor) fail(err error, path []any) {
+	var gerr *graphql.GraphQLError
+	if errors.As(err, &gerr) {
+		out := *gerr
+		out.Path = path
+		e.errs = append(e.errs, &out)
+		return
+	}
+	e.errs = append(e.errs, &graphql.GraphQLError{Message: err.Error(), Path: path})
+}
+
+// matchesArguments reports whether obj matches the search query and the filtering arguments of a connection,
+// e.g. topics of webhookSubscriptions is matched against the topic field.
+func matchesArguments(obj map[string]any, args map[string]any) bool {
+	if query, ok := args["query"].(string); ok && query != "" && !matchesSearch(obj, query) {
+		return false
+	}
+	for name, want := range args {
+		if want == nil || slices.Contains(connectionArguments, name) {
+			continue
+		}
+		if got, ok := obj[name]; ok {
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				return false
+			}
+			continue
+		}
+		singular, plural := strings.CutSuffix(name, "s")
+		wants, isList := want.([]any)
+		got, ok := obj[singular]
+		if !plural || !isList || !ok || len(wants) == 0 {
+			continue
+		}
+		if !slices.ContainsFunc(wants, func(w any) bool { return fmt.Sprint(w) == fmt.Sprint(got) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func typeMatches(typename string, condition string) bool {
+	if condition == "" || typename == "" || condition == typename || condition == "Node" {
+		return true
+	}
+	return slices.Contains(abstractTypes[condition], typename)
+}
+
+func cursor(node map[string]any, index int) string {
+	if id, ok := node["id"].(string); ok {
+		return base64.StdEncoding.EncodeToString([]byte(id))
+	}
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(index)))
+}
+
+func cursorIndex(nodes []map[string]any, c string) int {
+	for i, node := range nodes {
+		if cursor(node, i) == c {
+			return i
+		}
+	}
+	return -1
+}
+
+func toInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+func mergeMaps(a, b map[string]any) map[string]any {
+	out := copyMap(a)
+	for k, v := range b {
+		if am, ok := out[k].(map[string]any); ok {
+			if bm, ok := v.(map[string]any); ok {
+				out[k] = mergeMaps(am, bm)
+				continue
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
+
+func userErrorValues(errs []UserError) []any {
+	out := make([]any, 0, len(errs))
+	for _, err := range errs {
+		field := make([]any, 0, len(err.Field))
+		for _, f := range err.Field {
+			field = append(field, f)
+		}
+		out = append(out, map[string]any{
+			"field":   field,
+			"message": err.Message,
+			"code":    err.Code,
+		})
+	}
+	return out
+}
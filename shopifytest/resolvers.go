@@ -0,0 +1,467 @@
+package shopifytest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+type (
+	rootResolver     func(s *Server, args map[string]any) (any, error)
+	computedResolver func(s *Server, obj map[string]any, args map[string]any) any
+)
+
+// objectFields maps the root fields returning a single object by ID to its type.
+var objectFields = map[string]string{
+	"product":             "Product",
+	"productVariant":      "ProductVariant",
+	"collection":          "Collection",
+	"metafield":           "Metafield",
+	"webhookSubscription": "WebhookSubscription",
+}
+
+// connectionFields maps the root connections to the type of their nodes.
+var connectionFields = map[string]string{
+	"products":             "Product",
+	"productVariants":      "ProductVariant",
+	"collections":          "Collection",
+	"webhookSubscriptions": "WebhookSubscription",
+}
+
+// argumentTypes are the types of the arguments of the root fields, which the variables passed to them must
+// be declared with, as Shopify rejects the operation otherwise. The other arguments aren't checked.
+var argumentTypes = map[string]map[string]string{
+	"node":                                 {"id": "ID!"},
+	"bulkOperationRunQuery":                {"query": "String!"},
+	"bulkOperationRunMutation":             {"mutation": "String!", "stagedUploadPath": "String!"},
+	"bulkOperationCancel":                  {"id": "ID!"},
+	"stagedUploadsCreate":                  {"input": "[StagedUploadInput!]!"},
+	"metafieldsSet":                        {"metafields": "[MetafieldsSetInput!]!"},
+	"webhookSubscriptionCreate":            {"topic": "WebhookSubscriptionTopic!", "webhookSubscription": "WebhookSubscriptionInput!"},
+	"eventBridgeWebhookSubscriptionCreate": {"topic": "WebhookSubscriptionTopic!", "webhookSubscription": "EventBridgeWebhookSubscriptionInput!"},
+	"pubSubWebhookSubscriptionCreate":      {"topic": "WebhookSubscriptionTopic!", "webhookSubscription": "PubSubWebhookSubscriptionInput!"},
+	"webhookSubscriptionUpdate":            {"id": "ID!", "webhookSubscription": "WebhookSubscriptionInput!"},
+	"eventBridgeWebhookSubscriptionUpdate": {"id": "ID!", "webhookSubscription": "EventBridgeWebhookSubscriptionInput!"},
+	"pubSubWebhookSubscriptionUpdate":      {"id": "ID!", "webhookSubscription": "PubSubWebhookSubscriptionInput!"},
+	"webhookSubscriptionDelete":            {"id": "ID!"},
+}
+
+var queries = map[string]rootResolver{
+	"node": func(s *Server, args map[string]any) (any, error) {
+		return nilIfMissing(s.lookup(args["id"], "")), nil
+	},
+	"nodes": func(s *Server, args map[string]any) (any, error) {
+		ids, _ := args["ids"].([]any)
+		out := make([]any, 0, len(ids))
+		for _, id := range ids {
+			out = append(out, nilIfMissing(s.lookup(id, "")))
+		}
+		return out, nil
+	},
+	"shop": func(s *Server, _ map[string]any) (any, error) {
+		return s.objects[ShopID], nil
+	},
+	"currentAppInstallation": func(s *Server, _ map[string]any) (any, error) {
+		return s.objects[AppInstallationID], nil
+	},
+	"currentBulkOperation": func(s *Server, args map[string]any) (any, error) {
+		opType, _ := args["type"].(string)
+		if opType == "" {
+			opType = "QUERY"
+		}
+		ops := s.list("BulkOperation")
+		for i := len(ops) - 1; i >= 0; i-- {
+			if op := ops[i].(map[string]any); op["type"] == opType {
+				return op, nil
+			}
+		}
+		return nil, nil
+	},
+}
+
+var mutations = map[string]rootResolver{
+	"webhookSubscriptionCreate": func(s *Server, args map[string]any) (any, error) {
+		return s.createWebhookSubscription(args, "callbackUrl")
+	},
+	"eventBridgeWebhookSubscriptionCreate": func(s *Server, args map[string]any) (any, error) {
+		return s.createWebhookSubscription(args, "arn")
+	},
+	"pubSubWebhookSubscriptionCreate": func(s *Server, args map[string]any) (any, error) {
+		return s.createWebhookSubscription(args, "pubSubTopic")
+	},
+	"webhookSubscriptionUpdate": func(s *Server, args map[string]any) (any, error) {
+		return s.updateWebhookSubscription(args)
+	},
+	"eventBridgeWebhookSubscriptionUpdate": func(s *Server, args map[string]any) (any, error) {
+		return s.updateWebhookSubscription(args)
+	},
+	"pubSubWebhookSubscriptionUpdate": func(s *Server, args map[string]any) (any, error) {
+		return s.updateWebhookSubscription(args)
+	},
+	"webhookSubscriptionDelete": func(s *Server, args map[string]any) (any, error) {
+		obj := s.lookup(args["id"], "WebhookSubscription")
+		if obj == nil {
+			return payload("deletedWebhookSubscriptionId", nil, UserError{Field: []string{"id"}, Message: "Webhook subscription does not exist"}), nil
+		}
+		s.remove(obj["id"].(string))
+		return payload("deletedWebhookSubscriptionId", obj["id"]), nil
+	},
+	"metafieldsSet": func(s *Server, args map[string]any) (any, error) {
+		inputs, _ := args["metafields"].([]any)
+		var userErrors []UserError
+		for i, in := range inputs {
+			input, _ := in.(map[string]any)
+			if s.lookup(input["ownerId"], "") == nil {
+				userErrors = append(userErrors, UserError{
+					Field:   []string{"metafields", fmt.Sprint(i), "ownerId"},
+					Message: "Owner does not exist.",
+					Code:    "INVALID",
+				})
+			}
+		}
+		if len(userErrors) > 0 {
+			return payload("metafields", nil, userErrors...), nil
+		}
+		metafields := make([]any, 0, len(inputs))
+		for _, in := range inputs {
+			input := in.(map[string]any)
+			ownerID, _ := input["ownerId"].(string)
+			namespace, _ := input["namespace"].(string)
+			key, _ := input["key"].(string)
+			value, _ := input["value"].(string)
+			valueType, _ := input["type"].(string)
+			metafields = append(metafields, s.setMetafield(ownerID, namespace, key, value, valueType))
+		}
+		return payload("metafields", metafields), nil
+	},
+	"metafieldsDelete": func(s *Server, args map[string]any) (any, error) {
+		inputs, _ := args["metafields"].([]any)
+		deleted := make([]any, 0, len(inputs))
+		for _, in := range inputs {
+			input, _ := in.(map[string]any)
+			ownerID, _ := input["ownerId"].(string)
+			namespace, _ := input["namespace"].(string)
+			key, _ := input["key"].(string)
+			mf := s.findMetafield(ownerID, namespace, key)
+			if mf == nil {
+				deleted = append(deleted, nil)
+				continue
+			}
+			s.deleteMetafield(mf)
+			deleted = append(deleted, map[string]any{"ownerId": ownerID, "namespace": namespace, "key": key})
+		}
+		return payload("deletedMetafields", deleted), nil
+	},
+	"metafieldDelete": func(s *Server, args map[string]any) (any, error) {
+		input, _ := args["input"].(map[string]any)
+		mf := s.lookup(input["id"], "Metafield")
+		if mf == nil {
+			return payload("deletedId", nil, UserError{Field: []string{"id"}, Message: "Metafield does not exist"}), nil
+		}
+		s.deleteMetafield(mf)
+		return payload("deletedId", mf["id"]), nil
+	},
+	"collectionCreate": func(s *Server, args map[string]any) (any, error) {
+		input, _ := args["input"].(map[string]any)
+		if title, _ := input["title"].(string); title == "" {
+			return payload("collection", nil, UserError{Field: []string{"title"}, Message: "Title can't be blank"}), nil
+		}
+		fields := copyMap(input)
+		delete(fields, "products")
+		if _, ok := fields["handle"]; !ok {
+			fields["handle"] = handleize(fields["title"].(string))
+		}
+		fields["products"] = []any{}
+		id := s.put("Collection", fields)
+		if products, ok := input["products"].([]any); ok {
+			for _, p := range products {
+				s.appendRef(id, "products", p.(string))
+				s.appendRef(p.(string), "collections", id)
+			}
+		}
+		return payload("collection", id), nil
+	},
+	"collectionUpdate": func(s *Server, args map[string]any) (any, error) {
+		input, _ := args["input"].(map[string]any)
+		obj := s.lookup(input["id"], "Collection")
+		if obj == nil {
+			return payload("collection", nil, UserError{Field: []string{"id"}, Message: "Collection does not exist"}), nil
+		}
+		for k, v := range input {
+			if k != "id" && k != "products" {
+				obj[k] = v
+			}
+		}
+		obj["updatedAt"] = time.Now().UTC().Format(time.RFC3339)
+		return payload("collection", obj), nil
+	},
+	"appSubscriptionCreate": func(s *Server, args map[string]any) (any, error) {
+		items, _ := args["lineItems"].([]any)
+		if userErrors := validateLineItems(items); len(userErrors) > 0 {
+			return payload("appSubscription", nil, userErrors...), nil
+		}
+		lineItems := make([]any, 0, len(items))
+		for _, item := range items {
+			input, _ := item.(map[string]any)
+			plan, _ := input["plan"].(map[string]any)
+			s.nextID++
+			lineItems = append(lineItems, map[string]any{
+				"id":   fmt.Sprintf("gid://shopify/AppSubscriptionLineItem/%d?v=1&index=%d", s.nextID, len(lineItems)),
+				"plan": map[string]any{"pricingDetails": pricingDetails(plan)},
+			})
+		}
+		trialDays, _ := toInt(args["trialDays"])
+		test, _ := args["test"].(bool)
+		id := s.put("AppSubscription", map[string]any{
+			"name":             args["name"],
+			"returnUrl":        args["returnUrl"],
+			"status":           "PENDING",
+			"test":             test,
+			"trialDays":        trialDays,
+			"currentPeriodEnd": nil,
+			"lineItems":        lineItems,
+		})
+		out := payload("appSubscription", id)
+		out["confirmationUrl"] = fmt.Sprintf("%s/admin/charges/confirm_recurring_application_charge?id=%s", s.URL, legacyID(id))
+		return out, nil
+	},
+	"appSubscriptionCancel": func(s *Server, args map[string]any) (any, error) {
+		obj := s.lookup(args["id"], "AppSubscription")
+		if obj == nil {
+			return payload("appSubscription", nil, UserError{Field: []string{"id"}, Message: "Couldn't find RecurringApplicationCharge"}), nil
+		}
+		obj["status"] = "CANCELLED"
+		return payload("appSubscription", obj), nil
+	},
+}
+
+// computedFields resolves the object fields which depend on their arguments or on other objects.
+var computedFields = map[string]computedResolver{
+	"metafield": func(s *Server, obj map[string]any, args map[string]any) any {
+		id, _ := obj["id"].(string)
+		namespace, _ := args["namespace"].(string)
+		key, _ := args["key"].(string)
+		return nilIfMissing(s.findMetafield(id, namespace, key))
+	},
+	"activeSubscriptions": func(s *Server, obj map[string]any, _ map[string]any) any {
+		if obj["__typename"] != "AppInstallation" {
+			return obj["activeSubscriptions"]
+		}
+		active := make([]any, 0)
+		for _, sub := range s.list("AppSubscription") {
+			if sub.(map[string]any)["status"] == "ACTIVE" {
+				active = append(active, sub)
+			}
+		}
+		return active
+	},
+}
+
+func init() {
+	for field, typename := range objectFields {
+		queries[field] = func(s *Server, args map[string]any) (any, error) {
+			return nilIfMissing(s.lookup(args["id"], typename)), nil
+		}
+	}
+	for field, typename := range connectionFields {
+		queries[field] = func(s *Server, _ map[string]any) (any, error) {
+			return s.list(typename), nil
+		}
+	}
+}
+
+// resolvable reports whether the root field has a handler or a built-in resolver, the caller must hold s.mu.
+func (s *Server) resolvable(op ast.Operation, field string) bool {
+	if field == "__typename" {
+		return true
+	}
+	if _, ok := s.handlers[field]; ok {
+		return true
+	}
+	if op == ast.Mutation {
+		_, ok := mutations[field]
+		return ok
+	}
+	_, ok := queries[field]
+	return ok
+}
+
+// createWebhookSubscription creates a webhook subscription delivered to the address held by the addressField input.
+func (s *Server) createWebhookSubscription(args map[string]any, addressField string) (any, error) {
+	topic, _ := args["topic"].(string)
+	input, _ := args["webhookSubscription"].(map[string]any)
+	address, _ := input[addressField].(string)
+	if address == "" {
+		return payload("webhookSubscription", nil, UserError{
+			Field:   []string{"webhookSubscription", addressField},
+			Message: "Address can't be blank",
+		}), nil
+	}
+	obj := webhookSubscription(topic, input)
+	for _, existing := range s.list("WebhookSubscription") {
+		if wh := existing.(map[string]any); wh["topic"] == topic && wh["callbackUrl"] == obj["callbackUrl"] {
+			return payload("webhookSubscription", nil, UserError{
+				Field:   []string{"webhookSubscription", addressField},
+				Message: "Address for this topic has already been taken",
+			}), nil
+		}
+	}
+	id := s.put("WebhookSubscription", obj)
+	return payload("webhookSubscription", id), nil
+}
+
+// updateWebhookSubscription updates the fields of a webhook subscription, including its endpoint, from its input.
+func (s *Server) updateWebhookSubscription(args map[string]any) (any, error) {
+	obj := s.lookup(args["id"], "WebhookSubscription")
+	if obj == nil {
+		return payload("webhookSubscription", nil, UserError{Field: []string{"id"}, Message: "Webhook subscription does not exist"}), nil
+	}
+	input, _ := args["webhookSubscription"].(map[string]any)
+	for k, v := range input {
+		if v == nil {
+			continue
+		}
+		obj[k] = v
+	}
+	setWebhookEndpoint(obj, input)
+	obj["updatedAt"] = time.Now().UTC().Format(time.RFC3339)
+	return payload("webhookSubscription", obj), nil
+}
+
+// webhookSubscription builds the fields of a webhook subscription of the topic from its input.
+func webhookSubscription(topic string, input map[string]any) map[string]any {
+	format, _ := input["format"].(string)
+	if format == "" {
+		format = "JSON"
+	}
+	obj := map[string]any{
+		"topic":                      topic,
+		"format":                     format,
+		"includeFields":              emptyIfNil(input["includeFields"]),
+		"metafieldNamespaces":        emptyIfNil(input["metafieldNamespaces"]),
+		"privateMetafieldNamespaces": []any{},
+		"apiVersion": map[string]any{
+			"displayName": "Unstable",
+			"handle":      "unstable",
+			"supported":   true,
+		},
+	}
+	if filter, ok := input["filter"].(string); ok {
+		obj["filter"] = filter
+	}
+	setWebhookEndpoint(obj, input)
+	return obj
+}
+
+// setWebhookEndpoint sets the endpoint of a webhook subscription to the address held by its input, if any.
+func setWebhookEndpoint(obj map[string]any, input map[string]any) {
+	if arn, ok := input["arn"].(string); ok {
+		obj["callbackUrl"] = arn
+		obj["endpoint"] = map[string]any{"__typename": "WebhookEventBridgeEndpoint", "arn": arn}
+	} else if pubSubTopic, ok := input["pubSubTopic"].(string); ok {
+		pubSubProject, _ := input["pubSubProject"].(string)
+		obj["callbackUrl"] = "pubsub://" + pubSubProject + ":" + pubSubTopic
+		obj["endpoint"] = map[string]any{"__typename": "WebhookPubSubEndpoint", "pubSubProject": pubSubProject, "pubSubTopic": pubSubTopic}
+	} else if callbackURL, ok := input["callbackUrl"].(string); ok {
+		obj["callbackUrl"] = callbackURL
+		obj["endpoint"] = map[string]any{"__typename": "WebhookHttpEndpoint", "callbackUrl": callbackURL}
+	}
+}
+
+// validateLineItems checks the line items of an app subscription against the rules enforced by Shopify.
+func validateLineItems(items []any) []UserError {
+	var (
+		userErrors []UserError
+		annual     bool
+		usage      bool
+	)
+	for i, item := range items {
+		input, _ := item.(map[string]any)
+		plan, _ := input["plan"].(map[string]any)
+		if _, ok := plan["appUsagePricingDetails"].(map[string]any); ok {
+			usage = true
+		}
+		recurring, ok := plan["appRecurringPricingDetails"].(map[string]any)
+		if !ok {
+			continue
+		}
+		if recurring["interval"] == "ANNUAL" {
+			annual = true
+		}
+		discount, _ := recurring["discount"].(map[string]any)
+		value, _ := discount["value"].(map[string]any)
+		if percentage, ok := value["percentage"].(float64); ok && (percentage <= 0 || percentage >= 1) {
+			userErrors = append(userErrors, UserError{
+				Field:   []string{"lineItems", fmt.Sprint(i), "plan", "appRecurringPricingDetails", "discount", "value", "percentage"},
+				Message: "Percentage must be greater than 0 and less than 1",
+			})
+		}
+	}
+	if annual && usage {
+		userErrors = append(userErrors, UserError{
+			Field:   []string{"lineItems"},
+			Message: "Annual subscriptions can't have usage pricing",
+		})
+	}
+	return userErrors
+}
+
+// pricingDetails converts the plan input of an app subscription line item to its pricing details.
+func pricingDetails(plan map[string]any) map[string]any {
+	if usage, ok := plan["appUsagePricingDetails"].(map[string]any); ok {
+		out := copyMap(usage)
+		out["__typename"] = "AppUsagePricing"
+		out["balanceUsed"] = map[string]any{"amount": "0.0", "currencyCode": currencyCode(usage["cappedAmount"])}
+		if _, ok := out["interval"]; !ok {
+			out["interval"] = "EVERY_30_DAYS"
+		}
+		return out
+	}
+	recurring, _ := plan["appRecurringPricingDetails"].(map[string]any)
+	out := copyMap(recurring)
+	out["__typename"] = "AppRecurringPricing"
+	if _, ok := out["interval"]; !ok {
+		out["interval"] = "EVERY_30_DAYS"
+	}
+	out["discount"] = nil
+	return out
+}
+
+func currencyCode(money any) any {
+	if m, ok := money.(map[string]any); ok {
+		return m["currencyCode"]
+	}
+	return nil
+}
+
+func (s *Server) deleteMetafield(mf map[string]any) {
+	id := mf["id"].(string)
+	if owner, ok := mf["owner"].(string); ok {
+		s.removeRef(owner, "metafields", id)
+	}
+	s.remove(id)
+}
+
+// payload builds a mutation payload holding value in field, and the given user errors.
+func payload(field string, value any, userErrors ...UserError) map[string]any {
+	return map[string]any{
+		field:        value,
+		"userErrors": userErrorValues(userErrors),
+	}
+}
+
+// nilIfMissing turns a nil map into an untyped nil, which resolves to null.
+func nilIfMissing(obj map[string]any) any {
+	if obj == nil {
+		return nil
+	}
+	return obj
+}
+
+func emptyIfNil(v any) any {
+	if v == nil {
+		return []any{}
+	}
+	return v
+}
@@ -0,0 +1,280 @@
+// Package shopifytest provides an in-process fake of the Shopify Admin GraphQL API for tests.
+//
+// A Server keeps products, collections, variants, metafields, webhook subscriptions, app subscriptions
+// and automatic app discounts in memory and answers the queries and mutations sent by the services of the shopify package.
+// Bulk operations complete immediately and their JSONL result is served by the server itself,
+// as are the staged uploads of bulk mutation variables.
+// Throttling and user errors can be injected, and any root field can be overridden with Handle.
+//
+//	srv := shopifytest.NewServer()
+//	defer srv.Close()
+//	id := srv.AddProduct(map[string]any{"title": "Shirt"})
+//	product, err := srv.Client().Product.Get(ctx, id)
+package shopifytest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+
+	"github.com/gempages/go-shopify-graphql"
+	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/graphql"
+)
+
+const (
+	// Domain is the shop domain of the clients returned by Server.Client
+	Domain = "shopifytest.myshopify.com"
+	// AccessToken is the access token of the clients returned by Server.Client
+	AccessToken = "shpat_shopifytest"
+	// ShopID is the ID of the shop object, owner of shop metafields
+	ShopID = "gid://shopify/Shop/1"
+	// AppInstallationID is the ID of the object returned by currentAppInstallation
+	AppInstallationID = "gid://shopify/AppInstallation/1"
+)
+
+const (
+	maximumAvailable = 2000
+	restoreRate      = 100
+	requestedCost    = 1
+)
+
+// Resolver resolves a root field of a query or mutation from its arguments.
+// The returned value is made of maps, slices and scalars, or IDs of stored objects,
+// and is shaped by the selection set of the field. A returned *graphql.GraphQLError keeps its extensions.
+type Resolver func(args map[string]any) (any, error)
+
+// UserError is an error returned in the "userErrors" field of a mutation payload.
+type UserError struct {
+	Field   []string `json:"field,omitempty"`
+	Message string   `json:"message"`
+	Code    string   `json:"code,omitempty"`
+}
+
+// Request is a GraphQL request received by the server.
+type Request struct {
+	Query     string
+	Variables map[string]any
+}
+
+// Server is a fake Shopify Admin GraphQL API. Its zero value is not usable, create one with NewServer.
+type Server struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	objects    map[string]map[string]any
+	order      []string
+	nextID     int64
+	files      map[string][]byte
+	handlers   map[string]Resolver
+	userErrors map[string][][]UserError
+	throttle   int
+	// bulkFailures are the failures of the next bulk operations, see FailBulkOperation
+	bulkFailures []bulkFailure
+	requests     []Request
+	limiter      *graphql.CostLimiter
+}
+
+// NewServer starts and returns a new Server. The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		objects:    make(map[string]map[string]any),
+		nextID:     1000000000,
+		files:      make(map[string][]byte),
+		handlers:   make(map[string]Resolver),
+		userErrors: make(map[string][][]UserError),
+		limiter:    graphql.NewCostLimiter(),
+	}
+	s.put("Shop", map[string]any{
+		"id":              ShopID,
+		"name":            "Shopify Test",
+		"myshopifyDomain": Domain,
+	})
+	s.put("AppInstallation", map[string]any{
+		"id":           AppInstallationID,
+		"accessScopes": []any{},
+		"app": map[string]any{
+			"id":                     "gid://shopify/App/1",
+			"title":                  "Shopify Test App",
+			"embedded":               true,
+			"isPostPurchaseAppInUse": false,
+			"developerType":          "PARTNER",
+		},
+	})
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Client returns a client sending its requests to the server. The options are applied after
+// the ones pointing the client at the server, and may override them.
+func (s *Server) Client(opts ...graphqlclient.Option) *shopify.Client {
+	opts = append([]graphqlclient.Option{
+		graphqlclient.WithToken(AccessToken),
+		graphqlclient.WithBaseURL(s.URL),
+		graphqlclient.WithCostLimiter(s.limiter),
+	}, opts...)
+	return shopify.NewClientWithOpts(Domain, opts...)
+}
+
+// Handle overrides the root query or mutation field with the given resolver.
+func (s *Server) Handle(field string, resolver Resolver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[field] = resolver
+}
+
+// FailMutation makes the next call to the mutation return the given user errors, without any other effect.
+// Every call queues one failure.
+func (s *Server) FailMutation(field string, errs ...UserError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.userErrors[field] = append(s.userErrors[field], errs)
+}
+
+// FailBulkOperation makes the next bulk operation fail with errorCode, e.g. "TIMEOUT", after producing its first
+// objects lines, served from its partialDataUrl. Every call queues one failure.
+func (s *Server) FailBulkOperation(errorCode string, objects int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bulkFailures = append(s.bulkFailures, bulkFailure{errorCode: errorCode, objects: objects})
+}
+
+// Throttle makes the next n requests fail with a THROTTLED error and an empty bucket.
+func (s *Server) Throttle(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttle = n
+}
+
+// Requests returns the GraphQL requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s.serveFile(w, r)
+		return
+	}
+	if r.Method == http.MethodPost && strings.TrimPrefix(r.URL.Path, "/") == stagedUploadPrefix {
+		s.serveStagedUpload(w, r)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/graphql.json") {
+		http.NotFound(w, r)
+		return
+	}
+	if !authenticated(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]any{
+			"errors": "[API] Invalid API key or access token (unrecognized login or wrong password)",
+		})
+		return
+	}
+
+	var in struct {
+		Query         string         `json:"query"`
+		Variables     map[string]any `json:"variables"`
+		OperationName string         `json:"operationName"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"errors": err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Query: in.Query, Variables: in.Variables})
+	throttled := s.throttle > 0
+	if throttled {
+		s.throttle--
+	}
+	s.mu.Unlock()
+
+	if throttled {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"errors": []*graphql.GraphQLError{{
+				Message: "Throttled",
+				Extensions: map[string]any{
+					"code":          graphql.ErrorCodeThrottled,
+					"documentation": "https://shopify.dev/api/usage/rate-limits",
+				},
+			}},
+			"extensions": costExtensions(0, 0),
+		})
+		return
+	}
+
+	doc, gerr := parser.ParseQuery(&ast.Source{Input: in.Query})
+	if gerr != nil {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"errors": []*graphql.GraphQLError{{Message: gerr.Error()}},
+		})
+		return
+	}
+	op := doc.Operations.ForName(in.OperationName)
+	if op == nil {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"errors": []*graphql.GraphQLError{{Message: "No operation named \"" + in.OperationName + "\""}},
+		})
+		return
+	}
+
+	e := &executor{s: s, doc: doc, vars: in.Variables}
+	data, errs := e.execute(op)
+	out := map[string]any{
+		"extensions": costExtensions(requestedCost, maximumAvailable-requestedCost),
+	}
+	if data != nil {
+		out["data"] = data
+	}
+	if len(errs) > 0 {
+		out["errors"] = errs
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data, ok := s.files[r.URL.Path]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jsonl")
+	_, _ = w.Write(data)
+}
+
+func authenticated(r *http.Request) bool {
+	if r.Header.Get("X-Shopify-Access-Token") != "" || r.Header.Get("X-Shopify-Storefront-Access-Token") != "" {
+		return true
+	}
+	_, _, ok := r.BasicAuth()
+	return ok
+}
+
+func costExtensions(cost float64, available float64) map[string]any {
+	return map[string]any{
+		"cost": map[string]any{
+			"requestedQueryCost": cost,
+			"actualQueryCost":    cost,
+			"throttleStatus": map[string]any{
+				"maximumAvailable":   maximumAvailable,
+				"currentlyAvailable": available,
+				"restoreRate":        restoreRate,
+			},
+		},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,255 @@
+package shopifytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+
+	"github.com/gempages/go-shopify-graphql"
+	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/graphql"
+)
+
+func TestProducts(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	productID := srv.AddProduct(map[string]any{"title": "Blue Shirt"})
+	for i := 0; i < 3; i++ {
+		srv.AddVariant(productID, map[string]any{"title": fmt.Sprintf("Size %d", i)})
+	}
+	srv.AddProduct(map[string]any{"title": "Red Shirt"})
+	srv.AddProduct(map[string]any{"title": "Green Shirt"})
+
+	product, err := client.Product.Get(ctx, productID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if product.ID != productID || product.Title != "Blue Shirt" || product.Handle != "blue-shirt" {
+		t.Errorf("expected (%v), got (%+v)", productID, product)
+	}
+	if product.Variants == nil || len(product.Variants.Edges) != 3 {
+		t.Errorf("expected (%v) variants, got (%+v)", 3, product.Variants)
+	}
+
+	_, err = client.Product.Get(ctx, "gid://shopify/Product/0")
+	if !shopify.IsNotExistError(err) {
+		t.Errorf("expected not exist error, got (%v)", err)
+	}
+
+	page, err := client.Product.ListWithFields(ctx, &shopify.ListProductArgs{Fields: "id title", First: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page.Edges) != 2 || !page.PageInfo.HasNextPage {
+		t.Errorf("expected (%v) products and a next page, got (%v, %v)", 2, len(page.Edges), page.PageInfo.HasNextPage)
+	}
+}
+
+func TestBulkQuery(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	var productIDs []string
+	for i := 0; i < 3; i++ {
+		productIDs = append(productIDs, srv.AddProduct(map[string]any{"title": fmt.Sprintf("Product %d", i)}))
+	}
+	collectionID := srv.AddCollection(map[string]any{"title": "Summer"}, productIDs[:2]...)
+
+	products, err := client.Product.List(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(products) != 3 {
+		t.Errorf("expected (%v) products, got (%v)", 3, len(products))
+	}
+
+	query := fmt.Sprintf("id:%s OR %s", legacyID(productIDs[0]), legacyID(productIDs[2]))
+	products, err = client.Product.List(ctx, shopify.WithQuery(query))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(products) != 2 || products[0].ID != productIDs[0] || products[1].ID != productIDs[2] {
+		t.Errorf("expected products (%v, %v), got (%+v)", productIDs[0], productIDs[2], products)
+	}
+
+	collections, err := client.Collection.List(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(collections) != 1 || collections[0].ID != collectionID {
+		t.Fatalf("expected collection (%v), got (%+v)", collectionID, collections)
+	}
+	if collections[0].Products == nil || len(collections[0].Products.Edges) != 2 {
+		t.Errorf("expected (%v) collection products, got (%+v)", 2, collections[0].Products)
+	}
+
+	op, err := client.BulkOperation.GetCurrentBulkQuery(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if op.Status != model.BulkOperationStatusCompleted || op.ObjectCount != "3" || op.RootObjectCount != "1" {
+		t.Errorf("expected a completed operation of 3 objects, got (%+v)", op)
+	}
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	srv.AddWebhookSubscription("ORDERS_CREATE", "https://example.com/orders")
+	callbackURL := "https://example.com/products"
+	input := model.WebhookSubscriptionInput{CallbackURL: &callbackURL}
+	webhook, err := client.Webhook.NewWebhookSubscription(ctx, model.WebhookSubscriptionTopicProductsUpdate, input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	endpoint, ok := webhook.Endpoint.(*model.WebhookHTTPEndpoint)
+	if !ok || endpoint.CallbackURL != callbackURL {
+		t.Errorf("expected (%v), got (%+v)", callbackURL, webhook.Endpoint)
+	}
+
+	_, err = client.Webhook.NewWebhookSubscription(ctx, model.WebhookSubscriptionTopicProductsUpdate, input)
+	if !shopify.IsAddressTakenError(err) {
+		t.Errorf("expected address taken error, got (%v)", err)
+	}
+
+	webhooks, err := client.Webhook.ListWebhookSubscriptions(ctx, []model.WebhookSubscriptionTopic{model.WebhookSubscriptionTopicProductsUpdate})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(webhooks) != 1 || webhooks[0].ID != webhook.ID {
+		t.Errorf("expected (%v), got (%+v)", webhook.ID, webhooks)
+	}
+
+	deletedID, err := client.Webhook.DeleteWebhook(ctx, webhook.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deletedID == nil || *deletedID != webhook.ID || srv.Object(webhook.ID) != nil {
+		t.Errorf("expected (%v) to be deleted, got (%v)", webhook.ID, deletedID)
+	}
+}
+
+func TestMetafields(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	namespace, valueType := "app", "single_line_text_field"
+	srv.AddMetafield(ShopID, namespace, "plan", "basic", valueType)
+	metafields, err := client.Metafield.CreateBulk(ctx, []model.MetafieldsSetInput{
+		{OwnerID: ShopID, Namespace: &namespace, Key: "plan", Value: "pro", Type: &valueType},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(metafields) != 1 || metafields[0].Value != "pro" {
+		t.Errorf("expected updated metafield, got (%+v)", metafields)
+	}
+
+	metafield, err := client.Metafield.GetShopMetafieldByKey(ctx, "app", "plan")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if metafield.Value != "pro" {
+		t.Errorf("expected (%v), got (%v)", "pro", metafield.Value)
+	}
+	if n := len(srv.Objects("Metafield")); n != 1 {
+		t.Errorf("expected (%v) metafield, got (%v)", 1, n)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+	srv.AddProduct(map[string]any{"title": "Shirt"})
+	args := &shopify.ListProductArgs{First: 1}
+
+	srv.Throttle(1)
+	_, err := srv.Client().Product.ListWithFields(ctx, args)
+	if !shopify.IsRateLimitError(err) {
+		t.Errorf("expected rate limit error, got (%v)", err)
+	}
+
+	srv.Throttle(1)
+	client := srv.Client(graphqlclient.WithRetryPolicy(graphql.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	_, err = client.Product.ListWithFields(ctx, args)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFailMutationAndHandle(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	client := srv.Client()
+	ctx := context.Background()
+
+	srv.FailMutation("metafieldsSet", UserError{Field: []string{"metafields"}, Message: "Value is invalid"})
+	_, err := client.Metafield.CreateBulk(ctx, []model.MetafieldsSetInput{{OwnerID: ShopID, Key: "plan", Value: "pro"}})
+	if err == nil {
+		t.Errorf("expected user error")
+	}
+	if n := len(srv.Objects("Metafield")); n != 0 {
+		t.Errorf("expected no metafield, got (%v)", n)
+	}
+
+	var v any
+	err = client.GraphQLClient().QueryString(ctx, "{ unknown { id } }", nil, &v)
+	if !graphql.HasErrorCode(err, "undefinedField") {
+		t.Errorf("expected undefined field error, got (%v)", err)
+	}
+
+	srv.Handle("product", func(args map[string]any) (any, error) {
+		return nil, &graphql.GraphQLError{Message: "Access denied", Extensions: map[string]any{"code": graphql.ErrorCodeAccessDenied}}
+	})
+	_, err = client.Product.Get(ctx, "gid://shopify/Product/1")
+	if !errors.Is(err, graphql.ErrAccessDenied) {
+		t.Errorf("expected (%v), got (%v)", graphql.ErrAccessDenied, err)
+	}
+	if n := len(srv.Requests()); n != 3 {
+		t.Errorf("expected (%v) requests, got (%v)", 3, n)
+	}
+}
+
+func TestVariableTypes(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	var m struct {
+		BulkOperationRunMutation struct {
+			BulkOperation struct {
+				ID string `json:"id"`
+			} `json:"bulkOperation"`
+		} `graphql:"bulkOperationRunMutation(mutation: $mutation, stagedUploadPath: $stagedUploadPath)" json:"bulkOperationRunMutation"`
+	}
+	// Plain strings are declared as ID!, which the String! arguments don't accept
+	vars := map[string]interface{}{
+		"mutation":         "mutation call($input: ProductInput!) { productCreate(input: $input) { product { id } } }",
+		"stagedUploadPath": "tmp/bulk_op_vars",
+	}
+	err := srv.Client().GraphQLClient().Mutate(ctx, &m, vars)
+	if !graphql.HasErrorCode(err, "variableMismatch") {
+		t.Errorf("expected variable mismatch error, got (%v)", err)
+	}
+	if n := len(srv.Requests()); n != 1 {
+		t.Errorf("expected (%v) requests, got (%v)", 1, n)
+	}
+	if n := len(srv.Objects("BulkOperation")); n != 0 {
+		t.Errorf("expected no bulk operation, got (%v)", n)
+	}
+}
@@ -0,0 +1,351 @@
+package shopifytest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Add stores an object of the given GraphQL type and returns its global ID.
+// Fields referring to other objects hold their IDs, or slices of IDs for connections.
+// The id, legacyResourceId, createdAt and updatedAt fields are filled in when missing.
+func (s *Server) Add(typename string, fields map[string]any) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(typename, fields)
+}
+
+// AddProduct stores a product and returns its ID. The handle defaults to the title in lower case and the status to ACTIVE.
+func (s *Server) AddProduct(fields map[string]any) string {
+	fields = copyMap(fields)
+	if _, ok := fields["handle"]; !ok {
+		title, _ := fields["title"].(string)
+		fields["handle"] = handleize(title)
+	}
+	if _, ok := fields["status"]; !ok {
+		fields["status"] = "ACTIVE"
+	}
+	return s.Add("Product", fields)
+}
+
+// AddVariant stores a variant of the product and returns its ID.
+func (s *Server) AddVariant(productID string, fields map[string]any) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields = copyMap(fields)
+	fields["product"] = productID
+	id := s.put("ProductVariant", fields)
+	s.appendRef(productID, "variants", id)
+	return id
+}
+
+// AddCollection stores a collection containing the given products and returns its ID.
+func (s *Server) AddCollection(fields map[string]any, productIDs ...string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fields = copyMap(fields)
+	if _, ok := fields["handle"]; !ok {
+		title, _ := fields["title"].(string)
+		fields["handle"] = handleize(title)
+	}
+	fields["products"] = []any{}
+	id := s.put("Collection", fields)
+	for _, productID := range productIDs {
+		s.appendRef(id, "products", productID)
+		s.appendRef(productID, "collections", id)
+	}
+	return id
+}
+
+// AddMetafield stores a metafield of the owner, e.g. ShopID, and returns its ID.
+func (s *Server) AddMetafield(ownerID, namespace, key, value, valueType string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setMetafield(ownerID, namespace, key, value, valueType)
+}
+
+// AddWebhookSubscription stores a webhook subscription of the topic, e.g. "PRODUCTS_UPDATE", delivered to callbackURL
+// and returns its ID.
+func (s *Server) AddWebhookSubscription(topic, callbackURL string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put("WebhookSubscription", webhookSubscription(topic, map[string]any{"callbackUrl": callbackURL}))
+}
+
+// AddAppSubscription stores an app subscription and returns its ID. The status defaults to ACTIVE.
+func (s *Server) AddAppSubscription(fields map[string]any) string {
+	fields = copyMap(fields)
+	if _, ok := fields["status"]; !ok {
+		fields["status"] = "ACTIVE"
+	}
+	return s.Add("AppSubscription", fields)
+}
+
+// Update sets the given fields of a stored object. It reports whether the object exists.
+func (s *Server) Update(id string, fields map[string]any) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[id]
+	if !ok {
+		return false
+	}
+	for k, v := range fields {
+		obj[k] = v
+	}
+	return true
+}
+
+// Delete removes a stored object. References to it are left in place and resolve to null.
+func (s *Server) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+}
+
+// Object returns a copy of the stored object with the given ID, or nil when it doesn't exist.
+func (s *Server) Object(id string) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if obj, ok := s.objects[id]; ok {
+		return copyMap(obj)
+	}
+	return nil
+}
+
+// Objects returns copies of the stored objects of the given type, in insertion order.
+func (s *Server) Objects(typename string) []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []map[string]any
+	for _, obj := range s.list(typename) {
+		out = append(out, copyMap(obj.(map[string]any)))
+	}
+	return out
+}
+
+// put stores obj as typename, the caller must hold s.mu.
+func (s *Server) put(typename string, fields map[string]any) string {
+	obj := copyMap(fields)
+	id, _ := obj["id"].(string)
+	if id == "" {
+		s.nextID++
+		id = fmt.Sprintf("gid://shopify/%s/%d", typename, s.nextID)
+		obj["id"] = id
+	}
+	obj["__typename"] = typename
+	if _, ok := obj["legacyResourceId"]; !ok {
+		obj["legacyResourceId"] = legacyID(id)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, ok := obj["createdAt"]; !ok {
+		obj["createdAt"] = now
+	}
+	if _, ok := obj["updatedAt"]; !ok {
+		obj["updatedAt"] = now
+	}
+	if _, ok := s.objects[id]; !ok {
+		s.order = append(s.order, id)
+	}
+	s.objects[id] = obj
+	return id
+}
+
+func (s *Server) remove(id string) {
+	if _, ok := s.objects[id]; !ok {
+		return
+	}
+	delete(s.objects, id)
+	for i, oid := range s.order {
+		if oid == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// list returns the stored objects of the given type, the caller must hold s.mu.
+func (s *Server) list(typename string) []any {
+	var out []any
+	for _, id := range s.order {
+		if obj := s.objects[id]; obj["__typename"] == typename {
+			out = append(out, obj)
+		}
+	}
+	return out
+}
+
+// lookup returns the object with the given ID, restricted to typename unless it's empty.
+func (s *Server) lookup(id any, typename string) map[string]any {
+	sid, _ := id.(string)
+	obj, ok := s.objects[sid]
+	if !ok || (typename != "" && obj["__typename"] != typename) {
+		return nil
+	}
+	return obj
+}
+
+func (s *Server) appendRef(id string, field string, ref string) {
+	obj, ok := s.objects[id]
+	if !ok {
+		return
+	}
+	refs, _ := obj[field].([]any)
+	obj[field] = append(refs, ref)
+}
+
+func (s *Server) removeRef(id string, field string, ref string) {
+	obj, ok := s.objects[id]
+	if !ok {
+		return
+	}
+	refs, _ := obj[field].([]any)
+	for i, r := range refs {
+		if r == ref {
+			obj[field] = append(refs[:i:i], refs[i+1:]...)
+			return
+		}
+	}
+}
+
+// findMetafield returns the metafield of the owner with the given namespace and key.
+func (s *Server) findMetafield(ownerID string, namespace string, key string) map[string]any {
+	owner, ok := s.objects[ownerID]
+	if !ok {
+		return nil
+	}
+	refs, _ := owner["metafields"].([]any)
+	for _, ref := range refs {
+		mf := s.lookup(ref, "Metafield")
+		if mf != nil && mf["namespace"] == namespace && mf["key"] == key {
+			return mf
+		}
+	}
+	return nil
+}
+
+// setMetafield creates or updates the metafield of the owner and returns its ID.
+func (s *Server) setMetafield(ownerID, namespace, key, value, valueType string) string {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if mf := s.findMetafield(ownerID, namespace, key); mf != nil {
+		mf["value"] = value
+		if valueType != "" {
+			mf["type"] = valueType
+		}
+		mf["updatedAt"] = now
+		return mf["id"].(string)
+	}
+	ownerType := ""
+	if owner, ok := s.objects[ownerID]; ok {
+		ownerType = screamingSnake(owner["__typename"].(string))
+	}
+	id := s.put("Metafield", map[string]any{
+		"namespace":   namespace,
+		"key":         key,
+		"value":       value,
+		"type":        valueType,
+		"ownerType":   ownerType,
+		"owner":       ownerID,
+		"description": nil,
+	})
+	s.appendRef(ownerID, "metafields", id)
+	return id
+}
+
+// matchesSearch reports whether obj matches a Shopify search query.
+// Only a subset of the syntax is supported: key:value terms and bare words joined by spaces, AND or OR.
+// A bare word following an OR term uses the same key, so "id:1 OR 2" matches both IDs,
+// otherwise it's matched against the title.
+func matchesSearch(obj map[string]any, query string) bool {
+	var key string
+	for _, clause := range strings.Split(query, " OR ") {
+		matched := true
+		for _, term := range strings.Fields(clause) {
+			if term == "AND" {
+				continue
+			}
+			value := term
+			if k, v, ok := strings.Cut(term, ":"); ok {
+				key, value = k, v
+			}
+			if !matchesTerm(obj, key, strings.Trim(value, `"'`)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesTerm(obj map[string]any, key string, value string) bool {
+	switch {
+	case key == "":
+		title, _ := obj["title"].(string)
+		return strings.Contains(strings.ToLower(title), strings.ToLower(value))
+	case key == "id":
+		id, _ := obj["id"].(string)
+		return id == value || legacyID(id) == value
+	case strings.HasSuffix(key, "_id"):
+		ref, _ := obj[camelCase(strings.TrimSuffix(key, "_id"))].(string)
+		return ref == value || legacyID(ref) == value
+	}
+	field, ok := obj[camelCase(key)]
+	if !ok {
+		return false
+	}
+	values, ok := field.([]any)
+	if !ok {
+		values = []any{field}
+	}
+	for _, v := range values {
+		s := strings.ToLower(fmt.Sprint(v))
+		want := strings.ToLower(value)
+		if prefix, ok := strings.CutSuffix(want, "*"); ok && strings.HasPrefix(s, prefix) || s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyID returns the numeric part of a global ID.
+func legacyID(id string) string {
+	id, _, _ = strings.Cut(id, "?")
+	return id[strings.LastIndex(id, "/")+1:]
+}
+
+func handleize(title string) string {
+	return strings.Join(strings.Fields(strings.ToLower(title)), "-")
+}
+
+// camelCase converts a search key such as product_type to the name of its field.
+func camelCase(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// screamingSnake converts a type name such as ProductVariant to PRODUCT_VARIANT.
+func screamingSnake(typename string) string {
+	var b strings.Builder
+	for i, r := range typename {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToUpper(b.String())
+}
+
+func copyMap(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
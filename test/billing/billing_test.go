@@ -2,7 +2,6 @@ package billing_test
 
 import (
 	"context"
-	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/gempages/go-shopify-graphql-model/graph/model"
@@ -11,25 +10,31 @@ import (
 	"github.com/shopspring/decimal"
 
 	"github.com/gempages/go-shopify-graphql"
-	shopifyGraph "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
 )
 
 var _ = Describe("BillingService", func() {
 	var (
 		ctx           context.Context
 		shopifyClient *shopify.Client
-		domain        string
-		token         string
+		server        *shopifytest.Server
 	)
 
 	BeforeEach(func() {
 		ctx = context.Background()
-		domain = os.Getenv("SHOPIFY_SHOP_DOMAIN")
-		token = os.Getenv("SHOPIFY_API_TOKEN")
-		opts := []shopifyGraph.Option{
-			shopifyGraph.WithToken(token),
-		}
-		shopifyClient = shopify.NewClientWithOpts(domain, opts...)
+		server = shopifytest.NewServer()
+		server.AddAppSubscription(map[string]any{
+			"name":      "Basic",
+			"returnUrl": "https://return.url/",
+			"test":      true,
+			"trialDays": 0,
+			"lineItems": []any{},
+		})
+		shopifyClient = server.Client()
+	})
+
+	AfterEach(func() {
+		server.Close()
 	})
 
 	Describe("AppSubscriptionCreate", Serial, func() {
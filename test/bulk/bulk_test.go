@@ -0,0 +1,505 @@
+package bulk_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/gempages/go-shopify-graphql"
+	"github.com/gempages/go-shopify-graphql/graphql"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
+	"github.com/gempages/go-shopify-graphql/webhook"
+)
+
+const metafieldsSetMutation = `mutation call($metafields: [MetafieldsSetInput!]!) {
+	metafieldsSet(metafields: $metafields) {
+		metafields { id key value }
+		userErrors { field message code }
+	}
+}`
+
+const webhookSecret = "hush"
+
+func newWebhookRequest(topic, body string) *http.Request {
+	mac := hmac.New(sha256.New, []byte(webhookSecret))
+	mac.Write([]byte(body))
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+	req.Header.Set(webhook.HeaderHmac, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	req.Header.Set(webhook.HeaderTopic, topic)
+	req.Header.Set(webhook.HeaderShopDomain, "example.myshopify.com")
+	return req
+}
+
+var _ = Describe("BulkOperationService", func() {
+	var (
+		ctx           context.Context
+		shopifyClient *shopify.Client
+		server        *shopifytest.Server
+		productIDs    []string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = shopifytest.NewServer()
+		productIDs = nil
+		for _, title := range []string{"Shirt", "Pants", "Socks"} {
+			productIDs = append(productIDs, server.AddProduct(map[string]any{"title": title}))
+		}
+		shopifyClient = server.Client()
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("BulkMutate", func() {
+		It("runs the mutation once per line and matches the results to the lines", func() {
+			type vars struct {
+				Metafields []model.MetafieldsSetInput `json:"metafields"`
+			}
+			value := "cotton"
+			variables := []vars{
+				{Metafields: []model.MetafieldsSetInput{{OwnerID: productIDs[0], Key: "material", Value: value}}},
+				{Metafields: []model.MetafieldsSetInput{{OwnerID: "gid://shopify/Product/1", Key: "material", Value: value}}},
+				{Metafields: []model.MetafieldsSetInput{{OwnerID: productIDs[2], Key: "material", Value: value}}},
+			}
+			input, err := shopify.BulkMutationVariables(variables)
+			Expect(err).NotTo(HaveOccurred())
+
+			results, err := shopifyClient.BulkOperation.BulkMutate(ctx, metafieldsSetMutation, input)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+
+			for i, result := range results {
+				Expect(result.Line).To(Equal(i))
+			}
+			Expect(results[0].Err()).NotTo(HaveOccurred())
+			Expect(results[2].Err()).NotTo(HaveOccurred())
+			Expect(results[1].UserErrors).To(HaveLen(1))
+			Expect(results[1].UserErrors[0].Message).To(Equal("Owner does not exist."))
+			Expect(results[1].Err()).To(MatchError(ContainSubstring("Owner does not exist.")))
+			Expect(string(results[1].Variables)).To(ContainSubstring("gid://shopify/Product/1"))
+
+			var out struct {
+				MetafieldsSet struct {
+					Metafields []model.Metafield `json:"metafields"`
+				} `json:"metafieldsSet"`
+			}
+			Expect(results[2].Unmarshal(&out)).To(Succeed())
+			Expect(out.MetafieldsSet.Metafields).To(HaveLen(1))
+			Expect(out.MetafieldsSet.Metafields[0].Value).To(Equal(value))
+
+			var resources []any
+			for _, req := range server.Requests() {
+				if strings.Contains(req.Query, "stagedUploadsCreate") {
+					for _, in := range req.Variables["input"].([]any) {
+						resources = append(resources, in.(map[string]any)["resource"])
+					}
+				}
+			}
+			Expect(resources).To(Equal([]any{"BULK_MUTATION_VARIABLES"}))
+		})
+
+		It("returns no results without variables", func() {
+			results, err := shopifyClient.BulkOperation.BulkMutate(ctx, metafieldsSetMutation, strings.NewReader("\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(BeEmpty())
+		})
+
+		It("rejects invalid variables", func() {
+			_, err := shopifyClient.BulkOperation.BulkMutate(ctx, metafieldsSetMutation, strings.NewReader("{\"metafields\": \n"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects variables over the size limit before uploading them", func() {
+			line := `{"value":"` + strings.Repeat("a", 1<<20) + "\"}\n"
+			readers := make([]io.Reader, shopify.MaxBulkMutationVariablesSize>>20+1)
+			for i := range readers {
+				readers[i] = strings.NewReader(line)
+			}
+			_, err := shopifyClient.BulkOperation.BulkMutate(ctx, metafieldsSetMutation, io.MultiReader(readers...))
+			Expect(err).To(MatchError(shopify.ErrBulkMutationVariablesTooLarge))
+			for _, req := range server.Requests() {
+				Expect(req.Query).NotTo(ContainSubstring("stagedUploadsCreate"))
+			}
+		})
+
+		It("uploads the variables once the bulk mutation can run", func() {
+			coordinator := shopify.NewBulkCoordinator(1)
+			shopifyClient.SetBulkCoordinator(coordinator)
+			release, err := coordinator.Acquire(ctx, shopifytest.Domain, model.BulkOperationTypeMutation)
+			Expect(err).NotTo(HaveOccurred())
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				input := strings.NewReader(fmt.Sprintf(`{"metafields":[{"ownerId":%q,"key":"a","value":"1"}]}`, productIDs[0]))
+				_, err := shopifyClient.BulkOperation.BulkMutate(ctx, metafieldsSetMutation, input)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+			stagedUploads := func() int {
+				n := 0
+				for _, req := range server.Requests() {
+					if strings.Contains(req.Query, "stagedUploadsCreate") {
+						n++
+					}
+				}
+				return n
+			}
+			Consistently(stagedUploads, 100*time.Millisecond).Should(BeZero())
+			release()
+			Eventually(done).Should(BeClosed())
+			Expect(stagedUploads()).To(Equal(1))
+		})
+
+		It("joins the errors of a line", func() {
+			field := []string{"metafields", "0", "value"}
+			result := shopify.BulkMutationResult{
+				Line: 3,
+				Errors: []*graphql.GraphQLError{
+					{Message: "Throttled", Extensions: map[string]any{"code": graphql.ErrorCodeThrottled}},
+				},
+				UserErrors: []model.BulkMutationUserError{{Field: field, Message: "is too long"}, {Message: "Owner does not exist."}},
+			}
+			err := result.Err()
+			Expect(err).To(MatchError(graphql.ErrThrottled))
+			Expect(err.Error()).To(Equal("line 3: Throttled\nmetafields.0.value: is too long\nOwner does not exist."))
+		})
+
+		It("returns the results of the lines run before the operation failed", func() {
+			server.FailBulkOperation("INTERNAL_SERVER_ERROR", 1)
+			input := strings.NewReader(fmt.Sprintf(`{"metafields":[{"ownerId":%q,"key":"a","value":"1"}]}
+{"metafields":[{"ownerId":%q,"key":"a","value":"2"}]}
+`, productIDs[0], productIDs[1]))
+			results, err := shopifyClient.BulkOperation.BulkMutate(ctx, metafieldsSetMutation, input)
+
+			var opErr *shopify.BulkOperationError
+			Expect(errors.As(err, &opErr)).To(BeTrue())
+			Expect(opErr.Code()).To(Equal(model.BulkOperationErrorCodeInternalServerError))
+			Expect(opErr.Partial).To(BeTrue())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Err()).NotTo(HaveOccurred())
+			Expect(results[1].Err()).To(MatchError(ContainSubstring("wasn't run")))
+		})
+	})
+
+	Describe("BulkResult", func() {
+		const query = `{ products { edges { node { id title variants { edges { node { id title } } } } } } }`
+
+		BeforeEach(func() {
+			server.AddVariant(productIDs[0], map[string]any{"title": "S"})
+			server.AddVariant(productIDs[0], map[string]any{"title": "M"})
+		})
+
+		It("describes the completed operation", func() {
+			var lines int
+			res, err := shopifyClient.BulkOperation.BulkQueryStream(ctx, query, func(r io.Reader) error {
+				data, err := io.ReadAll(r)
+				lines = strings.Count(string(data), "\n")
+				return err
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(res.Completed()).To(BeTrue())
+			Expect(res.Err()).NotTo(HaveOccurred())
+			Expect(res.Status).To(Equal(model.BulkOperationStatusCompleted))
+			Expect(res.Type).To(Equal(model.BulkOperationTypeQuery))
+			Expect(res.ObjectCount).To(Equal(int64(5)))
+			Expect(res.RootObjectCount).To(Equal(int64(3)))
+			Expect(res.FileSize).To(BeNumerically(">", 0))
+			Expect(res.URL).NotTo(BeEmpty())
+			Expect(res.PartialDataURL).To(BeEmpty())
+			Expect(res.CompletedAt).NotTo(BeNil())
+			Expect(lines).To(Equal(5))
+		})
+
+		It("returns a BulkOperationError without the partial data by default", func() {
+			server.FailBulkOperation("TIMEOUT", 2)
+			var products []model.Product
+			err := shopifyClient.BulkOperation.BulkQuery(ctx, query, &products)
+
+			var opErr *shopify.BulkOperationError
+			Expect(errors.As(err, &opErr)).To(BeTrue())
+			Expect(opErr.Code()).To(Equal(model.BulkOperationErrorCodeTimeout))
+			Expect(opErr.Partial).To(BeFalse())
+			Expect(opErr.Result.Status).To(Equal(model.BulkOperationStatusFailed))
+			Expect(opErr.Result.PartialDataURL).NotTo(BeEmpty())
+			Expect(products).To(BeEmpty())
+
+			_, err = shopifyClient.BulkOperation.ShouldGetBulkQueryResultURL(ctx, &opErr.Result.ID)
+			Expect(errors.As(err, &opErr)).To(BeTrue())
+		})
+
+		It("decodes the partial data of a failed operation with WithPartialData", func() {
+			server.FailBulkOperation("ACCESS_DENIED", 3)
+			var products []*model.Product
+			err := shopifyClient.BulkOperation.BulkQuery(ctx, query, &products, shopify.WithPartialData())
+
+			var opErr *shopify.BulkOperationError
+			Expect(errors.As(err, &opErr)).To(BeTrue())
+			Expect(opErr.Code()).To(Equal(model.BulkOperationErrorCodeAccessDenied))
+			Expect(opErr.Partial).To(BeTrue())
+			Expect(opErr.Result.ObjectCount).To(Equal(int64(3)))
+			Expect(products).To(HaveLen(1))
+			Expect(products[0].Variants.Edges).To(HaveLen(2))
+		})
+
+		It("streams the partial data with BulkQueryEach", func() {
+			server.FailBulkOperation("TIMEOUT", 4)
+			var titles []string
+			err := shopify.BulkQueryEach(ctx, shopifyClient.BulkOperation, query, func(product model.Product) error {
+				titles = append(titles, product.Title)
+				return nil
+			}, shopify.WithPartialData())
+			Expect(err).To(BeAssignableToTypeOf(&shopify.BulkOperationError{}))
+			Expect(titles).To(Equal([]string{"Shirt", "Pants"}))
+		})
+	})
+
+	Describe("BulkQueryEach", func() {
+		const query = `{ products { edges { node { id title variants { edges { node { id title } } } } } } }`
+
+		BeforeEach(func() {
+			server.AddVariant(productIDs[0], map[string]any{"title": "S"})
+			server.AddVariant(productIDs[0], map[string]any{"title": "M"})
+			server.AddVariant(productIDs[2], map[string]any{"title": "One size"})
+		})
+
+		It("calls the function with each product and its variants", func() {
+			var products []*model.Product
+			err := shopify.BulkQueryEach(ctx, shopifyClient.BulkOperation, query, func(product *model.Product) error {
+				products = append(products, product)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(products).To(HaveLen(3))
+			Expect(products[0].ID).To(Equal(productIDs[0]))
+			Expect(products[0].Variants.Edges).To(HaveLen(2))
+			Expect(products[0].Variants.Edges[1].Node.Title).To(Equal("M"))
+			Expect(products[1].Variants).To(BeNil())
+			Expect(products[2].Variants.Edges).To(HaveLen(1))
+
+			var all []*model.Product
+			Expect(shopifyClient.BulkOperation.BulkQuery(ctx, query, &all)).To(Succeed())
+			Expect(products).To(Equal(all))
+		})
+
+		It("stops at the first error of the function", func() {
+			errStop := errors.New("stop")
+			calls := 0
+			err := shopify.BulkQueryEach(ctx, shopifyClient.BulkOperation, query, func(product model.Product) error {
+				calls++
+				return errStop
+			})
+			Expect(err).To(MatchError(errStop))
+			Expect(calls).To(Equal(1))
+		})
+	})
+
+	Describe("bulk operations by ID", func() {
+		const query = `{ products { edges { node { id title } } } }`
+
+		addRunningOperation := func() string {
+			return server.Add("BulkOperation", map[string]any{
+				"type":            "QUERY",
+				"status":          "RUNNING",
+				"query":           query,
+				"objectCount":     "0",
+				"rootObjectCount": "0",
+			})
+		}
+
+		It("gets, waits for and cancels an operation by its ID", func() {
+			id := addRunningOperation()
+			op, err := shopifyClient.BulkOperation.GetBulkOperation(ctx, id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(op.ID).To(Equal(id))
+			Expect(op.Status).To(Equal(model.BulkOperationStatusRunning))
+
+			Expect(shopifyClient.BulkOperation.CancelBulkOperation(ctx, id)).To(Succeed())
+			op, err = shopifyClient.BulkOperation.WaitForBulkOperation(ctx, id, time.Millisecond)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(op.Status).To(Equal(model.BulkOperationStatusCanceled))
+
+			_, err = shopifyClient.BulkOperation.GetBulkOperation(ctx, productIDs[0])
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("runs concurrent bulk queries of the same client", func() {
+			var wg sync.WaitGroup
+			errs := make([]error, 4)
+			results := make([][]model.Product, 4)
+			for i := range errs {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs[i] = shopifyClient.BulkOperation.BulkQuery(ctx, query, &results[i])
+				}(i)
+			}
+			wg.Wait()
+			for i := range errs {
+				Expect(errs[i]).NotTo(HaveOccurred())
+				Expect(results[i]).To(HaveLen(3))
+			}
+		})
+
+		It("waits for the operation started by another process to finish", func() {
+			id := addRunningOperation()
+			done := make(chan error, 1)
+			var products []model.Product
+			go func() {
+				done <- shopifyClient.BulkOperation.BulkQuery(ctx, query, &products)
+			}()
+			Consistently(done, 200*time.Millisecond).ShouldNot(Receive())
+
+			Expect(shopifyClient.BulkOperation.CancelBulkOperation(ctx, id)).To(Succeed())
+			Eventually(done, 5*time.Second).Should(Receive(BeNil()))
+			Expect(products).To(HaveLen(3))
+		})
+
+		It("gives up waiting once the context is done", func() {
+			addRunningOperation()
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			var products []model.Product
+			err := shopifyClient.BulkOperation.BulkQuery(ctx, query, &products)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("stops polling the current operation once the context is done", func() {
+			addRunningOperation()
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := shopifyClient.BulkOperation.WaitForCurrentBulkQuery(ctx, time.Hour)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		Context("with a BulkNotifier", func() {
+			var notifier *shopify.BulkNotifier
+
+			BeforeEach(func() {
+				notifier = shopify.NewBulkNotifier()
+			})
+
+			countNodeQueries := func() int {
+				count := 0
+				for _, req := range server.Requests() {
+					if strings.Contains(req.Query, "node(id: $id)") {
+						count++
+					}
+				}
+				return count
+			}
+
+			wait := func(id string, interval time.Duration) chan *model.BulkOperation {
+				done := make(chan *model.BulkOperation, 1)
+				go func() {
+					defer GinkgoRecover()
+					op, err := shopifyClient.BulkOperation.WaitForBulkOperation(ctx, id, interval)
+					Expect(err).NotTo(HaveOccurred())
+					done <- op
+				}()
+				return done
+			}
+
+			It("waits for the bulk_operations/finish webhook instead of polling", func() {
+				shopifyClient.SetBulkOperationNotifier(notifier, time.Hour)
+				id := addRunningOperation()
+				done := wait(id, time.Millisecond)
+				Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+				Expect(countNodeQueries()).To(Equal(1))
+
+				Expect(shopifyClient.BulkOperation.CancelBulkOperation(ctx, id)).To(Succeed())
+				Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+				receiver := webhook.NewReceiver(webhookSecret)
+				receiver.Handle(model.WebhookSubscriptionTopicBulkOperationsFinish, notifier)
+				body := fmt.Sprintf(`{"admin_graphql_api_id":"%s","status":"canceled","type":"query"}`, id)
+				rec := httptest.NewRecorder()
+				receiver.ServeHTTP(rec, newWebhookRequest("bulk_operations/finish", body))
+				Expect(rec.Code).To(Equal(http.StatusOK))
+
+				var op *model.BulkOperation
+				Eventually(done).Should(Receive(&op))
+				Expect(op.Status).To(Equal(model.BulkOperationStatusCanceled))
+			})
+
+			It("polls the operation once the notification timed out", func() {
+				shopifyClient.SetBulkOperationNotifier(notifier, 50*time.Millisecond)
+				id := addRunningOperation()
+				done := wait(id, 10*time.Millisecond)
+				Consistently(done, 200*time.Millisecond).ShouldNot(Receive())
+				Expect(countNodeQueries()).To(BeNumerically(">", 2))
+
+				Expect(shopifyClient.BulkOperation.CancelBulkOperation(ctx, id)).To(Succeed())
+				Eventually(done, 2*time.Second).Should(Receive())
+			})
+
+			It("runs bulk queries finishing before the subscription", func() {
+				shopifyClient.SetBulkOperationNotifier(notifier, time.Hour)
+				var products []model.Product
+				Expect(shopifyClient.BulkOperation.BulkQuery(ctx, query, &products)).To(Succeed())
+				Expect(products).To(HaveLen(3))
+			})
+		})
+	})
+
+	Describe("BulkCoordinator", func() {
+		const shop = "example.myshopify.com"
+
+		It("queues the operations over the limit of the shop", func() {
+			coordinator := shopify.NewBulkCoordinator(2)
+			release1, err := coordinator.Acquire(ctx, shop, model.BulkOperationTypeQuery)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = coordinator.Acquire(ctx, shop, model.BulkOperationTypeQuery)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(coordinator.Running(shop, model.BulkOperationTypeQuery)).To(Equal(2))
+
+			// Other shops and types have their own slots
+			release, err := coordinator.Acquire(ctx, "other.myshopify.com", model.BulkOperationTypeQuery)
+			Expect(err).NotTo(HaveOccurred())
+			release()
+			release, err = coordinator.Acquire(ctx, shop, model.BulkOperationTypeMutation)
+			Expect(err).NotTo(HaveOccurred())
+			release()
+
+			acquired := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				_, err := coordinator.Acquire(ctx, shop, model.BulkOperationTypeQuery)
+				Expect(err).NotTo(HaveOccurred())
+				close(acquired)
+			}()
+			Consistently(acquired, 100*time.Millisecond).ShouldNot(BeClosed())
+			release1()
+			release1()
+			Eventually(acquired).Should(BeClosed())
+			Expect(coordinator.Running(shop, model.BulkOperationTypeQuery)).To(Equal(2))
+		})
+
+		It("stops waiting once the context is done", func() {
+			coordinator := shopify.NewBulkCoordinator(1)
+			_, err := coordinator.Acquire(ctx, shop, model.BulkOperationTypeQuery)
+			Expect(err).NotTo(HaveOccurred())
+			ctx, cancel := context.WithCancel(ctx)
+			cancel()
+			_, err = coordinator.Acquire(ctx, shop, model.BulkOperationTypeQuery)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+})
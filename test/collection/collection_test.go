@@ -3,7 +3,6 @@ package collection_test
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/gempages/go-helper/errors"
@@ -11,7 +10,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/gempages/go-shopify-graphql"
-	shopifyGraph "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
 )
 
 const (
@@ -24,18 +23,18 @@ var _ = Describe("CollectionService", func() {
 	var (
 		ctx           context.Context
 		shopifyClient *shopify.Client
-		domain        string
-		token         string
+		server        *shopifytest.Server
 	)
 
 	BeforeEach(func() {
 		ctx = context.Background()
-		domain = os.Getenv("SHOPIFY_SHOP_DOMAIN")
-		token = os.Getenv("SHOPIFY_API_TOKEN")
-		opts := []shopifyGraph.Option{
-			shopifyGraph.WithToken(token),
-		}
-		shopifyClient = shopify.NewClientWithOpts(domain, opts...)
+		server = shopifytest.NewServer()
+		seedCollections(server)
+		shopifyClient = server.Client()
+	})
+
+	AfterEach(func() {
+		server.Close()
 	})
 
 	Describe("List", func() {
@@ -130,3 +129,14 @@ var _ = Describe("CollectionService", func() {
 		})
 	})
 })
+
+// seedCollections stores TotalCollectionCount collections, TestSingleQueryCollectionID holding all the products.
+func seedCollections(server *shopifytest.Server) {
+	productIDs := make([]string, 0, TestCollectionProductCount)
+	for i := 0; i < TestCollectionProductCount; i++ {
+		productIDs = append(productIDs, server.AddProduct(map[string]any{"title": fmt.Sprintf("Product %d", i)}))
+	}
+	server.AddCollection(map[string]any{"id": TestSingleQueryCollectionID, "title": "All products"}, productIDs...)
+	server.AddCollection(map[string]any{"id": "gid://shopify/Collection/453231673658", "title": "Featured"}, productIDs[:5]...)
+	server.AddCollection(map[string]any{"title": "Sale"}, productIDs[5:10]...)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"math/rand/v2"
-	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -13,39 +12,34 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/gempages/go-shopify-graphql"
-	shopifyGraph "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
 )
 
+// shopifyFunctionID is the ID of the discount function of the app, any ID is accepted by the fake server.
+const shopifyFunctionID = "01HQ3MJ4ZSM5EXAMPLE0FUNCTION"
+
 var _ = Describe("DiscountService", func() {
 	var (
 		ctx                context.Context
 		shopifyClient      *shopify.Client
-		domain             string
-		token              string
-		shopifyFunctionID  string
+		server             *shopifytest.Server
 		discountIDToDelete string
 	)
 
 	BeforeEach(func() {
 		ctx = context.Background()
-		domain = os.Getenv("SHOPIFY_SHOP_DOMAIN")
-		token = os.Getenv("SHOPIFY_API_TOKEN")
-		// Follow the step below to get function ID:
-		// - Go to Shopify Partners/Apps/All apps in https://partners.shopify.com/
-		// - Select an app, go to "Extensions"
-		// - Select a Shopify function in the list, you can find its ID in "Function details"
-		shopifyFunctionID = os.Getenv("SHOPIFY_DISCOUNT_FUNCTION_ID")
-		opts := []shopifyGraph.Option{
-			shopifyGraph.WithToken(token),
-		}
-		shopifyClient = shopify.NewClientWithOpts(domain, opts...)
+		server = shopifytest.NewServer()
+		shopifyClient = server.Client()
+		discountIDToDelete = ""
 	})
 
 	AfterEach(func() {
 		if discountIDToDelete != "" {
 			err := shopifyClient.Discount.AutomaticDelete(ctx, discountIDToDelete)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(server.Object(discountIDToDelete)).To(BeNil())
 		}
+		server.Close()
 	})
 
 	Describe("AutomaticAppCreate", func() {
@@ -53,7 +47,7 @@ var _ = Describe("DiscountService", func() {
 			It("creates an active discount", func() {
 				result, err := shopifyClient.Discount.AutomaticAppCreate(ctx, model.DiscountAutomaticAppInput{
 					Title:      aws.String(fmt.Sprintf("GemPages - Test Discount %d", rand.Int())),
-					FunctionID: &shopifyFunctionID,
+					FunctionID: aws.String(shopifyFunctionID),
 					CombinesWith: &model.DiscountCombinesWithInput{
 						ProductDiscounts: aws.Bool(true),
 					},
@@ -75,7 +69,7 @@ var _ = Describe("DiscountService", func() {
 			It("creates an active discount", func() {
 				result, err := shopifyClient.Discount.AutomaticAppCreate(ctx, model.DiscountAutomaticAppInput{
 					Title:      aws.String(fmt.Sprintf("GemPages - Test Discount %d", rand.Int())),
-					FunctionID: &shopifyFunctionID,
+					FunctionID: aws.String(shopifyFunctionID),
 					CombinesWith: &model.DiscountCombinesWithInput{
 						ProductDiscounts: aws.Bool(true),
 					},
@@ -99,11 +93,11 @@ var _ = Describe("DiscountService", func() {
 			It("marks discount as expired", func() {
 				discountCreated, err := shopifyClient.Discount.AutomaticAppCreate(ctx, model.DiscountAutomaticAppInput{
 					Title:      aws.String(fmt.Sprintf("GemPages - Test Discount %d", rand.Int())),
-					FunctionID: &shopifyFunctionID,
+					FunctionID: aws.String(shopifyFunctionID),
 					CombinesWith: &model.DiscountCombinesWithInput{
 						ProductDiscounts: aws.Bool(true),
 					},
-					StartsAt: aws.Time(time.Now()),
+					StartsAt: aws.Time(time.Now().Add(-time.Hour)),
 					EndsAt:   nil,
 				})
 				Expect(err).NotTo(HaveOccurred())
@@ -112,9 +106,6 @@ var _ = Describe("DiscountService", func() {
 
 				discountIDToDelete = discountCreated.DiscountID
 
-				// Sleep for a second to avoid startsAt equal endsAt when update discount.
-				time.Sleep(time.Second)
-
 				discountUpdated, err := shopifyClient.Discount.AutomaticAppUpdate(ctx, discountCreated.DiscountID, shopify.DiscountAutomaticAppInput{
 					DiscountAutomaticAppInput: model.DiscountAutomaticAppInput{
 						EndsAt: aws.Time(time.Now().Add(-time.Millisecond)),
@@ -132,12 +123,12 @@ var _ = Describe("DiscountService", func() {
 			It("marks discount as active", func() {
 				discountCreated, err := shopifyClient.Discount.AutomaticAppCreate(ctx, model.DiscountAutomaticAppInput{
 					Title:      aws.String(fmt.Sprintf("GemPages - Test Discount %d", rand.Int())),
-					FunctionID: &shopifyFunctionID,
+					FunctionID: aws.String(shopifyFunctionID),
 					CombinesWith: &model.DiscountCombinesWithInput{
 						ProductDiscounts: aws.Bool(true),
 					},
-					StartsAt: aws.Time(time.Now()),
-					EndsAt:   aws.Time(time.Now().Add(time.Millisecond)),
+					StartsAt: aws.Time(time.Now().Add(-time.Hour)),
+					EndsAt:   aws.Time(time.Now().Add(-time.Minute)),
 				})
 				Expect(err).NotTo(HaveOccurred())
 				Expect(discountCreated).NotTo(BeNil())
@@ -159,5 +150,84 @@ var _ = Describe("DiscountService", func() {
 				Expect(discountUpdated.Status.String()).To(Equal("ACTIVE"))
 			})
 		})
+
+		When("updates endsAt to time before startsAt", func() {
+			It("returns a validation error", func() {
+				discountCreated, err := shopifyClient.Discount.AutomaticAppCreate(ctx, model.DiscountAutomaticAppInput{
+					Title:      aws.String(fmt.Sprintf("GemPages - Test Discount %d", rand.Int())),
+					FunctionID: aws.String(shopifyFunctionID),
+					StartsAt:   aws.Time(time.Now()),
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				discountIDToDelete = discountCreated.DiscountID
+
+				_, err = shopifyClient.Discount.AutomaticAppUpdate(ctx, discountCreated.DiscountID, shopify.DiscountAutomaticAppInput{
+					DiscountAutomaticAppInput: model.DiscountAutomaticAppInput{
+						EndsAt: aws.Time(time.Now().Add(-time.Hour)),
+					},
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(shopify.IsValidationDiscountError(err)).To(BeTrue())
+			})
+		})
+
+		When("the discount doesn't exist", func() {
+			It("returns a validation error", func() {
+				_, err := shopifyClient.Discount.AutomaticAppUpdate(ctx, "gid://shopify/DiscountAutomaticNode/1", shopify.DiscountAutomaticAppInput{
+					ClearEndsAt: true,
+				})
+				Expect(err).To(HaveOccurred())
+				Expect(shopify.IsValidationDiscountError(err)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("AutomaticDeactivate and AutomaticActivate", func() {
+		It("expires then activates the discount", func() {
+			discountCreated, err := shopifyClient.Discount.AutomaticAppCreate(ctx, model.DiscountAutomaticAppInput{
+				Title:      aws.String(fmt.Sprintf("GemPages - Test Discount %d", rand.Int())),
+				FunctionID: aws.String(shopifyFunctionID),
+				StartsAt:   aws.Time(time.Now().Add(-time.Hour)),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			discountIDToDelete = discountCreated.DiscountID
+
+			node, err := shopifyClient.Discount.AutomaticDeactivate(ctx, discountCreated.DiscountID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.AutomaticDiscount).To(BeAssignableToTypeOf(&model.DiscountAutomaticApp{}))
+			Expect(node.AutomaticDiscount.(*model.DiscountAutomaticApp).Status.String()).To(Equal("EXPIRED"))
+
+			node, err = shopifyClient.Discount.AutomaticActivate(ctx, discountCreated.DiscountID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node.AutomaticDiscount).To(BeAssignableToTypeOf(&model.DiscountAutomaticApp{}))
+			discount := node.AutomaticDiscount.(*model.DiscountAutomaticApp)
+			Expect(discount.Status.String()).To(Equal("ACTIVE"))
+			Expect(discount.EndsAt).To(BeNil())
+		})
+	})
+
+	Describe("AutomaticNode", func() {
+		It("returns the discount with its metafield", func() {
+			discountCreated, err := shopifyClient.Discount.AutomaticAppCreate(ctx, model.DiscountAutomaticAppInput{
+				Title:      aws.String(fmt.Sprintf("GemPages - Test Discount %d", rand.Int())),
+				FunctionID: aws.String(shopifyFunctionID),
+				StartsAt:   aws.Time(time.Now().Add(time.Hour)),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			discountIDToDelete = discountCreated.DiscountID
+			server.AddMetafield(discountCreated.DiscountID, "gempages", "config", `{"percentage":10}`, "json")
+
+			node, err := shopifyClient.Discount.AutomaticNode(ctx, discountCreated.DiscountID, "config", "gempages")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(node).NotTo(BeNil())
+			Expect(node.ID).To(Equal(discountCreated.DiscountID))
+			Expect(node.Metafield).NotTo(BeNil())
+			Expect(node.Metafield.Value).To(Equal(`{"percentage":10}`))
+			Expect(node.AutomaticDiscount).To(BeAssignableToTypeOf(&model.DiscountAutomaticApp{}))
+			Expect(node.AutomaticDiscount.(*model.DiscountAutomaticApp).Status.String()).To(Equal("SCHEDULED"))
+		})
 	})
 })
@@ -0,0 +1,130 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/gempages/go-shopify-graphql"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
+)
+
+var _ = Describe("ClientPool", func() {
+	var (
+		ctx     context.Context
+		server  *shopifytest.Server
+		mu      sync.Mutex
+		lookups map[string]int
+		tokens  shopify.TokenStore
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = shopifytest.NewServer()
+		lookups = make(map[string]int)
+		tokens = shopify.TokenStoreFunc(func(ctx context.Context, shop string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			lookups[shop]++
+			if shop == "uninstalled.myshopify.com" {
+				return "", errors.New("shop not installed")
+			}
+			return shopifytest.AccessToken, nil
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("Client", func() {
+		It("caches the clients of the shops", func() {
+			pool := shopify.NewClientPool(tokens, shopify.WithClientOptions(graphqlclient.WithBaseURL(server.URL)))
+
+			client, err := pool.Client(ctx, "a.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			var out struct {
+				Shop struct {
+					ID string `json:"id"`
+				} `json:"shop"`
+			}
+			err = client.GraphQLClient().QueryString(ctx, "query { shop { id } }", nil, &out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Shop.ID).To(Equal(shopifytest.ShopID))
+
+			again, err := pool.Client(ctx, "a.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(BeIdenticalTo(client))
+			Expect(lookups["a.myshopify.com"]).To(Equal(1))
+		})
+
+		It("evicts the least recently used clients", func() {
+			pool := shopify.NewClientPool(tokens, shopify.WithPoolSize(2))
+
+			for _, shop := range []string{"a.myshopify.com", "b.myshopify.com", "a.myshopify.com", "c.myshopify.com", "a.myshopify.com", "b.myshopify.com"} {
+				_, err := pool.Client(ctx, shop)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(pool.Len()).To(Equal(2))
+			Expect(lookups).To(Equal(map[string]int{"a.myshopify.com": 1, "b.myshopify.com": 2, "c.myshopify.com": 1}))
+		})
+
+		It("looks up the token again once invalidated", func() {
+			pool := shopify.NewClientPool(tokens)
+
+			client, err := pool.Client(ctx, "a.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			pool.Invalidate("a.myshopify.com")
+			Expect(pool.Len()).To(Equal(0))
+
+			again, err := pool.Client(ctx, "a.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).NotTo(BeIdenticalTo(client))
+			Expect(lookups["a.myshopify.com"]).To(Equal(2))
+		})
+
+		It("shares the cost limiter of a shop between its clients", func() {
+			pool := shopify.NewClientPool(tokens, shopify.WithPoolSize(1))
+
+			a, err := pool.Client(ctx, "a.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			limiter := a.GraphQLClient().CostLimiter()
+			Expect(limiter).NotTo(BeNil())
+
+			// Evicts the client of a
+			b, err := pool.Client(ctx, "b.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(b.GraphQLClient().CostLimiter()).NotTo(BeIdenticalTo(limiter))
+
+			again, err := pool.Client(ctx, "a.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).NotTo(BeIdenticalTo(a))
+			Expect(again.GraphQLClient().CostLimiter()).To(BeIdenticalTo(limiter))
+
+			pool.Invalidate("a.myshopify.com")
+			again, err = pool.Client(ctx, "a.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again.GraphQLClient().CostLimiter()).To(BeIdenticalTo(limiter))
+		})
+
+		It("uses the cost limiter of the client options", func() {
+			pool := shopify.NewClientPool(tokens, shopify.WithClientOptions(graphqlclient.WithCostLimiter(nil)))
+
+			client, err := pool.Client(ctx, "a.myshopify.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.GraphQLClient().CostLimiter()).To(BeNil())
+		})
+
+		It("returns the token store errors", func() {
+			pool := shopify.NewClientPool(tokens)
+
+			_, err := pool.Client(ctx, "uninstalled.myshopify.com")
+			Expect(err).To(MatchError(ContainSubstring("shop not installed")))
+			Expect(pool.Len()).To(Equal(0))
+		})
+	})
+})
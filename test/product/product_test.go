@@ -3,7 +3,6 @@ package product_test
 import (
 	"context"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/gempages/go-helper/errors"
@@ -11,7 +10,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/gempages/go-shopify-graphql"
-	shopifyGraph "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/shopifytest"
 )
 
 const (
@@ -26,18 +25,18 @@ var _ = Describe("ProductService", func() {
 	var (
 		ctx           context.Context
 		shopifyClient *shopify.Client
-		domain        string
-		token         string
+		server        *shopifytest.Server
 	)
 
 	BeforeEach(func() {
 		ctx = context.Background()
-		domain = os.Getenv("SHOPIFY_SHOP_DOMAIN")
-		token = os.Getenv("SHOPIFY_API_TOKEN")
-		opts := []shopifyGraph.Option{
-			shopifyGraph.WithToken(token),
-		}
-		shopifyClient = shopify.NewClientWithOpts(domain, opts...)
+		server = shopifytest.NewServer()
+		seedProducts(server)
+		shopifyClient = server.Client()
+	})
+
+	AfterEach(func() {
+		server.Close()
 	})
 
 	Describe("List", func() {
@@ -214,6 +213,42 @@ var _ = Describe("ProductService", func() {
 	})
 })
 
+// seedProducts stores TotalProductCount products with an image and a media each,
+// TestSingleQueryProductID having TestProductVariantCount variants and TestProductMediaCount media.
+func seedProducts(server *shopifytest.Server) {
+	ids := []string{TestSingleQueryProductID, PrefixProductShopify + "8427240423738", PrefixProductShopify + "8427239178554"}
+	for i := len(ids); i < TotalProductCount; i++ {
+		ids = append(ids, "")
+	}
+	mediaTypes := []string{"MediaImage", "Video", "ExternalVideo", "Model3d"}
+	for i, id := range ids {
+		mediaCount := 1
+		if id == TestSingleQueryProductID {
+			mediaCount = TestProductMediaCount
+		}
+		media := make([]any, 0, mediaCount)
+		for j := 0; j < mediaCount; j++ {
+			mediaType := mediaTypes[j%len(mediaTypes)]
+			media = append(media, server.Add(mediaType, map[string]any{
+				"mediaContentType": strings.ToUpper(mediaType),
+				"alt":              fmt.Sprintf("Media %d", j),
+			}))
+		}
+		image := server.Add("ProductImage", map[string]any{"src": fmt.Sprintf("https://cdn.shopify.com/product-%d.png", i)})
+		productID := server.AddProduct(map[string]any{
+			"id":     id,
+			"title":  fmt.Sprintf("Product %d", i),
+			"images": []any{image},
+			"media":  media,
+		})
+		if productID == TestSingleQueryProductID {
+			for j := 0; j < TestProductVariantCount; j++ {
+				server.AddVariant(productID, map[string]any{"title": fmt.Sprintf("Variant %d", j), "price": "10.00"})
+			}
+		}
+	}
+}
+
 var mediaQuery = `media(first: 10) {
 	edges {
 		node {
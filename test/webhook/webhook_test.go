@@ -2,27 +2,30 @@ package webhook_test
 
 import (
 	"context"
-	"os"
 
 	"github.com/gempages/go-shopify-graphql"
 	"github.com/gempages/go-shopify-graphql-model/graph/model"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+
+	"github.com/gempages/go-shopify-graphql/shopifytest"
 )
 
 var _ = Describe("WebhookService", func() {
 	var (
 		ctx           context.Context
 		shopifyClient *shopify.Client
-		domain        string
-		token         string
+		server        *shopifytest.Server
 	)
 
 	BeforeEach(func() {
 		ctx = context.Background()
-		domain = os.Getenv("SHOPIFY_SHOP_DOMAIN")
-		token = os.Getenv("SHOPIFY_API_TOKEN")
-		shopifyClient = shopify.NewClientWithToken(token, domain)
+		server = shopifytest.NewServer()
+		shopifyClient = server.Client()
+	})
+
+	AfterEach(func() {
+		server.Close()
 	})
 
 	Describe("NewWebhookSubscription", func() {
@@ -54,4 +57,170 @@ var _ = Describe("WebhookService", func() {
 			Expect(err).NotTo(HaveOccurred())
 		})
 	})
+
+	Describe("NewPubSubWebhookSubscription", func() {
+		It("creates, updates and lists a Pub/Sub webhook subscription with filters", func() {
+			formatJSON := model.WebhookSubscriptionFormatJSON
+			filter := "vendor:gempages"
+			webhook, err := shopifyClient.Webhook.NewPubSubWebhookSubscription(ctx, model.WebhookSubscriptionTopicProductsUpdate, model.PubSubWebhookSubscriptionInput{
+				PubSubProject:       "gempages",
+				PubSubTopic:         "products",
+				Format:              &formatJSON,
+				Filter:              &filter,
+				IncludeFields:       []string{"id", "title"},
+				MetafieldNamespaces: []string{"custom"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhook.Endpoint).To(Equal(&model.WebhookPubSubEndpoint{PubSubProject: "gempages", PubSubTopic: "products"}))
+			Expect(webhook.Filter).To(HaveValue(Equal(filter)))
+			Expect(webhook.IncludeFields).To(Equal([]string{"id", "title"}))
+			Expect(webhook.MetafieldNamespaces).To(Equal([]string{"custom"}))
+
+			webhook, err = shopifyClient.Webhook.UpdatePubSubWebhookSubscription(ctx, webhook.ID, model.PubSubWebhookSubscriptionInput{
+				PubSubProject: "gempages",
+				PubSubTopic:   "catalog",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhook.Endpoint).To(Equal(&model.WebhookPubSubEndpoint{PubSubProject: "gempages", PubSubTopic: "catalog"}))
+
+			webhooks, err := shopifyClient.Webhook.ListWebhookSubscriptions(ctx, []model.WebhookSubscriptionTopic{model.WebhookSubscriptionTopicProductsUpdate})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhooks).To(HaveLen(1))
+			Expect(webhooks[0].Endpoint).To(Equal(&model.WebhookPubSubEndpoint{PubSubProject: "gempages", PubSubTopic: "catalog"}))
+			Expect(webhooks[0].Filter).To(HaveValue(Equal(filter)))
+			Expect(webhooks[0].MetafieldNamespaces).To(Equal([]string{"custom"}))
+		})
+	})
+
+	Describe("UpdateEventBridgeWebhookSubscription", func() {
+		It("updates the ARN of an EventBridge webhook subscription", func() {
+			arn := "arn:aws:events:us-east-1::event-source/aws.partner/shopify.com/1/old"
+			webhook, err := shopifyClient.Webhook.NewEventBridgeWebhookSubscription(ctx, model.WebhookSubscriptionTopicOrdersCreate, model.EventBridgeWebhookSubscriptionInput{Arn: &arn})
+			Expect(err).NotTo(HaveOccurred())
+
+			arn = "arn:aws:events:us-east-1::event-source/aws.partner/shopify.com/1/new"
+			webhook, err = shopifyClient.Webhook.UpdateEventBridgeWebhookSubscription(ctx, webhook.ID, model.EventBridgeWebhookSubscriptionInput{Arn: &arn})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhook.Endpoint).To(Equal(&model.WebhookEventBridgeEndpoint{Arn: arn}))
+		})
+	})
+
+	Describe("Sync", func() {
+		var desired []shopify.WebhookSpec
+
+		BeforeEach(func() {
+			server.AddWebhookSubscription("PRODUCTS_UPDATE", "https://old.gempages.xyz/webhook")
+			server.AddWebhookSubscription("ORDERS_CREATE", "https://gempages.xyz/webhook")
+			server.AddWebhookSubscription("SHOP_REDACT", "https://gempages.xyz/webhook")
+
+			desired = []shopify.WebhookSpec{
+				{Topic: model.WebhookSubscriptionTopicProductsUpdate, CallbackURL: "https://gempages.xyz/webhook"},
+				{Topic: model.WebhookSubscriptionTopicOrdersCreate, CallbackURL: "https://gempages.xyz/webhook", IncludeFields: []string{"id", "email"}, Filter: "financial_status:paid"},
+				{Topic: model.WebhookSubscriptionTopicAppUninstalled, Arn: "arn:aws:events:us-east-1::event-source/aws.partner/shopify.com/1/source"},
+				{Topic: model.WebhookSubscriptionTopicBulkOperationsFinish, PubSubProject: "gempages", PubSubTopic: "bulk"},
+			}
+		})
+
+		It("plans the changes without applying them", func() {
+			plan, err := shopifyClient.Webhook.PlanSync(ctx, desired)
+			Expect(err).NotTo(HaveOccurred())
+
+			var changes []string
+			for _, change := range plan.Changes {
+				changes = append(changes, string(change.Action)+" "+string(topicOf(change)))
+			}
+			Expect(changes).To(Equal([]string{
+				"UPDATE ORDERS_CREATE",
+				"UPDATE PRODUCTS_UPDATE",
+				"CREATE APP_UNINSTALLED",
+				"CREATE BULK_OPERATIONS_FINISH",
+				"DELETE SHOP_REDACT",
+			}))
+
+			webhooks, err := shopifyClient.Webhook.ListWebhookSubscriptions(ctx, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhooks).To(HaveLen(3))
+		})
+
+		It("applies the plan", func() {
+			_, err := shopifyClient.Webhook.Sync(ctx, desired)
+			Expect(err).NotTo(HaveOccurred())
+
+			webhooks, err := shopifyClient.Webhook.ListWebhookSubscriptions(ctx, nil)
+			Expect(err).NotTo(HaveOccurred())
+			endpoints := map[model.WebhookSubscriptionTopic]model.WebhookSubscriptionEndpoint{}
+			for _, webhook := range webhooks {
+				endpoints[webhook.Topic] = webhook.Endpoint
+				if webhook.Topic == model.WebhookSubscriptionTopicOrdersCreate {
+					Expect(webhook.IncludeFields).To(Equal([]string{"id", "email"}))
+					Expect(webhook.Filter).To(HaveValue(Equal("financial_status:paid")))
+				}
+			}
+			Expect(endpoints).To(Equal(map[model.WebhookSubscriptionTopic]model.WebhookSubscriptionEndpoint{
+				model.WebhookSubscriptionTopicProductsUpdate:       &model.WebhookHTTPEndpoint{CallbackURL: "https://gempages.xyz/webhook"},
+				model.WebhookSubscriptionTopicOrdersCreate:         &model.WebhookHTTPEndpoint{CallbackURL: "https://gempages.xyz/webhook"},
+				model.WebhookSubscriptionTopicAppUninstalled:       &model.WebhookEventBridgeEndpoint{Arn: "arn:aws:events:us-east-1::event-source/aws.partner/shopify.com/1/source"},
+				model.WebhookSubscriptionTopicBulkOperationsFinish: &model.WebhookPubSubEndpoint{PubSubProject: "gempages", PubSubTopic: "bulk"},
+			}))
+
+			plan, err := shopifyClient.Webhook.PlanSync(ctx, desired)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Empty()).To(BeTrue())
+			Expect(plan.Unchanged).To(HaveLen(4))
+		})
+
+		It("updates a subscription created while the plan is applied", func() {
+			desired := []shopify.WebhookSpec{
+				{Topic: model.WebhookSubscriptionTopicAppSubscriptionsUpdate, CallbackURL: "https://gempages.xyz/webhook", Filter: "state:enabled"},
+			}
+			plan, err := shopifyClient.Webhook.PlanSync(ctx, desired)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.Changes[0].Action).To(Equal(shopify.WebhookSyncCreate))
+
+			// Created by a concurrent deploy
+			server.AddWebhookSubscription("APP_SUBSCRIPTIONS_UPDATE", "https://gempages.xyz/webhook")
+			Expect(shopifyClient.Webhook.ApplySyncPlan(ctx, plan)).To(Succeed())
+			Expect(plan.Changes[0].Action).To(Equal(shopify.WebhookSyncUpdate))
+			Expect(plan.Changes[0].Subscription).NotTo(BeNil())
+
+			webhooks, err := shopifyClient.Webhook.ListWebhookSubscriptions(ctx, []model.WebhookSubscriptionTopic{model.WebhookSubscriptionTopicAppSubscriptionsUpdate})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhooks).To(HaveLen(1))
+			Expect(webhooks[0].Filter).To(HaveValue(Equal("state:enabled")))
+		})
+
+		It("recreates a subscription to clear its include fields", func() {
+			callbackURL := "https://gempages.xyz/subscriptions"
+			webhook, err := shopifyClient.Webhook.NewWebhookSubscription(ctx, model.WebhookSubscriptionTopicAppSubscriptionsUpdate, model.WebhookSubscriptionInput{
+				CallbackURL:   &callbackURL,
+				IncludeFields: []string{"id"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = shopifyClient.Webhook.Sync(ctx, []shopify.WebhookSpec{
+				{Topic: model.WebhookSubscriptionTopicAppSubscriptionsUpdate, CallbackURL: callbackURL},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			webhooks, err := shopifyClient.Webhook.ListWebhookSubscriptions(ctx, []model.WebhookSubscriptionTopic{model.WebhookSubscriptionTopicAppSubscriptionsUpdate})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(webhooks).To(HaveLen(1))
+			Expect(webhooks[0].ID).NotTo(Equal(webhook.ID))
+			Expect(webhooks[0].IncludeFields).To(BeEmpty())
+		})
+
+		It("rejects specs without exactly one endpoint", func() {
+			_, err := shopifyClient.Webhook.Sync(ctx, []shopify.WebhookSpec{
+				{Topic: model.WebhookSubscriptionTopicProductsUpdate, CallbackURL: "https://gempages.xyz/webhook", Arn: "arn"},
+			})
+			Expect(err).To(MatchError(shopify.ErrInvalidWebhookSpec))
+		})
+	})
 })
+
+func topicOf(change shopify.WebhookSyncChange) model.WebhookSubscriptionTopic {
+	if change.Spec != nil {
+		return change.Spec.Topic
+	}
+	return change.Subscription.Topic
+}
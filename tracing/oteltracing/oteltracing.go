@@ -0,0 +1,89 @@
+// Package oteltracing adapts an OpenTelemetry tracer to the tracer of the client.
+//
+//	client := shopify.NewClientWithOpts(domain,
+//		graphqlclient.WithToken(token),
+//		graphqlclient.WithTracer(oteltracing.New(otel.Tracer("shopify"))),
+//	)
+package oteltracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/gempages/go-shopify-graphql/tracing"
+)
+
+// New returns a tracer starting the spans with tracer.
+func New(tracer trace.Tracer) tracing.Tracer {
+	return otelTracer{tracer: tracer}
+}
+
+type otelTracer struct {
+	tracer trace.Tracer
+}
+
+func (t otelTracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(spanKind(name)), trace.WithAttributes(convert(attrs)...))
+	return ctx, otelSpan{span: span}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) SetAttributes(attrs ...tracing.Attribute) {
+	s.span.SetAttributes(convert(attrs)...)
+}
+
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+func spanKind(name string) trace.SpanKind {
+	switch name {
+	case tracing.SpanGraphQLSend, tracing.SpanDownloadFile:
+		return trace.SpanKindClient
+	default:
+		return trace.SpanKindInternal
+	}
+}
+
+func convert(attrs []tracing.Attribute) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		out = append(out, keyValue(attr))
+	}
+	return out
+}
+
+func keyValue(attr tracing.Attribute) attribute.KeyValue {
+	switch v := attr.Value.(type) {
+	case string:
+		return attribute.String(attr.Key, v)
+	case bool:
+		return attribute.Bool(attr.Key, v)
+	case int:
+		return attribute.Int(attr.Key, v)
+	case int64:
+		return attribute.Int64(attr.Key, v)
+	case float64:
+		return attribute.Float64(attr.Key, v)
+	case fmt.Stringer:
+		return attribute.String(attr.Key, v.String())
+	}
+	// Such as GraphQL variables
+	data, err := json.Marshal(attr.Value)
+	if err != nil {
+		return attribute.String(attr.Key, fmt.Sprint(attr.Value))
+	}
+	return attribute.String(attr.Key, string(data))
+}
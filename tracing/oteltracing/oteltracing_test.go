@@ -0,0 +1,88 @@
+package oteltracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	graphqlclient "github.com/gempages/go-shopify-graphql/graph"
+	"github.com/gempages/go-shopify-graphql/tracing"
+	"github.com/gempages/go-shopify-graphql/tracing/oteltracing"
+)
+
+func TestTracer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"data":{"shop":{"name":"test"}},"extensions":{"cost":{"requestedQueryCost":2,"actualQueryCost":1,"throttleStatus":{"maximumAvailable":2000.0,"currentlyAvailable":1999,"restoreRate":100.0}}}}`))
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	client := graphqlclient.NewClient("example.myshopify.com",
+		graphqlclient.WithToken("token"),
+		graphqlclient.WithVersion("2024-10"),
+		graphqlclient.WithBaseURL(server.URL),
+		graphqlclient.WithCostLimiter(nil),
+		graphqlclient.WithTracer(oteltracing.New(provider.Tracer("shopify"))),
+	)
+
+	var v interface{}
+	err := client.QueryString(context.Background(), "query { shop { name } }", nil, &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected (%v) span, got (%v)", 1, len(spans))
+	}
+	span := spans[0]
+	if span.Name != tracing.SpanGraphQLSend || span.SpanKind != trace.SpanKindClient || span.Status.Code == codes.Error {
+		t.Errorf("unexpected span %+v", span)
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, attr := range span.Attributes {
+		attrs[attr.Key] = attr.Value
+	}
+	expected := map[attribute.Key]attribute.Value{
+		tracing.AttrOperation:          attribute.StringValue("shop"),
+		tracing.AttrShopDomain:         attribute.StringValue("example.myshopify.com"),
+		tracing.AttrAPIVersion:         attribute.StringValue("2024-10"),
+		tracing.AttrRetryCount:         attribute.IntValue(0),
+		tracing.AttrRequestedQueryCost: attribute.Float64Value(2),
+		tracing.AttrActualQueryCost:    attribute.Float64Value(1),
+	}
+	for key, value := range expected {
+		if attrs[key] != value {
+			t.Errorf("expected (%v) to be (%v), got (%v)", key, value.Emit(), attrs[key].Emit())
+		}
+	}
+}
+
+func TestSpanError(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := oteltracing.New(provider.Tracer("shopify"))
+
+	_, span := tracer.Start(context.Background(), tracing.SpanBulkQuery, tracing.Attr(tracing.AttrVariables, map[string]any{"first": 1}))
+	span.End(errors.New("failed"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("expected (%v) span, got (%v)", 1, len(spans))
+	}
+	if spans[0].Status.Code != codes.Error || spans[0].Status.Description != "failed" || len(spans[0].Events) != 1 {
+		t.Errorf("expected failed span, got (%+v)", spans[0].Status)
+	}
+	if got := spans[0].Attributes[0].Value.AsString(); got != `{"first":1}` {
+		t.Errorf("expected (%v), got (%v)", `{"first":1}`, got)
+	}
+}
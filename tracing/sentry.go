@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/gempages/go-helper/tracing"
+	"github.com/getsentry/sentry-go"
+)
+
+// sentryDataKeys keeps the span data keys used before tracers were pluggable, so that existing Sentry queries still work.
+var sentryDataKeys = map[string]string{
+	AttrQuery:     "GraphQL Query",
+	AttrVariables: "GraphQL Variables",
+	AttrURL:       "URL",
+}
+
+// Sentry returns a tracer starting Sentry spans, the default tracer of the client.
+// The operation name, or the URL when there is none, is used as the span description.
+func Sentry() Tracer {
+	return sentryTracer{}
+}
+
+type sentryTracer struct{}
+
+func (sentryTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	span := &sentrySpan{span: sentry.StartSpan(ctx, name)}
+	span.SetAttributes(attrs...)
+	return span.span.Context(), span
+}
+
+type sentrySpan struct {
+	span *sentry.Span
+}
+
+func (s *sentrySpan) SetAttributes(attrs ...Attribute) {
+	for _, attr := range attrs {
+		switch {
+		case attr.Key == AttrOperation:
+			s.span.Description, _ = attr.Value.(string)
+		case attr.Key == AttrURL && s.span.Description == "":
+			s.span.Description, _ = attr.Value.(string)
+		}
+		key, ok := sentryDataKeys[attr.Key]
+		if !ok {
+			key = attr.Key
+		}
+		s.span.SetData(key, attr.Value)
+	}
+}
+
+func (s *sentrySpan) End(err error) {
+	tracing.FinishSpan(s.span, err)
+}
@@ -0,0 +1,100 @@
+// Package tracing defines the tracer used by the client to trace GraphQL requests, bulk operations and file downloads,
+// with a Sentry adapter, used by default, and a no-op one. The oteltracing package provides an OpenTelemetry adapter.
+package tracing
+
+import (
+	"context"
+)
+
+// Span names
+const (
+	SpanGraphQLSend  = "shopify_graphql.send"
+	SpanBulkQuery    = "shopify_graphql.bulk_query"
+	SpanBulkMutation = "shopify_graphql.bulk_mutation"
+	SpanSleep        = "time.sleep"
+	SpanDownloadFile = "shopify.download_file"
+)
+
+// Attribute keys
+const (
+	// AttrOperation is the name of the GraphQL operation, the root fields of the query
+	AttrOperation = "graphql.operation.name"
+	// AttrQuery is the GraphQL query document
+	AttrQuery = "graphql.document"
+	// AttrVariables are the GraphQL variables
+	AttrVariables = "graphql.variables"
+	// AttrShopDomain is the domain of the shop
+	AttrShopDomain = "shopify.shop.domain"
+	// AttrAPIVersion is the Admin API version
+	AttrAPIVersion = "shopify.api.version"
+	// AttrRequestedQueryCost is the requested cost of the query
+	AttrRequestedQueryCost = "shopify.query_cost.requested"
+	// AttrActualQueryCost is the actual cost of the query
+	AttrActualQueryCost = "shopify.query_cost.actual"
+	// AttrRetryCount is the number of times the request was retried
+	AttrRetryCount = "shopify.retry_count"
+	// AttrURL is the URL of the request
+	AttrURL = "url.full"
+)
+
+// Attribute is a key-value pair attached to a span.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Attr returns an attribute.
+func Attr(key string, value any) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any, returning a context holding the new span
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is an operation being traced.
+type Span interface {
+	// SetAttributes sets attributes on the span
+	SetAttributes(attrs ...Attribute)
+	// End finishes the span, marking it as failed when err is not nil
+	End(err error)
+}
+
+// Noop returns a tracer that doesn't trace anything.
+func Noop() Tracer {
+	return noopTracer{}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+
+func (noopSpan) End(error) {}
+
+// WithAttributes returns a tracer setting attrs on every span started by tracer, such as the shop domain.
+func WithAttributes(tracer Tracer, attrs ...Attribute) Tracer {
+	if len(attrs) == 0 {
+		return tracer
+	}
+	return attributesTracer{tracer: tracer, attrs: attrs}
+}
+
+type attributesTracer struct {
+	tracer Tracer
+	attrs  []Attribute
+}
+
+func (t attributesTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	all := make([]Attribute, 0, len(t.attrs)+len(attrs))
+	all = append(all, t.attrs...)
+	all = append(all, attrs...)
+	return t.tracer.Start(ctx, name, all...)
+}
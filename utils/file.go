@@ -10,23 +10,29 @@ import (
 	"os"
 	"time"
 
-	"github.com/gempages/go-helper/tracing"
-	"github.com/getsentry/sentry-go"
-
 	pkghttp "github.com/gempages/go-shopify-graphql/http"
+	"github.com/gempages/go-shopify-graphql/tracing"
 )
 
 func DownloadFile(ctx context.Context, file *os.File, url string) error {
+	return DownloadFileWithClient(ctx, http.DefaultClient, file, url)
+}
+
+// DownloadFileWithClient downloads url into file using the given HTTP client
+func DownloadFileWithClient(ctx context.Context, client *http.Client, file *os.File, url string) error {
+	return DownloadFileWithTracer(ctx, client, tracing.Sentry(), file, url)
+}
+
+// DownloadFileWithTracer downloads url into file using the given HTTP client, tracing the download with tracer
+func DownloadFileWithTracer(ctx context.Context, client *http.Client, tracer tracing.Tracer, file *os.File, url string) error {
 	var err error
 
-	span := sentry.StartSpan(ctx, "shopify.download_file")
-	span.Description = url
+	ctx, span := tracer.Start(ctx, tracing.SpanDownloadFile, tracing.Attr(tracing.AttrURL, url))
 	defer func() {
-		tracing.FinishSpan(span, err)
+		span.End(err)
 	}()
-	ctx = span.Context()
 
-	resp, err := httpGetWithRetry(url)
+	resp, err := httpGetWithRetry(ctx, client, url)
 	if err != nil {
 		return err
 	}
@@ -36,10 +42,15 @@ func DownloadFile(ctx context.Context, file *os.File, url string) error {
 	return err
 }
 
-func httpGetWithRetry(url string) (resp *http.Response, err error) {
+func httpGetWithRetry(ctx context.Context, client *http.Client, url string) (resp *http.Response, err error) {
 	var uerr *neturl.Error
 	for i := 1; i <= 3; i++ {
-		resp, err = http.Get(url)
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return
+		}
+		resp, err = client.Do(req)
 		if err != nil {
 			err = fmt.Errorf("attempt %v: %w", i, err)
 			if errors.As(err, &uerr) && (uerr.Timeout() || uerr.Temporary()) || pkghttp.IsConnectionError(err) {
@@ -10,9 +10,15 @@ import (
 type WebhookService interface {
 	NewWebhookSubscription(ctx context.Context, topic model.WebhookSubscriptionTopic, input model.WebhookSubscriptionInput) (output *model.WebhookSubscription, err error)
 	NewEventBridgeWebhookSubscription(ctx context.Context, topic model.WebhookSubscriptionTopic, input model.EventBridgeWebhookSubscriptionInput) (output *model.WebhookSubscription, err error)
+	NewPubSubWebhookSubscription(ctx context.Context, topic model.WebhookSubscriptionTopic, input model.PubSubWebhookSubscriptionInput) (output *model.WebhookSubscription, err error)
 	ListWebhookSubscriptions(ctx context.Context, topics []model.WebhookSubscriptionTopic) (output []*model.WebhookSubscription, err error)
 	DeleteWebhook(ctx context.Context, webhookID string) (deletedID *string, err error)
 	UpdateWebhookSubscription(ctx context.Context, webhookID string, input model.WebhookSubscriptionInput) (output *model.WebhookSubscription, err error)
+	UpdateEventBridgeWebhookSubscription(ctx context.Context, webhookID string, input model.EventBridgeWebhookSubscriptionInput) (output *model.WebhookSubscription, err error)
+	UpdatePubSubWebhookSubscription(ctx context.Context, webhookID string, input model.PubSubWebhookSubscriptionInput) (output *model.WebhookSubscription, err error)
+	PlanSync(ctx context.Context, desired []WebhookSpec) (*WebhookSyncPlan, error)
+	ApplySyncPlan(ctx context.Context, plan *WebhookSyncPlan) error
+	Sync(ctx context.Context, desired []WebhookSpec) (*WebhookSyncPlan, error)
 }
 
 type WebhookServiceOp struct {
@@ -37,6 +43,18 @@ type mutationEventBridgeWebhookCreate struct {
 	EventBridgeWebhookCreateResult *model.EventBridgeWebhookSubscriptionCreatePayload `graphql:"eventBridgeWebhookSubscriptionCreate(topic: $topic, webhookSubscription: $webhookSubscription)" json:"eventBridgeWebhookSubscriptionCreate"`
 }
 
+type mutationEventBridgeWebhookUpdate struct {
+	EventBridgeWebhookUpdateResult *model.EventBridgeWebhookSubscriptionUpdatePayload `graphql:"eventBridgeWebhookSubscriptionUpdate(id: $id, webhookSubscription: $webhookSubscription)" json:"eventBridgeWebhookSubscriptionUpdate"`
+}
+
+type mutationPubSubWebhookCreate struct {
+	PubSubWebhookCreateResult *model.PubSubWebhookSubscriptionCreatePayload `graphql:"pubSubWebhookSubscriptionCreate(topic: $topic, webhookSubscription: $webhookSubscription)" json:"pubSubWebhookSubscriptionCreate"`
+}
+
+type mutationPubSubWebhookUpdate struct {
+	PubSubWebhookUpdateResult *model.PubSubWebhookSubscriptionUpdatePayload `graphql:"pubSubWebhookSubscriptionUpdate(id: $id, webhookSubscription: $webhookSubscription)" json:"pubSubWebhookSubscriptionUpdate"`
+}
+
 // NOTE: Have to use this because writeQuery function will not write structs that implements UnmarshalJSON function
 const webhookSubscriptionMutationSelects = `
 userErrors {
@@ -51,6 +69,7 @@ webhookSubscription {
 	}
 	callbackUrl
 	createdAt
+	filter
 	format
 	id
 	includeFields
@@ -67,6 +86,10 @@ webhookSubscription {
 		...on WebhookHttpEndpoint {
 			callbackUrl
 		}
+		...on WebhookPubSubEndpoint {
+			pubSubProject
+			pubSubTopic
+		}
 	}
 }`
 
@@ -117,6 +140,30 @@ func (w WebhookServiceOp) NewEventBridgeWebhookSubscription(ctx context.Context,
 	return v.EventBridgeWebhookCreateResult.WebhookSubscription, nil
 }
 
+func (w WebhookServiceOp) NewPubSubWebhookSubscription(ctx context.Context, topic model.WebhookSubscriptionTopic, input model.PubSubWebhookSubscriptionInput) (output *model.WebhookSubscription, err error) {
+	m := fmt.Sprintf(`mutation($topic: WebhookSubscriptionTopic!, $webhookSubscription: PubSubWebhookSubscriptionInput!) {
+	pubSubWebhookSubscriptionCreate(topic: $topic, webhookSubscription: $webhookSubscription) {
+		%s
+	}}`, webhookSubscriptionMutationSelects)
+	v := mutationPubSubWebhookCreate{}
+	vars := map[string]interface{}{
+		"topic":               topic,
+		"webhookSubscription": input,
+	}
+
+	err = w.client.gql.MutateString(ctx, m, vars, &v)
+	if err != nil {
+		return
+	}
+
+	if len(v.PubSubWebhookCreateResult.UserErrors) > 0 {
+		err = fmt.Errorf("%+v", v.PubSubWebhookCreateResult.UserErrors)
+		return
+	}
+
+	return v.PubSubWebhookCreateResult.WebhookSubscription, nil
+}
+
 func (w WebhookServiceOp) DeleteWebhook(ctx context.Context, webhookID string) (deletedID *string, err error) {
 	m := mutationWebhookDelete{}
 	vars := map[string]interface{}{
@@ -155,11 +202,17 @@ func (w WebhookServiceOp) ListWebhookSubscriptions(ctx context.Context, topics [
 						... on WebhookEventBridgeEndpoint{
 							arn
 						}
+						... on WebhookPubSubEndpoint {
+							pubSubProject
+							pubSubTopic
+						}
 					}
 					callbackUrl
+					filter
 					format
 					topic
 					includeFields
+					metafieldNamespaces
 					createdAt
 					updatedAt
 				}
@@ -226,3 +279,49 @@ func (w WebhookServiceOp) UpdateWebhookSubscription(ctx context.Context, webhook
 
 	return v.WebhookUpdateResult.WebhookSubscription, nil
 }
+
+func (w WebhookServiceOp) UpdateEventBridgeWebhookSubscription(ctx context.Context, webhookID string, input model.EventBridgeWebhookSubscriptionInput) (output *model.WebhookSubscription, err error) {
+	m := fmt.Sprintf(`mutation eventBridgeWebhookSubscriptionUpdate($id: ID!, $webhookSubscription: EventBridgeWebhookSubscriptionInput!) {
+	eventBridgeWebhookSubscriptionUpdate(id: $id, webhookSubscription: $webhookSubscription) {
+		%s
+	}}`, webhookSubscriptionMutationSelects)
+	v := mutationEventBridgeWebhookUpdate{}
+	vars := map[string]interface{}{
+		"id":                  webhookID,
+		"webhookSubscription": input,
+	}
+	err = w.client.gql.MutateString(ctx, m, vars, &v)
+	if err != nil {
+		return
+	}
+
+	if len(v.EventBridgeWebhookUpdateResult.UserErrors) > 0 {
+		err = fmt.Errorf("%+v", v.EventBridgeWebhookUpdateResult.UserErrors)
+		return
+	}
+
+	return v.EventBridgeWebhookUpdateResult.WebhookSubscription, nil
+}
+
+func (w WebhookServiceOp) UpdatePubSubWebhookSubscription(ctx context.Context, webhookID string, input model.PubSubWebhookSubscriptionInput) (output *model.WebhookSubscription, err error) {
+	m := fmt.Sprintf(`mutation pubSubWebhookSubscriptionUpdate($id: ID!, $webhookSubscription: PubSubWebhookSubscriptionInput!) {
+	pubSubWebhookSubscriptionUpdate(id: $id, webhookSubscription: $webhookSubscription) {
+		%s
+	}}`, webhookSubscriptionMutationSelects)
+	v := mutationPubSubWebhookUpdate{}
+	vars := map[string]interface{}{
+		"id":                  webhookID,
+		"webhookSubscription": input,
+	}
+	err = w.client.gql.MutateString(ctx, m, vars, &v)
+	if err != nil {
+		return
+	}
+
+	if len(v.PubSubWebhookUpdateResult.UserErrors) > 0 {
+		err = fmt.Errorf("%+v", v.PubSubWebhookUpdateResult.UserErrors)
+		return
+	}
+
+	return v.PubSubWebhookUpdateResult.WebhookSubscription, nil
+}
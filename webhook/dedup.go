@@ -0,0 +1,252 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupTTL is the default time a handled webhook is remembered for, longer than Shopify retries a delivery
+	DefaultDedupTTL = 48 * time.Hour
+	// DefaultInFlightTTL is the default time a webhook is claimed for while it is handled
+	DefaultInFlightTTL = 5 * time.Minute
+)
+
+// Store remembers the webhooks handled already. Claim, Complete and Release must be atomic across the processes
+// sharing the store, e.g. with SET NX PX, SET PX and DEL in Redis.
+//
+// Dedup claims a webhook for the in-flight TTL before handling it, and completes it for the dedup TTL once handled.
+// If the process dies in between, the claim expires and the next delivery is handled again: the webhooks are
+// handled at least once, a handler interrupted after its side effects possibly running twice. An in-flight TTL
+// as long as the dedup TTL, see WithInFlightTTL, handles them at most once instead: an interrupted webhook
+// is never handled again. The in-flight TTL must be longer than the handlers run, else a redelivery received
+// meanwhile is handled concurrently.
+type Store interface {
+	// Claim records key for ttl and reports whether it wasn't recorded yet, i.e. whether the caller should handle the webhook.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Complete records key for ttl whether it is recorded already or not, once its webhook was handled.
+	Complete(ctx context.Context, key string, ttl time.Duration) error
+	// Release forgets key, so that the next delivery of the webhook is handled.
+	Release(ctx context.Context, key string) error
+}
+
+// OrderStore remembers the time of the latest event of each resource, see WithOrdering.
+type OrderStore interface {
+	// Advance records t as the time of the latest event of key for ttl, unless a later time was recorded,
+	// and reports whether t isn't before the recorded time.
+	Advance(ctx context.Context, key string, t time.Time, ttl time.Duration) (bool, error)
+}
+
+// DedupOption is used to configure Dedup
+type DedupOption func(d *dedup)
+
+// WithDedupTTL optionally sets the time a handled webhook is remembered for. Defaults to DefaultDedupTTL.
+func WithDedupTTL(ttl time.Duration) DedupOption {
+	return func(d *dedup) {
+		d.ttl = ttl
+	}
+}
+
+// WithInFlightTTL optionally sets the time a webhook is claimed for while it is handled, see Store.
+// Defaults to DefaultInFlightTTL.
+func WithInFlightTTL(ttl time.Duration) DedupOption {
+	return func(d *dedup) {
+		d.inFlightTTL = ttl
+	}
+}
+
+// WithOrdering optionally records the trigger time of the webhooks per resource, key returning the resource of a webhook,
+// e.g. the topic and the ID of the order, or "" to skip it. Handlers can then tell whether a later event of the resource
+// was received already with Stale, since Shopify doesn't deliver the webhooks in order.
+func WithOrdering(store OrderStore, key func(w *Webhook) string) DedupOption {
+	return func(d *dedup) {
+		d.orders = store
+		d.orderKey = key
+	}
+}
+
+type dedup struct {
+	store       Store
+	next        Handler
+	ttl         time.Duration
+	inFlightTTL time.Duration
+	orders      OrderStore
+	orderKey    func(w *Webhook) string
+}
+
+// Dedup returns a handler calling next once per event, acknowledging the duplicate deliveries.
+// Webhooks are identified by their topic and event ID, or webhook ID when the event ID is missing.
+// The webhook is released when next fails, so that its next delivery is handled again.
+func Dedup(store Store, next Handler, opts ...DedupOption) Handler {
+	d := &dedup{
+		store:       store,
+		next:        next,
+		ttl:         DefaultDedupTTL,
+		inFlightTTL: DefaultInFlightTTL,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// DedupKey returns the key Dedup identifies w with, "" when w has neither an event ID nor a webhook ID.
+func DedupKey(w *Webhook) string {
+	id := w.EventID
+	if id == "" {
+		id = w.WebhookID
+	}
+	if id == "" {
+		return ""
+	}
+	return w.RawTopic + ":" + id
+}
+
+func (d *dedup) ServeWebhook(ctx context.Context, w *Webhook) error {
+	key := DedupKey(w)
+	if key == "" {
+		return d.serve(ctx, w)
+	}
+
+	claimed, err := d.store.Claim(ctx, key, d.inFlightTTL)
+	if err != nil {
+		return fmt.Errorf("claim webhook: %w", err)
+	}
+	if !claimed {
+		return nil
+	}
+
+	err = d.serve(ctx, w)
+	// Use a new context, the handler could have failed because ctx is done
+	storeCtx := context.WithoutCancel(ctx)
+	if err != nil && !IsPermanent(err) {
+		if rerr := d.store.Release(storeCtx, key); rerr != nil {
+			return fmt.Errorf("%w, release webhook: %w", err, rerr)
+		}
+		return err
+	}
+	// Permanent errors aren't retried either
+	if cerr := d.store.Complete(storeCtx, key, d.ttl); cerr != nil {
+		if err != nil {
+			return fmt.Errorf("%w, complete webhook: %w", err, cerr)
+		}
+		return fmt.Errorf("complete webhook: %w", cerr)
+	}
+	return err
+}
+
+func (d *dedup) serve(ctx context.Context, w *Webhook) error {
+	if d.orders != nil && !w.TriggeredAt.IsZero() {
+		if key := d.orderKey(w); key != "" {
+			latest, err := d.orders.Advance(ctx, key, w.TriggeredAt, d.ttl)
+			if err != nil {
+				return fmt.Errorf("advance webhook order: %w", err)
+			}
+			ctx = context.WithValue(ctx, staleKey{}, !latest)
+		}
+	}
+	return d.next.ServeWebhook(ctx, w)
+}
+
+type staleKey struct{}
+
+// Stale reports whether an event of the resource of the webhook handled with ctx was triggered after it,
+// in which case the webhook shouldn't overwrite the state of the resource. It needs Dedup with WithOrdering.
+func Stale(ctx context.Context) bool {
+	stale, _ := ctx.Value(staleKey{}).(bool)
+	return stale
+}
+
+// MemoryStore is a Store and OrderStore keeping the keys in memory, for a single process.
+// The expired keys are removed periodically. A MemoryStore is safe for concurrent use.
+type MemoryStore struct {
+	mu        sync.Mutex
+	claims    map[string]time.Time
+	orders    map[string]memoryOrder
+	nextSweep time.Time
+}
+
+type memoryOrder struct {
+	latest    time.Time
+	expiresAt time.Time
+}
+
+// sweepInterval is the interval between the removals of the expired keys of a MemoryStore
+const sweepInterval = time.Minute
+
+var (
+	_ Store      = &MemoryStore{}
+	_ OrderStore = &MemoryStore{}
+)
+
+// NewMemoryStore returns an empty memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		claims: make(map[string]time.Time),
+		orders: make(map[string]memoryOrder),
+	}
+}
+
+func (s *MemoryStore) Claim(_ context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if expiresAt, ok := s.claims[key]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	s.claims[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.claims, key)
+	return nil
+}
+
+func (s *MemoryStore) Advance(_ context.Context, key string, t time.Time, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.sweep(now)
+	if order, ok := s.orders[key]; ok && now.Before(order.expiresAt) && t.Before(order.latest) {
+		return false, nil
+	}
+	s.orders[key] = memoryOrder{latest: t, expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+// Len returns the number of keys in the store, including the expired ones not removed yet.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.claims) + len(s.orders)
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Before(s.nextSweep) {
+		return
+	}
+	s.nextSweep = now.Add(sweepInterval)
+	for key, expiresAt := range s.claims {
+		if !now.Before(expiresAt) {
+			delete(s.claims, key)
+		}
+	}
+	for key, order := range s.orders {
+		if !now.Before(order.expiresAt) {
+			delete(s.orders, key)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+
+	"github.com/gempages/go-shopify-graphql/webhook"
+)
+
+func TestDedup(t *testing.T) {
+	store := webhook.NewMemoryStore()
+	calls := 0
+	fail := true
+	handler := webhook.Dedup(store, webhook.HandlerFunc(func(ctx context.Context, w *webhook.Webhook) error {
+		calls++
+		if fail {
+			return errors.New("database unavailable")
+		}
+		return nil
+	}))
+	r := webhook.NewReceiver(secret)
+	r.Handle(model.WebhookSubscriptionTopicOrdersCreate, handler)
+
+	// The failed delivery is released and handled again
+	if code := serve(r, newRequest("orders/create", `{}`, secret)); code != http.StatusInternalServerError {
+		t.Errorf("expected (%v), got (%v)", http.StatusInternalServerError, code)
+	}
+	fail = false
+	for i := 0; i < 3; i++ {
+		if code := serve(r, newRequest("orders/create", `{}`, secret)); code != http.StatusOK {
+			t.Errorf("expected (%v), got (%v)", http.StatusOK, code)
+		}
+	}
+	if calls != 2 {
+		t.Errorf("expected (%v), got (%v)", 2, calls)
+	}
+
+	// Another webhook of the same event, e.g. of another subscription
+	req := newRequest("orders/create", `{}`, secret)
+	req.Header.Set(webhook.HeaderWebhookID, "another-webhook")
+	serve(r, req)
+	if calls != 2 {
+		t.Errorf("expected (%v), got (%v)", 2, calls)
+	}
+
+	// Another event
+	req = newRequest("orders/create", `{}`, secret)
+	req.Header.Set(webhook.HeaderEventID, "another-event")
+	serve(r, req)
+	if calls != 3 {
+		t.Errorf("expected (%v), got (%v)", 3, calls)
+	}
+}
+
+func TestDedupConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	handler := webhook.Dedup(webhook.NewMemoryStore(), webhook.HandlerFunc(func(ctx context.Context, w *webhook.Webhook) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return nil
+	}))
+	w := &webhook.Webhook{RawTopic: "orders/create", EventID: "1"}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = handler.ServeWebhook(context.Background(), w)
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expected (%v), got (%v)", 1, calls)
+	}
+}
+
+func TestDedupInFlight(t *testing.T) {
+	ctx := context.Background()
+	store := webhook.NewMemoryStore()
+	calls := 0
+	handler := webhook.Dedup(store, webhook.HandlerFunc(func(ctx context.Context, w *webhook.Webhook) error {
+		calls++
+		return nil
+	}), webhook.WithInFlightTTL(time.Millisecond))
+
+	// A process died handling the webhook, its claim expires
+	w := &webhook.Webhook{RawTopic: "orders/create", EventID: "1"}
+	if ok, _ := store.Claim(ctx, webhook.DedupKey(w), time.Millisecond); !ok {
+		t.Fatalf("expected (%v), got (%v)", true, ok)
+	}
+	_ = handler.ServeWebhook(ctx, w)
+	if calls != 0 {
+		t.Errorf("expected (%v), got (%v)", 0, calls)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := handler.ServeWebhook(ctx, w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected (%v), got (%v)", 1, calls)
+	}
+
+	// The handled webhook is remembered for the dedup TTL, not the in-flight one
+	time.Sleep(5 * time.Millisecond)
+	_ = handler.ServeWebhook(ctx, w)
+	if calls != 1 {
+		t.Errorf("expected (%v), got (%v)", 1, calls)
+	}
+}
+
+func TestMemoryStoreTTL(t *testing.T) {
+	ctx := context.Background()
+	store := webhook.NewMemoryStore()
+	if ok, _ := store.Claim(ctx, "key", time.Millisecond); !ok {
+		t.Errorf("expected (%v), got (%v)", true, ok)
+	}
+	if ok, _ := store.Claim(ctx, "key", time.Millisecond); ok {
+		t.Errorf("expected (%v), got (%v)", false, ok)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if ok, _ := store.Claim(ctx, "key", time.Millisecond); !ok {
+		t.Errorf("expected (%v), got (%v)", true, ok)
+	}
+}
+
+func TestStale(t *testing.T) {
+	var stale []bool
+	handler := webhook.Dedup(webhook.NewMemoryStore(), webhook.HandlerFunc(func(ctx context.Context, w *webhook.Webhook) error {
+		stale = append(stale, webhook.Stale(ctx))
+		return nil
+	}), webhook.WithOrdering(webhook.NewMemoryStore(), func(w *webhook.Webhook) string {
+		return w.ShopDomain + "/orders/1"
+	}))
+
+	base := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	for i, triggeredAt := range []time.Time{base.Add(time.Second), base, base.Add(2 * time.Second)} {
+		w := &webhook.Webhook{
+			RawTopic:    "orders/updated",
+			ShopDomain:  "example.myshopify.com",
+			EventID:     string(rune('a' + i)),
+			TriggeredAt: triggeredAt,
+		}
+		if err := handler.ServeWebhook(context.Background(), w); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expected := []bool{false, true, false}
+	for i := range expected {
+		if stale[i] != expected[i] {
+			t.Errorf("expected (%v), got (%v)", expected, stale)
+			break
+		}
+	}
+}
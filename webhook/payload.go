@@ -0,0 +1,300 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+)
+
+// ErrUnknownTopic is returned by Decode for the topics without a payload type
+var ErrUnknownTopic = errors.New("webhook: unknown topic")
+
+// GID returns the GraphQL ID of the REST resource of type resource with the numeric id, e.g. "gid://shopify/Order/1".
+func GID(resource string, id int64) string {
+	if id == 0 {
+		return ""
+	}
+	return "gid://shopify/" + resource + "/" + strconv.FormatInt(id, 10)
+}
+
+// payloadTypes returns a new payload of each topic
+var payloadTypes = map[model.WebhookSubscriptionTopic]func() any{
+	model.WebhookSubscriptionTopicOrdersCreate:           func() any { return &Order{} },
+	model.WebhookSubscriptionTopicProductsUpdate:         func() any { return &Product{} },
+	model.WebhookSubscriptionTopicAppUninstalled:         func() any { return &Shop{} },
+	model.WebhookSubscriptionTopicAppSubscriptionsUpdate: func() any { return &AppSubscriptionsUpdate{} },
+	model.WebhookSubscriptionTopicBulkOperationsFinish:   func() any { return &BulkOperationsFinish{} },
+	model.WebhookSubscriptionTopicCustomersDataRequest:   func() any { return &CustomersDataRequest{} },
+	model.WebhookSubscriptionTopicCustomersRedact:        func() any { return &CustomersRedact{} },
+	model.WebhookSubscriptionTopicShopRedact:             func() any { return &ShopRedact{} },
+}
+
+// Decode decodes the payload of w into the payload type of its topic, e.g. *Order for ORDERS_CREATE,
+// with the GraphQL IDs set from the numeric IDs.
+func Decode(w *Webhook) (any, error) {
+	newPayload, ok := payloadTypes[w.Topic]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTopic, w.RawTopic)
+	}
+	payload := newPayload()
+	if err := decode(w.Body, payload); err != nil {
+		return nil, fmt.Errorf("decode %s payload: %w", w.RawTopic, err)
+	}
+	return payload, nil
+}
+
+// normalizer is implemented by the payloads setting their GraphQL IDs from their numeric IDs once decoded
+type normalizer interface {
+	normalize()
+}
+
+func decode(body []byte, payload any) error {
+	if err := json.Unmarshal(body, payload); err != nil {
+		return err
+	}
+	if n, ok := payload.(normalizer); ok {
+		n.normalize()
+	}
+	return nil
+}
+
+// Order is the payload of orders/create.
+type Order struct {
+	// ID is the GraphQL ID, as returned by OrderService.Get
+	ID               string          `json:"admin_graphql_api_id"`
+	LegacyResourceID int64           `json:"id"`
+	Name             string          `json:"name"`
+	Email            string          `json:"email"`
+	Phone            string          `json:"phone"`
+	Currency         string          `json:"currency"`
+	TotalPrice       string          `json:"total_price"`
+	SubtotalPrice    string          `json:"subtotal_price"`
+	TotalTax         string          `json:"total_tax"`
+	FinancialStatus  string          `json:"financial_status"`
+	Tags             string          `json:"tags"`
+	Note             string          `json:"note"`
+	Test             bool            `json:"test"`
+	Customer         *Customer       `json:"customer"`
+	LineItems        []OrderLineItem `json:"line_items"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+}
+
+func (o *Order) normalize() {
+	o.ID = gidOr(o.ID, "Order", o.LegacyResourceID)
+	if o.Customer != nil {
+		o.Customer.normalize()
+	}
+	for i := range o.LineItems {
+		o.LineItems[i].normalize()
+	}
+}
+
+// OrderLineItem is a line item of an Order.
+type OrderLineItem struct {
+	ID               string `json:"admin_graphql_api_id"`
+	LegacyResourceID int64  `json:"id"`
+	// ProductID and VariantID are the GraphQL IDs of the product and variant, empty for custom line items
+	ProductID       string `json:"-"`
+	LegacyProductID int64  `json:"product_id"`
+	VariantID       string `json:"-"`
+	LegacyVariantID int64  `json:"variant_id"`
+	Title           string `json:"title"`
+	VariantTitle    string `json:"variant_title"`
+	SKU             string `json:"sku"`
+	Vendor          string `json:"vendor"`
+	Quantity        int    `json:"quantity"`
+	Price           string `json:"price"`
+}
+
+func (l *OrderLineItem) normalize() {
+	l.ID = gidOr(l.ID, "LineItem", l.LegacyResourceID)
+	l.ProductID = GID("Product", l.LegacyProductID)
+	l.VariantID = GID("ProductVariant", l.LegacyVariantID)
+}
+
+// Customer is the customer of an Order, or of a privacy webhook.
+type Customer struct {
+	ID               string `json:"admin_graphql_api_id"`
+	LegacyResourceID int64  `json:"id"`
+	Email            string `json:"email"`
+	Phone            string `json:"phone"`
+	FirstName        string `json:"first_name"`
+	LastName         string `json:"last_name"`
+}
+
+func (c *Customer) normalize() {
+	c.ID = gidOr(c.ID, "Customer", c.LegacyResourceID)
+}
+
+// Product is the payload of products/update.
+type Product struct {
+	// ID is the GraphQL ID, as returned by ProductService.Get
+	ID               string           `json:"admin_graphql_api_id"`
+	LegacyResourceID int64            `json:"id"`
+	Title            string           `json:"title"`
+	Handle           string           `json:"handle"`
+	BodyHTML         string           `json:"body_html"`
+	Vendor           string           `json:"vendor"`
+	ProductType      string           `json:"product_type"`
+	Status           string           `json:"status"`
+	Tags             string           `json:"tags"`
+	Variants         []ProductVariant `json:"variants"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+	PublishedAt      *time.Time       `json:"published_at"`
+}
+
+func (p *Product) normalize() {
+	p.ID = gidOr(p.ID, "Product", p.LegacyResourceID)
+	for i := range p.Variants {
+		p.Variants[i].normalize()
+	}
+}
+
+// ProductVariant is a variant of a Product.
+type ProductVariant struct {
+	ID                string    `json:"admin_graphql_api_id"`
+	LegacyResourceID  int64     `json:"id"`
+	ProductID         string    `json:"-"`
+	LegacyProductID   int64     `json:"product_id"`
+	Title             string    `json:"title"`
+	SKU               string    `json:"sku"`
+	Price             string    `json:"price"`
+	CompareAtPrice    *string   `json:"compare_at_price"`
+	Position          int       `json:"position"`
+	InventoryQuantity int       `json:"inventory_quantity"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+func (v *ProductVariant) normalize() {
+	v.ID = gidOr(v.ID, "ProductVariant", v.LegacyResourceID)
+	v.ProductID = GID("Product", v.LegacyProductID)
+}
+
+// Shop is the payload of app/uninstalled.
+type Shop struct {
+	ID               string `json:"-"`
+	LegacyResourceID int64  `json:"id"`
+	Name             string `json:"name"`
+	Email            string `json:"email"`
+	Domain           string `json:"domain"`
+	MyshopifyDomain  string `json:"myshopify_domain"`
+	PlanName         string `json:"plan_name"`
+	Currency         string `json:"currency"`
+}
+
+func (s *Shop) normalize() {
+	s.ID = GID("Shop", s.LegacyResourceID)
+}
+
+// AppSubscriptionsUpdate is the payload of app_subscriptions/update.
+type AppSubscriptionsUpdate struct {
+	AppSubscription AppSubscription `json:"app_subscription"`
+}
+
+// AppSubscription is the subscription of an AppSubscriptionsUpdate.
+type AppSubscription struct {
+	// ID is the GraphQL ID, as returned by BillingService
+	ID     string `json:"admin_graphql_api_id"`
+	ShopID string `json:"admin_graphql_api_shop_id"`
+	Name   string `json:"name"`
+	// Status is upper-cased to match model.AppSubscriptionStatus, e.g. ACTIVE
+	Status       string    `json:"status"`
+	Currency     string    `json:"currency"`
+	CappedAmount string    `json:"capped_amount"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (u *AppSubscriptionsUpdate) normalize() {
+	u.AppSubscription.Status = strings.ToUpper(u.AppSubscription.Status)
+}
+
+// BulkOperationsFinish is the payload of bulk_operations/finish.
+type BulkOperationsFinish struct {
+	// ID is the GraphQL ID, as returned by BulkOperationService
+	ID          string                        `json:"admin_graphql_api_id"`
+	Status      model.BulkOperationStatus     `json:"status"`
+	Type        model.BulkOperationType       `json:"type"`
+	ErrorCode   *model.BulkOperationErrorCode `json:"error_code"`
+	CreatedAt   time.Time                     `json:"created_at"`
+	CompletedAt *time.Time                    `json:"completed_at"`
+}
+
+// normalize upper-cases the enums, which the payload has in lower case, e.g. "completed"
+func (b *BulkOperationsFinish) normalize() {
+	b.Status = model.BulkOperationStatus(strings.ToUpper(string(b.Status)))
+	b.Type = model.BulkOperationType(strings.ToUpper(string(b.Type)))
+	if b.ErrorCode != nil {
+		code := model.BulkOperationErrorCode(strings.ToUpper(string(*b.ErrorCode)))
+		b.ErrorCode = &code
+	}
+}
+
+// CustomersDataRequest is the payload of customers/data_request.
+type CustomersDataRequest struct {
+	ShopID          string   `json:"-"`
+	LegacyShopID    int64    `json:"shop_id"`
+	ShopDomain      string   `json:"shop_domain"`
+	Customer        Customer `json:"customer"`
+	OrderIDs        []string `json:"-"`
+	OrdersRequested []int64  `json:"orders_requested"`
+	DataRequest     struct {
+		ID int64 `json:"id"`
+	} `json:"data_request"`
+}
+
+func (r *CustomersDataRequest) normalize() {
+	r.ShopID = GID("Shop", r.LegacyShopID)
+	r.Customer.normalize()
+	r.OrderIDs = orderIDs(r.OrdersRequested)
+}
+
+// CustomersRedact is the payload of customers/redact.
+type CustomersRedact struct {
+	ShopID         string   `json:"-"`
+	LegacyShopID   int64    `json:"shop_id"`
+	ShopDomain     string   `json:"shop_domain"`
+	Customer       Customer `json:"customer"`
+	OrderIDs       []string `json:"-"`
+	OrdersToRedact []int64  `json:"orders_to_redact"`
+}
+
+func (r *CustomersRedact) normalize() {
+	r.ShopID = GID("Shop", r.LegacyShopID)
+	r.Customer.normalize()
+	r.OrderIDs = orderIDs(r.OrdersToRedact)
+}
+
+// ShopRedact is the payload of shop/redact.
+type ShopRedact struct {
+	ShopID       string `json:"-"`
+	LegacyShopID int64  `json:"shop_id"`
+	ShopDomain   string `json:"shop_domain"`
+}
+
+func (r *ShopRedact) normalize() {
+	r.ShopID = GID("Shop", r.LegacyShopID)
+}
+
+func gidOr(gid, resource string, id int64) string {
+	if gid != "" {
+		return gid
+	}
+	return GID(resource, id)
+}
+
+func orderIDs(ids []int64) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, GID("Order", id))
+	}
+	return out
+}
@@ -0,0 +1,107 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+
+	"github.com/gempages/go-shopify-graphql/webhook"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		topic    string
+		body     string
+		expected func(t *testing.T, payload any)
+	}{
+		{"orders/create", `{"id":820982911946154508,"name":"#1001","customer":{"id":115310627314723954},"line_items":[{"id":866550311766439020,"product_id":632910392,"variant_id":808950810}]}`, func(t *testing.T, payload any) {
+			order := payload.(*webhook.Order)
+			if order.ID != "gid://shopify/Order/820982911946154508" || order.Name != "#1001" {
+				t.Errorf("expected (%v), got (%v)", "gid://shopify/Order/820982911946154508", order.ID)
+			}
+			if order.Customer.ID != "gid://shopify/Customer/115310627314723954" {
+				t.Errorf("expected (%v), got (%v)", "gid://shopify/Customer/115310627314723954", order.Customer.ID)
+			}
+			item := order.LineItems[0]
+			if item.ID != "gid://shopify/LineItem/866550311766439020" || item.ProductID != "gid://shopify/Product/632910392" || item.VariantID != "gid://shopify/ProductVariant/808950810" {
+				t.Errorf("unexpected line item IDs (%v, %v, %v)", item.ID, item.ProductID, item.VariantID)
+			}
+		}},
+		{"products/update", `{"admin_graphql_api_id":"gid://shopify/Product/788032119674292922","id":788032119674292922,"title":"Example T-Shirt","variants":[{"id":642667041472713922,"product_id":788032119674292922}]}`, func(t *testing.T, payload any) {
+			product := payload.(*webhook.Product)
+			if product.ID != "gid://shopify/Product/788032119674292922" {
+				t.Errorf("expected (%v), got (%v)", "gid://shopify/Product/788032119674292922", product.ID)
+			}
+			if product.Variants[0].ID != "gid://shopify/ProductVariant/642667041472713922" || product.Variants[0].ProductID != product.ID {
+				t.Errorf("unexpected variant IDs (%v, %v)", product.Variants[0].ID, product.Variants[0].ProductID)
+			}
+		}},
+		{"app/uninstalled", `{"id":548380009,"myshopify_domain":"example.myshopify.com"}`, func(t *testing.T, payload any) {
+			if shop := payload.(*webhook.Shop); shop.ID != "gid://shopify/Shop/548380009" {
+				t.Errorf("expected (%v), got (%v)", "gid://shopify/Shop/548380009", shop.ID)
+			}
+		}},
+		{"app_subscriptions/update", `{"app_subscription":{"admin_graphql_api_id":"gid://shopify/AppSubscription/1029266947","status":"active"}}`, func(t *testing.T, payload any) {
+			if sub := payload.(*webhook.AppSubscriptionsUpdate).AppSubscription; sub.Status != "ACTIVE" {
+				t.Errorf("expected (%v), got (%v)", "ACTIVE", sub.Status)
+			}
+		}},
+		{"bulk_operations/finish", `{"admin_graphql_api_id":"gid://shopify/BulkOperation/147595010","completed_at":"2024-01-28T19:10:59-05:00","error_code":"access_denied","status":"failed","type":"query"}`, func(t *testing.T, payload any) {
+			op := payload.(*webhook.BulkOperationsFinish)
+			if op.Status != model.BulkOperationStatusFailed || op.Type != model.BulkOperationTypeQuery || *op.ErrorCode != model.BulkOperationErrorCodeAccessDenied {
+				t.Errorf("unexpected enums (%v, %v, %v)", op.Status, op.Type, *op.ErrorCode)
+			}
+		}},
+		{"customers/data_request", `{"shop_id":954889,"shop_domain":"example.myshopify.com","orders_requested":[299938,280263],"customer":{"id":191167},"data_request":{"id":9999}}`, func(t *testing.T, payload any) {
+			req := payload.(*webhook.CustomersDataRequest)
+			if req.ShopID != "gid://shopify/Shop/954889" || req.Customer.ID != "gid://shopify/Customer/191167" || req.DataRequest.ID != 9999 {
+				t.Errorf("unexpected IDs (%v, %v, %v)", req.ShopID, req.Customer.ID, req.DataRequest.ID)
+			}
+			if len(req.OrderIDs) != 2 || req.OrderIDs[1] != "gid://shopify/Order/280263" {
+				t.Errorf("expected (%v), got (%v)", "gid://shopify/Order/280263", req.OrderIDs)
+			}
+		}},
+		{"customers/redact", `{"shop_id":954889,"customer":{"id":191167},"orders_to_redact":[299938]}`, func(t *testing.T, payload any) {
+			if req := payload.(*webhook.CustomersRedact); len(req.OrderIDs) != 1 || req.OrderIDs[0] != "gid://shopify/Order/299938" {
+				t.Errorf("expected (%v), got (%v)", "gid://shopify/Order/299938", req.OrderIDs)
+			}
+		}},
+		{"shop/redact", `{"shop_id":954889,"shop_domain":"example.myshopify.com"}`, func(t *testing.T, payload any) {
+			if req := payload.(*webhook.ShopRedact); req.ShopID != "gid://shopify/Shop/954889" {
+				t.Errorf("expected (%v), got (%v)", "gid://shopify/Shop/954889", req.ShopID)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			payload, err := webhook.Decode(&webhook.Webhook{Topic: webhook.TopicFromHeader(tt.topic), RawTopic: tt.topic, Body: []byte(tt.body)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.expected(t, payload)
+		})
+	}
+
+	_, err := webhook.Decode(&webhook.Webhook{Topic: "CARTS_CREATE", RawTopic: "carts/create", Body: []byte(`{}`)})
+	if !errors.Is(err, webhook.ErrUnknownTopic) {
+		t.Errorf("expected (%v), got (%v)", webhook.ErrUnknownTopic, err)
+	}
+}
+
+func TestTypedPayload(t *testing.T) {
+	var id string
+	r := webhook.NewReceiver(secret)
+	r.Handle(model.WebhookSubscriptionTopicOrdersCreate, webhook.Typed(func(ctx context.Context, w *webhook.Webhook, order *webhook.Order) error {
+		id = order.ID
+		return nil
+	}))
+	if code := serve(r, newRequest("orders/create", `{"id":1}`, secret)); code != http.StatusOK {
+		t.Fatalf("expected (%v), got (%v)", http.StatusOK, code)
+	}
+	if id != "gid://shopify/Order/1" {
+		t.Errorf("expected (%v), got (%v)", "gid://shopify/Order/1", id)
+	}
+}
@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+)
+
+// DefaultMaxBodySize is the default maximum size of the payloads
+const DefaultMaxBodySize = 10 << 20
+
+// Handler handles the webhooks of a topic. A nil error acknowledges the webhook.
+// Any other error but a permanent one makes Shopify deliver the webhook again later, see Permanent.
+type Handler interface {
+	ServeWebhook(ctx context.Context, w *Webhook) error
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(ctx context.Context, w *Webhook) error
+
+func (f HandlerFunc) ServeWebhook(ctx context.Context, w *Webhook) error {
+	return f(ctx, w)
+}
+
+// Typed returns a handler decoding the JSON payload into a T before calling f, e.g. an Order or a *Order.
+// The GraphQL IDs of the payload types of this package are set from the numeric IDs, like Decode does.
+// A payload that can't be decoded is acknowledged and logged, see Permanent.
+func Typed[T any](f func(ctx context.Context, w *Webhook, payload T) error) Handler {
+	return HandlerFunc(func(ctx context.Context, w *Webhook) error {
+		var payload T
+		if err := decode(w.Body, &payload); err != nil {
+			return Permanent(fmt.Errorf("decode %s payload: %w", w.RawTopic, err))
+		}
+		// T is a pointer to a payload type
+		if n, ok := any(payload).(normalizer); ok {
+			n.normalize()
+		}
+		return f(ctx, w, payload)
+	})
+}
+
+// permanentError is an error retrying the delivery wouldn't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error that retrying the delivery wouldn't fix, such as a malformed payload.
+// The webhook is acknowledged, so that Shopify doesn't deliver it again, and the error is logged.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped by Permanent.
+func IsPermanent(err error) bool {
+	var perr *permanentError
+	return errors.As(err, &perr)
+}
+
+// Option is used to configure a Receiver
+type Option func(r *Receiver)
+
+// WithSecrets optionally adds secrets the HMAC can be signed with, e.g. the previous secret while rotating it.
+func WithSecrets(secrets ...string) Option {
+	return func(r *Receiver) {
+		r.secrets = append(r.secrets, secrets...)
+	}
+}
+
+// WithMaxBodySize optionally sets the maximum size of the payloads, larger ones being rejected. Defaults to DefaultMaxBodySize.
+func WithMaxBodySize(size int64) Option {
+	return func(r *Receiver) {
+		r.maxBodySize = size
+	}
+}
+
+// WithLogger optionally sets the logger of the receiver. Defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Receiver) {
+		r.logger = logger
+	}
+}
+
+// Receiver is an http.Handler receiving the webhooks and routing them to the handler of their topic.
+//
+// It answers:
+//   - 405 Method Not Allowed to requests other than POST
+//   - 413 Request Entity Too Large to payloads larger than the maximum body size
+//   - 401 Unauthorized to webhooks whose HMAC is invalid
+//   - 400 Bad Request to webhooks missing the topic or shop domain header
+//   - 200 OK to webhooks of topics without a handler, and once the handler succeeds or returns a permanent error
+//   - 500 Internal Server Error when the handler fails, so that Shopify delivers the webhook again
+//
+// A Receiver is safe for concurrent use.
+type Receiver struct {
+	secrets     []string
+	maxBodySize int64
+	logger      *slog.Logger
+
+	mu       sync.RWMutex
+	handlers map[model.WebhookSubscriptionTopic]Handler
+	fallback Handler
+}
+
+// NewReceiver returns a receiver verifying the HMAC of the webhooks with secret, the API secret key of the app.
+func NewReceiver(secret string, opts ...Option) *Receiver {
+	r := &Receiver{
+		secrets:     []string{secret},
+		maxBodySize: DefaultMaxBodySize,
+		logger:      slog.Default(),
+		handlers:    make(map[model.WebhookSubscriptionTopic]Handler),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Handle registers the handler of topic, replacing the previous one, if any.
+func (r *Receiver) Handle(topic model.WebhookSubscriptionTopic, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[topic] = handler
+}
+
+// HandleFunc registers the handler function of topic.
+func (r *Receiver) HandleFunc(topic model.WebhookSubscriptionTopic, handler func(ctx context.Context, w *Webhook) error) {
+	r.Handle(topic, HandlerFunc(handler))
+}
+
+// HandleDefault registers the handler of the topics without a handler.
+func (r *Receiver) HandleDefault(handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = handler
+}
+
+func (r *Receiver) handler(topic model.WebhookSubscriptionTopic) Handler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if handler, ok := r.handlers[topic]; ok {
+		return handler
+	}
+	return r.fallback
+}
+
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, r.maxBodySize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	hook, err := Parse(req.Header, body, r.secrets...)
+	if errors.Is(err, ErrInvalidHMAC) {
+		r.logger.WarnContext(req.Context(), "Rejected webhook with invalid HMAC", "topic", req.Header.Get(HeaderTopic), "shop", req.Header.Get(HeaderShopDomain))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logger := r.logger.With("topic", hook.RawTopic, "shop", hook.ShopDomain, "webhook_id", hook.WebhookID)
+	handler := r.handler(hook.Topic)
+	if handler == nil {
+		logger.DebugContext(req.Context(), "Acknowledged webhook without handler")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	err = handler.ServeWebhook(req.Context(), hook)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusOK)
+	case IsPermanent(err):
+		logger.WarnContext(req.Context(), "Dropped webhook", "error", err)
+		w.WriteHeader(http.StatusOK)
+	default:
+		logger.ErrorContext(req.Context(), "Couldn't handle webhook", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,136 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+
+	"github.com/gempages/go-shopify-graphql/webhook"
+)
+
+const secret = "api-secret"
+
+func sign(body, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func newRequest(topic, body, secret string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+	req.Header.Set(webhook.HeaderHmac, sign(body, secret))
+	req.Header.Set(webhook.HeaderTopic, topic)
+	req.Header.Set(webhook.HeaderShopDomain, "example.myshopify.com")
+	req.Header.Set(webhook.HeaderWebhookID, "b54557e4-bdd9-4b37-8a5f-bf7d70bcd043")
+	req.Header.Set(webhook.HeaderEventID, "98880550-7158-44d4-b7cd-2c97c8a091b5")
+	req.Header.Set(webhook.HeaderAPIVersion, "2024-10")
+	req.Header.Set(webhook.HeaderTriggeredAt, "2024-10-01T12:00:00.123Z")
+	return req
+}
+
+func serve(r http.Handler, req *http.Request) int {
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestReceiver(t *testing.T) {
+	type order struct {
+		ID int64 `json:"id"`
+	}
+	var got *webhook.Webhook
+	var gotOrder order
+	r := webhook.NewReceiver(secret,
+		webhook.WithSecrets("previous-secret"),
+		webhook.WithMaxBodySize(64),
+		webhook.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+	)
+	r.Handle(model.WebhookSubscriptionTopicOrdersCreate, webhook.Typed(func(ctx context.Context, w *webhook.Webhook, o order) error {
+		got, gotOrder = w, o
+		return nil
+	}))
+	r.HandleFunc(model.WebhookSubscriptionTopicAppUninstalled, func(ctx context.Context, w *webhook.Webhook) error {
+		if strings.Contains(string(w.Body), "fail") {
+			return errors.New("database unavailable")
+		}
+		return webhook.Permanent(errors.New("unknown shop"))
+	})
+
+	if code := serve(r, newRequest("orders/create", `{"id":820982911946154508}`, secret)); code != http.StatusOK {
+		t.Fatalf("expected (%v), got (%v)", http.StatusOK, code)
+	}
+	if got == nil {
+		t.Fatal("expected the handler of orders/create to be called")
+	}
+	expected := webhook.Webhook{
+		Topic:       model.WebhookSubscriptionTopicOrdersCreate,
+		RawTopic:    "orders/create",
+		ShopDomain:  "example.myshopify.com",
+		WebhookID:   "b54557e4-bdd9-4b37-8a5f-bf7d70bcd043",
+		EventID:     "98880550-7158-44d4-b7cd-2c97c8a091b5",
+		APIVersion:  "2024-10",
+		TriggeredAt: time.Date(2024, 10, 1, 12, 0, 0, 123000000, time.UTC),
+	}
+	if got.Topic != expected.Topic || got.RawTopic != expected.RawTopic || got.ShopDomain != expected.ShopDomain ||
+		got.WebhookID != expected.WebhookID || got.EventID != expected.EventID || got.APIVersion != expected.APIVersion ||
+		!got.TriggeredAt.Equal(expected.TriggeredAt) {
+		t.Errorf("expected (%+v), got (%+v)", expected, *got)
+	}
+	if gotOrder.ID != 820982911946154508 {
+		t.Errorf("expected (%v), got (%v)", 820982911946154508, gotOrder.ID)
+	}
+
+	missingTopic := newRequest("orders/create", `{}`, secret)
+	missingTopic.Header.Del(webhook.HeaderTopic)
+	get := newRequest("orders/create", `{}`, secret)
+	get.Method = http.MethodGet
+
+	tests := []struct {
+		name     string
+		req      *http.Request
+		expected int
+	}{
+		{"previous secret", newRequest("orders/create", `{"id":1}`, "previous-secret"), http.StatusOK},
+		{"invalid HMAC", newRequest("orders/create", `{"id":1}`, "other"), http.StatusUnauthorized},
+		{"missing topic", missingTopic, http.StatusBadRequest},
+		{"not POST", get, http.StatusMethodNotAllowed},
+		{"body too large", newRequest("orders/create", `{"id":1,"note":"`+strings.Repeat("a", 64)+`"}`, secret), http.StatusRequestEntityTooLarge},
+		{"topic without handler", newRequest("products/update", `{}`, secret), http.StatusOK},
+		{"malformed payload", newRequest("orders/create", `{"id":"abc"}`, secret), http.StatusOK},
+		{"handler error", newRequest("app/uninstalled", `{"fail":true}`, secret), http.StatusInternalServerError},
+		{"permanent handler error", newRequest("app/uninstalled", `{}`, secret), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := serve(r, tt.req); code != tt.expected {
+				t.Errorf("expected (%v), got (%v)", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestTopicFromHeader(t *testing.T) {
+	tests := map[string]model.WebhookSubscriptionTopic{
+		"orders/create":            model.WebhookSubscriptionTopicOrdersCreate,
+		"app/uninstalled":          model.WebhookSubscriptionTopicAppUninstalled,
+		"bulk_operations/finish":   model.WebhookSubscriptionTopicBulkOperationsFinish,
+		"app_subscriptions/update": model.WebhookSubscriptionTopicAppSubscriptionsUpdate,
+		"customers/data_request":   model.WebhookSubscriptionTopicCustomersDataRequest,
+	}
+	for header, expected := range tests {
+		if got := webhook.TopicFromHeader(header); got != expected {
+			t.Errorf("expected (%v), got (%v)", expected, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Package webhook receives the webhooks Shopify delivers over HTTP: it verifies their HMAC signature,
+// parses their headers and routes each topic to a registered handler.
+//
+//	receiver := webhook.NewReceiver(apiSecret)
+//	receiver.HandleFunc(model.WebhookSubscriptionTopicAppUninstalled, func(ctx context.Context, w *webhook.Webhook) error {
+//		return uninstall(ctx, w.ShopDomain)
+//	})
+//	mux.Handle("/webhooks", receiver)
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+)
+
+// Header names
+const (
+	HeaderHmac        = "X-Shopify-Hmac-Sha256"
+	HeaderTopic       = "X-Shopify-Topic"
+	HeaderShopDomain  = "X-Shopify-Shop-Domain"
+	HeaderWebhookID   = "X-Shopify-Webhook-Id"
+	HeaderEventID     = "X-Shopify-Event-Id"
+	HeaderAPIVersion  = "X-Shopify-API-Version"
+	HeaderTriggeredAt = "X-Shopify-Triggered-At"
+)
+
+var (
+	ErrInvalidHMAC   = errors.New("webhook: invalid HMAC")
+	ErrMissingHeader = errors.New("webhook: missing header")
+)
+
+// Webhook is a webhook delivery.
+type Webhook struct {
+	// Topic is the topic of the webhook, e.g. ORDERS_CREATE for the "orders/create" header
+	Topic model.WebhookSubscriptionTopic
+	// RawTopic is the value of the topic header, e.g. "orders/create"
+	RawTopic   string
+	ShopDomain string
+	// WebhookID identifies the delivery, it is the same for every retry of a delivery
+	WebhookID string
+	// EventID identifies the event, it is the same for every webhook of an event, e.g. across subscriptions
+	EventID    string
+	APIVersion string
+	// TriggeredAt is the time of the event, the zero time when the header is missing
+	TriggeredAt time.Time
+	// Body is the JSON payload
+	Body []byte
+}
+
+// TopicFromHeader converts the value of the topic header, such as "orders/create", into the GraphQL enum value,
+// such as ORDERS_CREATE.
+func TopicFromHeader(topic string) model.WebhookSubscriptionTopic {
+	return model.WebhookSubscriptionTopic(strings.ToUpper(strings.NewReplacer("/", "_", "-", "_").Replace(topic)))
+}
+
+// ValidHMAC reports whether signature, the base64 value of the HMAC header, is the HMAC-SHA256 of body with secret.
+// The comparison runs in constant time.
+func ValidHMAC(body []byte, signature, secret string) bool {
+	expected, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(expected, mac.Sum(nil))
+}
+
+// Parse verifies the HMAC of body with one of the secrets and returns the webhook described by header.
+func Parse(header http.Header, body []byte, secrets ...string) (*Webhook, error) {
+	signature := header.Get(HeaderHmac)
+	valid := false
+	for _, secret := range secrets {
+		if ValidHMAC(body, signature, secret) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, ErrInvalidHMAC
+	}
+
+	w := &Webhook{
+		RawTopic:   header.Get(HeaderTopic),
+		ShopDomain: header.Get(HeaderShopDomain),
+		WebhookID:  header.Get(HeaderWebhookID),
+		EventID:    header.Get(HeaderEventID),
+		APIVersion: header.Get(HeaderAPIVersion),
+		Body:       body,
+	}
+	if w.RawTopic == "" {
+		return nil, fmt.Errorf("%w: %s", ErrMissingHeader, HeaderTopic)
+	}
+	if w.ShopDomain == "" {
+		return nil, fmt.Errorf("%w: %s", ErrMissingHeader, HeaderShopDomain)
+	}
+	w.Topic = TopicFromHeader(w.RawTopic)
+	if triggeredAt := header.Get(HeaderTriggeredAt); triggeredAt != "" {
+		// Best effort, the time is only a hint
+		w.TriggeredAt, _ = time.Parse(time.RFC3339Nano, triggeredAt)
+	}
+	return w, nil
+}
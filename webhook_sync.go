@@ -0,0 +1,379 @@
+package shopify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/gempages/go-shopify-graphql-model/graph/model"
+)
+
+// ErrInvalidWebhookSpec is returned by PlanSync and Sync when a spec doesn't have exactly one endpoint,
+// or when two specs have the same topic and endpoint
+var ErrInvalidWebhookSpec = errors.New("invalid webhook spec")
+
+// WebhookSpec is a webhook subscription the app wants, delivered to exactly one endpoint:
+// an HTTP callback URL, an EventBridge ARN or a Pub/Sub topic.
+type WebhookSpec struct {
+	Topic model.WebhookSubscriptionTopic
+	// CallbackURL is the URL of an HTTP endpoint
+	CallbackURL string
+	// Arn is the ARN of an EventBridge partner event source
+	Arn string
+	// PubSubProject and PubSubTopic are the Google Cloud project and Pub/Sub topic
+	PubSubProject string
+	PubSubTopic   string
+	// Format defaults to JSON
+	Format              model.WebhookSubscriptionFormat
+	IncludeFields       []string
+	MetafieldNamespaces []string
+	// Filter is a search query the payloads must match, e.g. "status:active"
+	Filter string
+}
+
+type webhookEndpointKind int
+
+const (
+	webhookEndpointHTTP webhookEndpointKind = iota + 1
+	webhookEndpointEventBridge
+	webhookEndpointPubSub
+)
+
+// webhookEndpoint identifies the endpoint a subscription is delivered to
+type webhookEndpoint struct {
+	kind    webhookEndpointKind
+	address string
+}
+
+func (s *WebhookSpec) endpoint() (webhookEndpoint, error) {
+	var endpoints []webhookEndpoint
+	if s.CallbackURL != "" {
+		endpoints = append(endpoints, webhookEndpoint{webhookEndpointHTTP, s.CallbackURL})
+	}
+	if s.Arn != "" {
+		endpoints = append(endpoints, webhookEndpoint{webhookEndpointEventBridge, s.Arn})
+	}
+	if s.PubSubProject != "" || s.PubSubTopic != "" {
+		endpoints = append(endpoints, webhookEndpoint{webhookEndpointPubSub, s.PubSubProject + ":" + s.PubSubTopic})
+	}
+	if len(endpoints) != 1 {
+		return webhookEndpoint{}, fmt.Errorf("%w: %s must have exactly one endpoint", ErrInvalidWebhookSpec, s.Topic)
+	}
+	return endpoints[0], nil
+}
+
+func (s *WebhookSpec) format() model.WebhookSubscriptionFormat {
+	if s.Format == "" {
+		return model.WebhookSubscriptionFormatJSON
+	}
+	return s.Format
+}
+
+// clears reports whether updating sub to the spec clears its include fields or metafield namespaces,
+// which the model inputs can't do since they omit the empty lists
+func (s *WebhookSpec) clears(sub *model.WebhookSubscription) bool {
+	return len(s.IncludeFields) == 0 && len(sub.IncludeFields) > 0 ||
+		len(s.MetafieldNamespaces) == 0 && len(sub.MetafieldNamespaces) > 0
+}
+
+// matches reports whether the subscription has the settings of the spec, its endpoint aside
+func (s *WebhookSpec) matches(sub *model.WebhookSubscription) bool {
+	filter := ""
+	if sub.Filter != nil {
+		filter = *sub.Filter
+	}
+	return sub.Format == s.format() &&
+		filter == s.Filter &&
+		sameSet(sub.IncludeFields, s.IncludeFields) &&
+		sameSet(sub.MetafieldNamespaces, s.MetafieldNamespaces)
+}
+
+func subscriptionEndpoint(sub *model.WebhookSubscription) webhookEndpoint {
+	switch e := sub.Endpoint.(type) {
+	case *model.WebhookHTTPEndpoint:
+		return webhookEndpoint{webhookEndpointHTTP, e.CallbackURL}
+	case *model.WebhookEventBridgeEndpoint:
+		return webhookEndpoint{webhookEndpointEventBridge, e.Arn}
+	case *model.WebhookPubSubEndpoint:
+		return webhookEndpoint{webhookEndpointPubSub, e.PubSubProject + ":" + e.PubSubTopic}
+	}
+	return webhookEndpoint{address: sub.CallbackURL}
+}
+
+// WebhookSyncAction is what Sync does to a webhook subscription
+type WebhookSyncAction string
+
+const (
+	WebhookSyncCreate WebhookSyncAction = "CREATE"
+	WebhookSyncUpdate WebhookSyncAction = "UPDATE"
+	WebhookSyncDelete WebhookSyncAction = "DELETE"
+)
+
+// WebhookSyncChange is a change of a WebhookSyncPlan.
+type WebhookSyncChange struct {
+	Action WebhookSyncAction
+	// Spec is the wanted subscription, nil for deletions
+	Spec *WebhookSpec
+	// Subscription is the existing subscription, nil for creations
+	Subscription *model.WebhookSubscription
+}
+
+func (c WebhookSyncChange) String() string {
+	switch c.Action {
+	case WebhookSyncCreate:
+		return fmt.Sprintf("create %s", c.Spec.Topic)
+	case WebhookSyncUpdate:
+		return fmt.Sprintf("update %s %s", c.Spec.Topic, c.Subscription.ID)
+	default:
+		return fmt.Sprintf("delete %s %s", c.Subscription.Topic, c.Subscription.ID)
+	}
+}
+
+// WebhookSyncPlan lists the changes turning the existing webhook subscriptions into the wanted ones.
+// Updates come first, then creations, then deletions, so that no event is missed while the plan is applied.
+type WebhookSyncPlan struct {
+	Changes []WebhookSyncChange
+	// Unchanged are the existing subscriptions matching a spec
+	Unchanged []*model.WebhookSubscription
+}
+
+// Empty reports whether the existing subscriptions are the wanted ones already.
+func (p *WebhookSyncPlan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// PlanSync compares the existing webhook subscriptions with desired and returns the changes Sync would apply,
+// without applying them, e.g. for a dry run.
+//
+// A subscription is kept when a spec has its topic and endpoint, and updated when their settings differ.
+// A subscription without such a spec is updated to the endpoint of a spec of the same topic and delivery method
+// when there is one, deleted otherwise. The remaining specs are created.
+func (w WebhookServiceOp) PlanSync(ctx context.Context, desired []WebhookSpec) (*WebhookSyncPlan, error) {
+	existing, err := w.ListWebhookSubscriptions(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("list webhook subscriptions: %w", err)
+	}
+	return planWebhookSync(existing, desired)
+}
+
+func planWebhookSync(existing []*model.WebhookSubscription, desired []WebhookSpec) (*WebhookSyncPlan, error) {
+	type key struct {
+		topic    model.WebhookSubscriptionTopic
+		endpoint webhookEndpoint
+	}
+	specs := make(map[key]*WebhookSpec, len(desired))
+	var unmatched []*WebhookSpec
+	for i := range desired {
+		spec := &desired[i]
+		endpoint, err := spec.endpoint()
+		if err != nil {
+			return nil, err
+		}
+		k := key{spec.Topic, endpoint}
+		if _, ok := specs[k]; ok {
+			return nil, fmt.Errorf("%w: duplicate %s to %s", ErrInvalidWebhookSpec, spec.Topic, endpoint.address)
+		}
+		specs[k] = spec
+		unmatched = append(unmatched, spec)
+	}
+
+	plan := &WebhookSyncPlan{}
+	var updates, deletes []WebhookSyncChange
+	var orphans []*model.WebhookSubscription
+	for _, sub := range existing {
+		spec, ok := specs[key{sub.Topic, subscriptionEndpoint(sub)}]
+		if !ok {
+			orphans = append(orphans, sub)
+			continue
+		}
+		// A spec matches one subscription only, duplicate subscriptions are deleted
+		delete(specs, key{sub.Topic, subscriptionEndpoint(sub)})
+		unmatched = slices.DeleteFunc(unmatched, func(s *WebhookSpec) bool { return s == spec })
+		if spec.matches(sub) {
+			plan.Unchanged = append(plan.Unchanged, sub)
+		} else {
+			updates = append(updates, WebhookSyncChange{Action: WebhookSyncUpdate, Spec: spec, Subscription: sub})
+		}
+	}
+
+	for _, sub := range orphans {
+		kind := subscriptionEndpoint(sub).kind
+		i := slices.IndexFunc(unmatched, func(s *WebhookSpec) bool {
+			endpoint, _ := s.endpoint()
+			return s.Topic == sub.Topic && endpoint.kind == kind
+		})
+		if kind == 0 || i < 0 {
+			deletes = append(deletes, WebhookSyncChange{Action: WebhookSyncDelete, Subscription: sub})
+			continue
+		}
+		updates = append(updates, WebhookSyncChange{Action: WebhookSyncUpdate, Spec: unmatched[i], Subscription: sub})
+		unmatched = slices.Delete(unmatched, i, i+1)
+	}
+
+	plan.Changes = append(plan.Changes, updates...)
+	for _, spec := range unmatched {
+		plan.Changes = append(plan.Changes, WebhookSyncChange{Action: WebhookSyncCreate, Spec: spec})
+	}
+	plan.Changes = append(plan.Changes, deletes...)
+	return plan, nil
+}
+
+// ApplySyncPlan applies the changes of plan in order, stopping at the first error.
+// A creation failing because the subscription exists already, e.g. created by a concurrent deploy, falls back
+// to an update of that subscription, which replaces the creation in plan.
+// An update clearing the include fields or metafield namespaces of a subscription recreates it instead,
+// since the model inputs omit the empty lists: its events are missed between the deletion and the creation.
+func (w WebhookServiceOp) ApplySyncPlan(ctx context.Context, plan *WebhookSyncPlan) error {
+	for i := range plan.Changes {
+		change := &plan.Changes[i]
+		if err := w.applySyncChange(ctx, change); err != nil {
+			return fmt.Errorf("%s: %w", change, err)
+		}
+	}
+	return nil
+}
+
+func (w WebhookServiceOp) applySyncChange(ctx context.Context, change *WebhookSyncChange) error {
+	switch change.Action {
+	case WebhookSyncCreate:
+		_, err := w.createWebhookSubscription(ctx, change.Spec)
+		if !IsAddressTakenError(err) {
+			return err
+		}
+		existing, lerr := w.existingWebhookSubscription(ctx, change.Spec)
+		if lerr != nil {
+			return fmt.Errorf("%w, %w", err, lerr)
+		}
+		if existing == nil {
+			return err
+		}
+		change.Action, change.Subscription = WebhookSyncUpdate, existing
+		return w.applySyncChange(ctx, change)
+	case WebhookSyncUpdate:
+		if change.Spec.clears(change.Subscription) {
+			if _, err := w.DeleteWebhook(ctx, change.Subscription.ID); err != nil {
+				return err
+			}
+			_, err := w.createWebhookSubscription(ctx, change.Spec)
+			return err
+		}
+		_, err := w.updateWebhookSubscription(ctx, change.Subscription.ID, change.Spec)
+		return err
+	case WebhookSyncDelete:
+		_, err := w.DeleteWebhook(ctx, change.Subscription.ID)
+		return err
+	}
+	return nil
+}
+
+// Sync creates, updates and deletes the webhook subscriptions of the app so that they are the desired ones,
+// and returns the applied plan. See PlanSync.
+func (w WebhookServiceOp) Sync(ctx context.Context, desired []WebhookSpec) (*WebhookSyncPlan, error) {
+	plan, err := w.PlanSync(ctx, desired)
+	if err != nil {
+		return nil, err
+	}
+	return plan, w.ApplySyncPlan(ctx, plan)
+}
+
+// createWebhookSubscription creates the subscription of spec with the method of its delivery method.
+func (w WebhookServiceOp) createWebhookSubscription(ctx context.Context, spec *WebhookSpec) (*model.WebhookSubscription, error) {
+	endpoint, err := spec.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	// The filter is sent even if empty, so that updates clear it
+	format, filter := spec.format(), spec.Filter
+	switch endpoint.kind {
+	case webhookEndpointEventBridge:
+		return w.NewEventBridgeWebhookSubscription(ctx, spec.Topic, model.EventBridgeWebhookSubscriptionInput{
+			Arn:                 &spec.Arn,
+			Format:              &format,
+			Filter:              &filter,
+			IncludeFields:       spec.IncludeFields,
+			MetafieldNamespaces: spec.MetafieldNamespaces,
+		})
+	case webhookEndpointPubSub:
+		return w.NewPubSubWebhookSubscription(ctx, spec.Topic, model.PubSubWebhookSubscriptionInput{
+			PubSubProject:       spec.PubSubProject,
+			PubSubTopic:         spec.PubSubTopic,
+			Format:              &format,
+			Filter:              &filter,
+			IncludeFields:       spec.IncludeFields,
+			MetafieldNamespaces: spec.MetafieldNamespaces,
+		})
+	default:
+		return w.NewWebhookSubscription(ctx, spec.Topic, model.WebhookSubscriptionInput{
+			CallbackURL:         &spec.CallbackURL,
+			Format:              &format,
+			Filter:              &filter,
+			IncludeFields:       spec.IncludeFields,
+			MetafieldNamespaces: spec.MetafieldNamespaces,
+		})
+	}
+}
+
+// updateWebhookSubscription updates the subscription webhookID to spec with the method of its delivery method.
+func (w WebhookServiceOp) updateWebhookSubscription(ctx context.Context, webhookID string, spec *WebhookSpec) (*model.WebhookSubscription, error) {
+	endpoint, err := spec.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	format, filter := spec.format(), spec.Filter
+	switch endpoint.kind {
+	case webhookEndpointEventBridge:
+		return w.UpdateEventBridgeWebhookSubscription(ctx, webhookID, model.EventBridgeWebhookSubscriptionInput{
+			Arn:                 &spec.Arn,
+			Format:              &format,
+			Filter:              &filter,
+			IncludeFields:       spec.IncludeFields,
+			MetafieldNamespaces: spec.MetafieldNamespaces,
+		})
+	case webhookEndpointPubSub:
+		return w.UpdatePubSubWebhookSubscription(ctx, webhookID, model.PubSubWebhookSubscriptionInput{
+			PubSubProject:       spec.PubSubProject,
+			PubSubTopic:         spec.PubSubTopic,
+			Format:              &format,
+			Filter:              &filter,
+			IncludeFields:       spec.IncludeFields,
+			MetafieldNamespaces: spec.MetafieldNamespaces,
+		})
+	default:
+		return w.UpdateWebhookSubscription(ctx, webhookID, model.WebhookSubscriptionInput{
+			CallbackURL:         &spec.CallbackURL,
+			Format:              &format,
+			Filter:              &filter,
+			IncludeFields:       spec.IncludeFields,
+			MetafieldNamespaces: spec.MetafieldNamespaces,
+		})
+	}
+}
+
+// existingWebhookSubscription returns the subscription of the topic and endpoint of spec, nil if there is none.
+func (w WebhookServiceOp) existingWebhookSubscription(ctx context.Context, spec *WebhookSpec) (*model.WebhookSubscription, error) {
+	endpoint, err := spec.endpoint()
+	if err != nil {
+		return nil, err
+	}
+	subs, err := w.ListWebhookSubscriptions(ctx, []model.WebhookSubscriptionTopic{spec.Topic})
+	if err != nil {
+		return nil, fmt.Errorf("list webhook subscriptions: %w", err)
+	}
+	for _, sub := range subs {
+		if subscriptionEndpoint(sub) == endpoint {
+			return sub, nil
+		}
+	}
+	return nil, nil
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}